@@ -0,0 +1,86 @@
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/doctor"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/spf13/cobra"
+)
+
+func NewDoctorCmd() *cobra.Command {
+	var (
+		devices []string
+		asJSON  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the problems keeping the daemon from managing the disks",
+		Long: `Check the tools, privileges and kernel interfaces the daemon relies on, that
+the power mode of each disk can be queried without waking it, and that no
+other program manages the disk timers. Exits non-zero if a check fails.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if devices, err = hw.ResolveDevices(devices); err != nil {
+				return err
+			}
+			candidates, err := hw.Discover(fileCfg.Discovery, fileCfg.Quirks...)
+			if err != nil {
+				return err
+			}
+			disks := hw.Selected(candidates)
+			if len(devices) > 0 {
+				disks = nil
+				for _, dev := range devices {
+					disk := hw.Disk{Path: dev, Transport: hw.TransportATA}
+					if i := slices.IndexFunc(candidates, func(c hw.Candidate) bool { return c.Path == dev }); i >= 0 {
+						disk = candidates[i].Disk
+					}
+					disks = append(disks, disk)
+				}
+			}
+
+			findings := doctor.New(hw.NewHDDControl(fileCfg.Quirks...)).Check(disks, len(fileCfg.EPC) > 0)
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(findings); err != nil {
+					return err
+				}
+			} else {
+				writeFindings(cmd, findings)
+			}
+			if doctor.Failed(findings) {
+				// the findings tell what is wrong, not the usage
+				cmd.SilenceUsage = true
+				return fmt.Errorf("doctor found problems keeping the daemon from working")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&devices, "devices", "D", nil, "specific devices to check (e.g. /dev/sda,/dev/sdb); if not set, the disks auto-discovery selects")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON instead of text")
+	return cmd
+}
+
+// writeFindings writes one line per finding, followed by its hint.
+func writeFindings(cmd *cobra.Command, findings []doctor.Finding) {
+	w := cmd.OutOrStdout()
+	counts := make(map[doctor.Severity]int)
+	for _, f := range findings {
+		counts[f.Severity]++
+		fmt.Fprintf(w, "[%-4s] %s: %s\n", f.Severity, f.Check, f.Message)
+		if f.Hint != "" {
+			fmt.Fprintf(w, "       -> %s\n", f.Hint)
+		}
+	}
+	fmt.Fprintf(w, "%d ok, %d warnings, %d failures\n", counts[doctor.OK], counts[doctor.Warn], counts[doctor.Fail])
+}
@@ -0,0 +1,103 @@
+package epc
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewEPCCmd() *cobra.Command {
+	var (
+		set     string
+		disable bool
+		force   bool
+		dryRun  bool
+		devices []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "epc",
+		Short: "Show or set Extended Power Conditions timers of mechanical disks",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if devices, err = hw.ResolveDevices(devices); err != nil {
+				return err
+			}
+			var controller hw.HDDControl = hw.NewSafeHDDControl(hw.NewHDDControl(fileCfg.Quirks...), force)
+			if dryRun {
+				controller = hw.NewDryRunHDDControl(controller)
+			}
+
+			var timers hw.EPCTimers
+			switch {
+			case set != "" && disable:
+				return fmt.Errorf("--set and --disable are mutually exclusive")
+			case set != "":
+				if timers, err = hw.ParseEPCTimers(set); err != nil {
+					return err
+				}
+			case !disable:
+				return show(cmd, controller, devices)
+			}
+
+			hasError := false
+			for _, dev := range devices {
+				if err := controller.SetEPC(dev, timers); err != nil {
+					logrus.Errorf("failed to set epc on %s: %v", dev, err)
+					hasError = true
+				} else {
+					logrus.Infof("set epc %s on %s", timers, dev)
+				}
+			}
+			if hasError {
+				return fmt.Errorf("failed to set epc on one or more devices")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&set, "set", "", "enable EPC with the given timers, e.g. idle_b=2m,standby_z=30m; unlisted conditions are disabled")
+	cmd.Flags().BoolVar(&disable, "disable", false, "disable EPC")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "issue commands even on disks in standby or whose state cannot be verified, which may spin them up")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do not change the timers, only log actions")
+	cmd.Flags().StringSliceVarP(&devices, "devices", "D", nil, "specific devices (e.g. /dev/sda,/dev/sdb) [required]")
+	// nolint:errcheck
+	cmd.MarkFlagRequired("devices")
+	return cmd
+}
+
+// show prints the EPC timers of each device.
+func show(cmd *cobra.Command, controller hw.HDDControl, devices []string) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tEPC")
+	hasError := false
+	for _, dev := range devices {
+		timers, err := controller.GetEPC(dev)
+		switch {
+		case errors.Is(err, hw.ErrEPCUnsupported):
+			fmt.Fprintf(w, "%s\tunsupported\n", dev)
+		case errors.Is(err, hw.ErrWouldWake):
+			fmt.Fprintf(w, "%s\tnot queried, disk is spun down (use --force)\n", dev)
+		case err != nil:
+			logrus.Errorf("failed to get epc of %s: %v", dev, err)
+			hasError = true
+		default:
+			fmt.Fprintf(w, "%s\t%s\n", dev, timers)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if hasError {
+		return fmt.Errorf("failed to get epc of one or more devices")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package inhibit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewInhibitCmd() *cobra.Command {
+	var (
+		socket  string
+		devices []string
+		ttl     time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inhibit [flags] -- command [args...]",
+		Short: "Keep disks spinning while a command runs, through a lease of the running daemon",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if ttl <= 0 || ttl > daemon.MaxLeaseTTL {
+				return fmt.Errorf("invalid ttl %s: must be in (0, %s]", ttl, daemon.MaxLeaseTTL)
+			}
+			devices, err := hw.ResolveDevices(devices)
+			if err != nil {
+				return err
+			}
+			client := daemon.NewClient(socket)
+			lease, err := client.Acquire(devices, ttl, strings.Join(args, " "))
+			if err != nil {
+				return fmt.Errorf("failed to acquire lease: %w", err)
+			}
+			logrus.Infof("lease %s holds %v", lease.ID, lease.Devices)
+
+			done := make(chan struct{})
+			renewed := make(chan struct{})
+			go func() {
+				defer close(renewed)
+				renew(client, lease.ID, ttl, done)
+			}()
+
+			err = run(args)
+			close(done)
+			<-renewed
+			if rerr := client.Release(lease.ID); rerr != nil {
+				logrus.Warnf("failed to release lease %s, it expires in %s: %v", lease.ID, ttl, rerr)
+			}
+
+			var exit *exec.ExitError
+			if errors.As(err, &exit) {
+				// exit like the command did
+				os.Exit(max(exit.ExitCode(), 1))
+			}
+			return err
+		},
+	}
+
+	// flags after the command are its own
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringVar(&socket, "socket", daemon.DefaultSocket, "control socket of the daemon")
+	cmd.Flags().StringSliceVarP(&devices, "device", "D", nil, "device to keep spinning, repeatable or comma separated (e.g. /dev/sda,/dev/sdb); if not set, all monitored devices")
+	cmd.Flags().StringSliceVar(&devices, "devices", nil, "devices to keep spinning")
+	// nolint:errcheck
+	cmd.Flags().MarkDeprecated("devices", "use --device instead")
+	cmd.Flags().DurationVar(&ttl, "ttl", time.Minute, "lease time to live, renewed at a third of it while the command runs")
+	return cmd
+}
+
+// renew renews the lease id until done is closed.
+func renew(client *daemon.Client, id string, ttl time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := client.Renew(id, ttl); err != nil {
+				logrus.Warnf("failed to renew lease %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// run runs the command of args attached to the terminal, forwarding the
+// termination signals to it.
+func run(args []string) error {
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	if err := child.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range sigs {
+			// nolint:errcheck
+			child.Process.Signal(sig)
+		}
+	}()
+	return child.Wait()
+}
@@ -0,0 +1,164 @@
+package list
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// drive is a discovered disk with what can be read of it without waking it.
+type drive struct {
+	hw.Candidate
+	// State is the power mode, empty when it was not queried
+	State string `json:"state,omitempty"`
+	// Identity is empty when the disk could not be identified without
+	// waking it
+	Identity *hw.Identity `json:"identity,omitempty"`
+	// Policy is the power policy the daemon would arm, empty if unmanaged
+	Policy string `json:"policy,omitempty"`
+	// Mounts and EPC are only read by inspect
+	Mounts []string `json:"mounts,omitempty"`
+	EPC    string   `json:"epc,omitempty"`
+	// Errors are the queries that failed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// stateUnverifiable is the state of disks whose power mode cannot be queried
+// safely, see hw.Quirk.
+const stateUnverifiable = "unverifiable"
+
+// prober reads the state of discovered disks through a SafeHDDControl, so
+// that a disk in standby is never spun up.
+type prober struct {
+	controller hw.HDDControl
+	// epc and standby are the power policy the daemon would arm now
+	epc     *hw.EPCTimers
+	standby int
+}
+
+// probe queries the power mode and identity of c, which holds its APM
+// level, and its EPC timers when detailed.
+func (p prober) probe(c hw.Candidate, detailed bool) drive {
+	d := p.query(c, detailed)
+	d.Policy = p.policy(d)
+	return d
+}
+
+func (p prober) query(c hw.Candidate, detailed bool) drive {
+	d := drive{Candidate: c}
+	if c.Bus() == hw.TransportNVMe || c.Reason == "no device node" {
+		// no ATA or SCSI power modes to query
+		return d
+	}
+
+	state, err := p.controller.GetState(c.Path)
+	switch {
+	case errors.Is(err, hw.ErrStateUnavailable):
+		// the daemon sends no command to a disk it cannot verify is spinning
+		d.State = stateUnverifiable
+		if d.Selected {
+			d.Selected, d.Reason = false, "power mode unverifiable"
+		}
+	case err != nil:
+		// the safety layer refuses any other command on an unverified disk
+		d.failed("power mode", err)
+		return d
+	default:
+		d.State = state
+	}
+
+	id, err := p.controller.Identify(c.Path)
+	switch {
+	case errors.Is(err, hw.ErrWouldWake):
+		logrus.Debugf("%s not identified: %v", c.Path, err)
+		return d
+	case err != nil:
+		d.failed("identify", err)
+		return d
+	}
+	d.Identity = &id
+	if d.Serial == "" {
+		d.Serial = id.Serial
+	}
+	if !detailed {
+		return d
+	}
+
+	if id.EPC.Supported {
+		timers, err := p.controller.GetEPC(c.Path)
+		if err != nil {
+			d.failed("EPC timers", err)
+		} else {
+			d.EPC = timers.String()
+		}
+	}
+	return d
+}
+
+func (d *drive) failed(query string, err error) {
+	logrus.Debugf("failed to read %s of %s: %v", query, d.Path, err)
+	d.Errors = append(d.Errors, fmt.Sprintf("%s: %v", query, err))
+}
+
+// policy tells what the daemon would arm on d at its scheduled window: the
+// EPC timers on drives supporting them, the standby timer otherwise.
+func (p prober) policy(d drive) string {
+	if !d.Selected {
+		return ""
+	}
+	standby := fmt.Sprintf("standby %d (%s)", p.standby, hw.StandbyDuration(p.standby))
+	switch {
+	case p.epc == nil:
+		return standby
+	case d.Identity == nil:
+		return "epc " + p.epc.String() + " if supported, else " + standby
+	case d.Identity.EPC.Supported:
+		return "epc " + p.epc.String()
+	default:
+		return standby
+	}
+}
+
+// features lists the optional feature sets supported by d, "?" when it
+// could not be identified.
+func (d drive) features() string {
+	if d.Identity == nil {
+		return "?"
+	}
+	var names []string
+	for _, f := range []struct {
+		name string
+		hw.Feature
+	}{{"APM", d.Identity.APM}, {"EPC", d.Identity.EPC}, {"SCT", d.Identity.SCT}} {
+		if f.Supported {
+			names = append(names, f.name)
+		}
+	}
+	return orDash(strings.Join(names, ","))
+}
+
+// transport is the bus of d, with the bridge of USB disks.
+func (d drive) transport() string {
+	if d.USBID != "" {
+		return fmt.Sprintf("usb %s (%s)", d.USBID, d.Transport)
+	}
+	return d.Bus()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
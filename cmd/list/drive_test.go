@@ -0,0 +1,192 @@
+package list
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_probe(t *testing.T) {
+	ioErr := errors.New("Input/output error")
+	sata := hw.Candidate{Disk: hw.Disk{Path: "/dev/sda", Transport: hw.TransportATA}, Name: "sda", Selected: true}
+	epc := hw.EPCTimers{StandbyZ: 30 * time.Minute}
+	identity := hw.Identity{Serial: "WD-123", PM: hw.Feature{Supported: true, Enabled: true}}
+	epcIdentity := identity
+	epcIdentity.EPC = hw.Feature{Supported: true, Enabled: true}
+
+	tests := []struct {
+		name      string
+		candidate hw.Candidate
+		epc       *hw.EPCTimers
+		detailed  bool
+		setup     func(*hw.MockHDDControl)
+		want      drive
+		// unmanaged is the reason the disk turns out unmanaged
+		unmanaged string
+	}{
+		{
+			name:      "nvme is not queried",
+			candidate: hw.Candidate{Disk: hw.Disk{Path: "/dev/nvme0n1", Transport: hw.TransportNVMe}, Name: "nvme0n1", Selected: true},
+			want:      drive{Policy: "standby 120 (10m0s)"},
+		},
+		{
+			name:      "unmanaged disk has no policy",
+			candidate: hw.Candidate{Disk: hw.Disk{Path: "/dev/sda"}, Name: "sda", Reason: "no device node"},
+		},
+		{
+			name:      "state query error",
+			candidate: sata,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return("", ioErr).Once()
+			},
+			want: drive{Policy: "standby 120 (10m0s)", Errors: []string{"power mode: Input/output error"}},
+		},
+		{
+			name:      "unverifiable state, spun down disk not identified",
+			candidate: sata,
+			epc:       &epc,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return("", hw.ErrStateUnavailable).Once()
+				m.EXPECT().Identify("/dev/sda").Return(hw.Identity{}, hw.ErrWouldWake).Once()
+			},
+			want:      drive{State: stateUnverifiable},
+			unmanaged: "power mode unverifiable",
+		},
+		{
+			name:      "identify error",
+			candidate: sata,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(hw.Identity{}, ioErr).Once()
+			},
+			want: drive{State: hw.DriveStateActive, Policy: "standby 120 (10m0s)", Errors: []string{"identify: Input/output error"}},
+		},
+		{
+			name:      "EPC unsupported gets the standby timer",
+			candidate: sata,
+			epc:       &epc,
+			detailed:  true,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(identity, nil).Once()
+			},
+			want: drive{State: hw.DriveStateActive, Identity: &identity, Policy: "standby 120 (10m0s)"},
+		},
+		{
+			name:      "EPC timers are only read when detailed",
+			candidate: sata,
+			epc:       &epc,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(epcIdentity, nil).Once()
+			},
+			want: drive{State: hw.DriveStateActive, Identity: &epcIdentity, Policy: "epc standby_z=30m0s"},
+		},
+		{
+			name:      "EPC supported",
+			candidate: sata,
+			epc:       &epc,
+			detailed:  true,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(epcIdentity, nil).Once()
+				m.EXPECT().GetEPC("/dev/sda").Return(hw.EPCTimers{IdleB: 2 * time.Minute}, nil).Once()
+			},
+			want: drive{State: hw.DriveStateActive, Identity: &epcIdentity, EPC: "idle_b=2m0s", Policy: "epc standby_z=30m0s"},
+		},
+		{
+			name:      "EPC timers query error",
+			candidate: sata,
+			detailed:  true,
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(epcIdentity, nil).Once()
+				m.EXPECT().GetEPC("/dev/sda").Return(hw.EPCTimers{}, ioErr).Once()
+			},
+			want: drive{State: hw.DriveStateActive, Identity: &epcIdentity, Policy: "standby 120 (10m0s)", Errors: []string{"EPC timers: Input/output error"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := hw.NewMockHDDControl(t)
+			if tt.setup != nil {
+				tt.setup(mockCtrl)
+			}
+			p := prober{controller: mockCtrl, epc: tt.epc, standby: 120}
+			tt.want.Candidate = tt.candidate
+			if tt.unmanaged != "" {
+				tt.want.Selected, tt.want.Reason = false, tt.unmanaged
+			}
+			if tt.want.Identity != nil {
+				tt.want.Serial = tt.want.Identity.Serial
+			}
+			require.Equal(t, tt.want, p.probe(tt.candidate, tt.detailed))
+		})
+	}
+}
+
+func TestWriteDetails(t *testing.T) {
+	// details parses the "field: value" lines written for d
+	details := func(d drive) map[string]string {
+		b := new(strings.Builder)
+		w := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+		writeDetails(w, d)
+		require.NoError(t, w.Flush())
+		fields := make(map[string]string)
+		for line := range strings.Lines(b.String()) {
+			name, value, ok := strings.Cut(line, ":")
+			require.True(t, ok, line)
+			fields[name] = strings.TrimSpace(value)
+		}
+		return fields
+	}
+
+	spunDown := drive{
+		Candidate: hw.Candidate{Disk: hw.Disk{Path: "/dev/sdb", Transport: hw.TransportATA}, Name: "sdb", Selected: true, Reason: "rotational"},
+		State:     hw.DriveStateStandby,
+		Policy:    "standby 120 (10m0s)",
+	}
+	fields := details(spunDown)
+	require.Equal(t, "standby (not identified to keep it spun down)", fields["Power mode"])
+	require.Equal(t, "?", fields["Standby timer"])
+	require.Equal(t, "?", fields["EPC"])
+	require.Equal(t, "-", fields["Firmware"])
+	require.Equal(t, "yes, rotational", fields["Managed"])
+	require.Equal(t, "standby 120 (10m0s)", fields["Policy"])
+
+	identified := drive{
+		Candidate: hw.Candidate{
+			Disk: hw.Disk{Path: "/dev/sdc", Transport: hw.TransportATA, USBID: "152d:0578", Quirk: &hw.Quirk{Name: "jmicron"}},
+			Name: "sdc", Reason: "excluded",
+		},
+		State: hw.DriveStateActive,
+		Identity: &hw.Identity{
+			Firmware:     "81.00A81",
+			PM:           hw.Feature{Supported: true, Enabled: true},
+			StandbyTimer: "vendor specific",
+			APM:          hw.Feature{Supported: true, Enabled: true},
+			APMLevel:     128,
+			EPC:          hw.Feature{Supported: true},
+		},
+		EPC:    "idle_b=2m0s",
+		Mounts: []string{"/srv", "/mnt/data"},
+		Errors: []string{"EPC timers: Input/output error"},
+	}
+	fields = details(identified)
+	require.Equal(t, hw.DriveStateActive, fields["Power mode"])
+	require.Equal(t, "enabled, values vendor specific", fields["Standby timer"])
+	require.Equal(t, "enabled, level 128", fields["APM"])
+	require.Equal(t, "disabled, idle_b=2m0s", fields["EPC"])
+	require.Equal(t, "unsupported", fields["SCT"])
+	require.Equal(t, "81.00A81", fields["Firmware"])
+	require.Equal(t, "usb 152d:0578 (ata), quirk jmicron", fields["Transport"])
+	require.Equal(t, "/srv, /mnt/data", fields["Mounts"])
+	require.Equal(t, "no, excluded", fields["Managed"])
+	require.Equal(t, "-", fields["Policy"])
+	require.Equal(t, "EPC timers: Input/output error", fields["Error"])
+}
@@ -0,0 +1,123 @@
+package list
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/spf13/cobra"
+)
+
+func NewInspectCmd() *cobra.Command {
+	var (
+		standby int
+		asJSON  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inspect <device>...",
+		Short: "Show everything known about disks without waking them",
+		Long: `Show the identification, power mode, APM level, EPC timers, mount points
+and the policy the daemon would arm of each disk. A device may be given as a
+/dev path, a mount point or a filesystem tag like UUID=... .`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			devices, err := hw.ResolveDevices(args)
+			if err != nil {
+				return err
+			}
+			candidates, err := hw.Discover(fileCfg.Discovery, fileCfg.Quirks...)
+			if err != nil {
+				return err
+			}
+
+			p := newProber(fileCfg, standby)
+			fsys := os.DirFS("/")
+			drives := make([]drive, 0, len(devices))
+			for _, dev := range devices {
+				i := slices.IndexFunc(candidates, func(c hw.Candidate) bool { return c.Path == dev })
+				if i < 0 {
+					return fmt.Errorf("%s: not a disk of this host", dev)
+				}
+				d := p.probe(candidates[i], true)
+				d.Mounts = hw.MountPoints(fsys, dev)
+				drives = append(drives, d)
+			}
+			if asJSON {
+				return writeJSON(cmd.OutOrStdout(), drives)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			for i, d := range drives {
+				if i > 0 {
+					fmt.Fprintln(w)
+				}
+				writeDetails(w, d)
+			}
+			return w.Flush()
+		},
+	}
+
+	addFlags(cmd, &standby, &asJSON)
+	return cmd
+}
+
+// writeDetails writes one "field: value" line per property of d.
+func writeDetails(w *tabwriter.Writer, d drive) {
+	firmware := "-"
+	pm, apm, epc, sct := "?", "?", "?", "?"
+	if id := d.Identity; id != nil {
+		firmware = orDash(id.Firmware)
+		pm, apm, epc, sct = id.PM.String(), id.APM.String(), id.EPC.String(), id.SCT.String()
+		if id.StandbyTimer != "" {
+			pm += ", values " + id.StandbyTimer
+		}
+		if id.APMLevel > 0 && id.APMLevel != hw.APMDisabled {
+			apm += fmt.Sprintf(", level %d", id.APMLevel)
+		}
+		if d.EPC != "" {
+			epc += ", " + d.EPC
+		}
+	}
+	transport := d.transport()
+	if d.Quirk != nil {
+		transport += ", quirk " + d.Quirk.Name
+	}
+	managed := "no"
+	if d.Selected {
+		managed = "yes"
+	}
+	state := orDash(d.State)
+	if d.Identity == nil && hw.IsSpunDown(d.State) {
+		state += " (not identified to keep it spun down)"
+	}
+
+	fmt.Fprintf(w, "Device:\t%s\n", d.Path)
+	fmt.Fprintf(w, "Model:\t%s\n", orDash(d.Model))
+	fmt.Fprintf(w, "Serial:\t%s\n", orDash(d.Serial))
+	fmt.Fprintf(w, "Firmware:\t%s\n", firmware)
+	fmt.Fprintf(w, "Size:\t%s (%d bytes)\n", hw.FormatSize(d.Size), d.Size)
+	fmt.Fprintf(w, "Transport:\t%s\n", transport)
+	fmt.Fprintf(w, "Rotational:\t%v\n", d.Rotational)
+	fmt.Fprintf(w, "Root:\t%v\n", d.Root)
+	fmt.Fprintf(w, "IDs:\t%s\n", orDash(strings.Join(d.IDs, ", ")))
+	fmt.Fprintf(w, "Mounts:\t%s\n", orDash(strings.Join(d.Mounts, ", ")))
+	fmt.Fprintf(w, "Power mode:\t%s\n", state)
+	fmt.Fprintf(w, "Standby timer:\t%s\n", pm)
+	fmt.Fprintf(w, "APM:\t%s\n", apm)
+	fmt.Fprintf(w, "EPC:\t%s\n", epc)
+	fmt.Fprintf(w, "SCT:\t%s\n", sct)
+	fmt.Fprintf(w, "Managed:\t%s, %s\n", managed, d.Reason)
+	fmt.Fprintf(w, "Policy:\t%s\n", orDash(d.Policy))
+	for _, err := range d.Errors {
+		fmt.Fprintf(w, "Error:\t%s\n", err)
+	}
+}
@@ -0,0 +1,75 @@
+package list
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/spf13/cobra"
+)
+
+func NewListCmd() *cobra.Command {
+	var (
+		standby int
+		asJSON  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the disks found by auto-discovery and whether the daemon would manage them",
+		Long: `List every disk found by auto-discovery with its power mode, optional
+feature sets and the policy the daemon would arm on it. Disks in standby are
+never spun up: their features are shown as "?".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			candidates, err := hw.Discover(fileCfg.Discovery, fileCfg.Quirks...)
+			if err != nil {
+				return err
+			}
+			p := newProber(fileCfg, standby)
+			drives := make([]drive, 0, len(candidates))
+			for _, c := range candidates {
+				drives = append(drives, p.probe(c, false))
+			}
+			if asJSON {
+				return writeJSON(cmd.OutOrStdout(), drives)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "DEVICE\tMODEL\tSERIAL\tSIZE\tTRANSPORT\tROTATIONAL\tSTATE\tFEATURES\tMANAGED\tREASON\tPOLICY")
+			for _, d := range drives {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%s\t%s\t%v\t%s\t%s\n",
+					d.Path, orDash(d.Model), orDash(d.Serial), hw.FormatSize(d.Size), d.transport(), d.Rotational,
+					orDash(d.State), d.features(), d.Selected, d.Reason, orDash(d.Policy))
+			}
+			return w.Flush()
+		},
+	}
+
+	addFlags(cmd, &standby, &asJSON)
+	return cmd
+}
+
+// newProber returns a prober that never wakes a disk, reporting the policy
+// of fileCfg with the standby timer value.
+func newProber(fileCfg *config.Config, standby int) prober {
+	p := prober{
+		controller: hw.NewSafeHDDControl(hw.NewHDDControl(fileCfg.Quirks...), false),
+		standby:    standby,
+	}
+	if epc := daemon.EPCPolicy(fileCfg.EPC, time.Now()); epc.Enabled() {
+		p.epc = &epc
+	}
+	return p
+}
+
+func addFlags(cmd *cobra.Command, standby *int, asJSON *bool) {
+	cmd.Flags().IntVarP(standby, "standby", "s", 120, "standby timeout value the daemon runs with, to report its policy")
+	cmd.Flags().BoolVar(asJSON, "json", false, "print JSON instead of a table")
+}
@@ -1,15 +1,20 @@
 package run
 
 import (
+	"fmt"
+	"math/rand/v2"
 	"time"
 
+	"github.com/chain710/hd-smart-idle/internal/config"
 	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/policy"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 func NewRunCmd() *cobra.Command {
-	cron := &daemon.CronExpr{}
+	cron := &config.CronExpr{}
 	// Set default value: 22:00
 	if err := cron.Parse("22 00"); err != nil {
 		panic("cron.Parse should NOT fail!")
@@ -20,21 +25,73 @@ func NewRunCmd() *cobra.Command {
 		pollInterval time.Duration
 		dryRun       bool
 		devices      []string
+		adaptive     bool
+		adaptiveCfg  policy.AdaptiveConfig
+		backend      string
+		simIO        time.Duration
+		socket       string
+		groups       bool
+		attribute    bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run the daemon",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if devices, err = hw.ResolveDevices(devices); err != nil {
+				return err
+			}
+			if err := fileCfg.ResolveDevices(hw.ResolveDevices); err != nil {
+				return err
+			}
 			logrus.Infof("starting hd-smart-idle (schedule=%s standby=%d poll=%s dry-run=%v)", cron, standbyValue, pollInterval, dryRun)
 
-			d, err := daemon.New(daemon.Config{
+			cfg := daemon.Config{
 				Devices:      devices,
 				PollInterval: pollInterval,
 				Cron:         cron,
 				StandbyValue: standbyValue,
 				DryRun:       dryRun,
-			})
+				Quirks:       fileCfg.Quirks,
+				Filters:      fileCfg.Discovery,
+				EPC:          fileCfg.EPC,
+				APM:          fileCfg.APM,
+				Wake:         fileCfg.Wake,
+				Inhibit:      fileCfg.Inhibit,
+				Power:        fileCfg.Power,
+				Stagger:      fileCfg.Stagger,
+				Retry:        fileCfg.Retry,
+				Drift:        fileCfg.Drift,
+				Socket:       socket,
+				Attribution:  attribute,
+			}
+			if adaptive {
+				cfg.Adaptive = &adaptiveCfg
+			}
+			if groups {
+				if cfg.Arrays, err = hw.DiscoverArrays(); err != nil {
+					logrus.Warnf("array discovery incomplete: %v", err)
+				}
+			}
+			switch backend {
+			case "hdparm":
+			case "sim":
+				if len(cfg.Devices) == 0 {
+					cfg.Devices = []string{"/dev/sda", "/dev/sdb"}
+				}
+				sim := hw.NewSimHDDControl(time.Now, cfg.Devices...)
+				if simIO > 0 {
+					go simulateIO(sim, cfg.Devices, simIO)
+				}
+				cfg.Controller = sim
+			default:
+				return fmt.Errorf("unknown backend %q", backend)
+			}
+			d, err := daemon.New(cfg)
 			if err != nil {
 				logrus.Fatalf("failed to create daemon: %v", err)
 				return err
@@ -50,6 +107,33 @@ func NewRunCmd() *cobra.Command {
 	cmd.Flags().DurationVarP(&pollInterval, "poll", "p", 10*time.Second, "poll interval for checking disk state")
 	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do not issue standby, only log actions")
 	cmd.Flags().StringSliceVarP(&devices, "devices", "D", nil, "specific devices to monitor (e.g. /dev/sda,/dev/sdb); if not set, auto-detect all rotational disks")
+	cmd.Flags().BoolVar(&adaptive, "adaptive", false, "learn per-device standby timeout from observed access gaps instead of using --standby")
+	cmd.Flags().Float64Var(&adaptiveCfg.PowerWeight, "adaptive-weight", 0.5, "adaptive policy weighting in [0,1]: 0 minimizes spin-ups, 1 minimizes spinning time")
+	cmd.Flags().IntVar(&adaptiveCfg.MinSamples, "adaptive-min-samples", 10, "access gaps required before the adaptive policy overrides --standby")
+	cmd.Flags().BoolVar(&groups, "groups", true, "manage the disks of each md RAID, ZFS pool and btrfs filesystem as a group")
+	cmd.Flags().BoolVar(&attribute, "attribute-wakes", false, "log the processes that woke each disk up, with fanotify when permitted or /proc/*/io")
+	cmd.Flags().StringVar(&socket, "socket", daemon.DefaultSocket, "control socket serving status and metrics; empty disables it")
+	// hidden: exercise the daemon end-to-end on machines without disks
+	cmd.Flags().StringVar(&backend, "backend", "hdparm", "hardware backend: hdparm|sim")
+	cmd.Flags().DurationVar(&simIO, "sim-io", 0, "with --backend sim, mean interval between simulated I/O on a random device")
+	// nolint:errcheck
+	cmd.Flags().MarkHidden("backend")
+	// nolint:errcheck
+	cmd.Flags().MarkHidden("sim-io")
 
 	return cmd
 }
+
+// simulateIO issues I/O on a random simulated device at exponentially
+// distributed intervals with the given mean.
+func simulateIO(sim *hw.SimHDDControl, devs []string, mean time.Duration) {
+	for {
+		time.Sleep(time.Duration(rand.ExpFloat64() * float64(mean)))
+		dev := devs[rand.IntN(len(devs))]
+		if err := sim.Access(dev); err != nil {
+			logrus.Debugf("sim: access %s error: %v", dev, err)
+		} else {
+			logrus.Debugf("sim: access %s", dev)
+		}
+	}
+}
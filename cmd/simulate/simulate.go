@@ -0,0 +1,128 @@
+package simulate
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/policy"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/chain710/hd-smart-idle/internal/simulate"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewSimulateCmd() *cobra.Command {
+	cron := &config.CronExpr{}
+	// Set default value: 22:00
+	if err := cron.Parse("22 00"); err != nil {
+		panic("cron.Parse should NOT fail!")
+	}
+
+	var (
+		tracePath     string
+		standbyValues []int
+		pollInterval  time.Duration
+		adaptive      bool
+		adaptiveCfg   policy.AdaptiveConfig
+		start, end    string
+		model         = power.Classes[power.ClassDesktop]
+		verbose       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Replay a recorded I/O trace against candidate policies and report predicted spin-ups and energy",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(standbyValues) == 0 {
+				return fmt.Errorf("no standby value to evaluate")
+			}
+			f, err := os.Open(tracePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			trace, err := simulate.ParseTrace(f)
+			if err != nil {
+				return fmt.Errorf("failed to parse trace %s: %w", tracePath, err)
+			}
+			if len(trace) == 0 {
+				return fmt.Errorf("empty trace %s", tracePath)
+			}
+
+			from, to := trace[0].Time, trace[len(trace)-1].Time
+			if start != "" {
+				if from, err = time.Parse(time.RFC3339, start); err != nil {
+					return fmt.Errorf("invalid start: %w", err)
+				}
+			}
+			if end != "" {
+				if to, err = time.Parse(time.RFC3339, end); err != nil {
+					return fmt.Errorf("invalid end: %w", err)
+				}
+			}
+			if !to.After(from) {
+				return fmt.Errorf("end %s is not after start %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+			}
+
+			var policies []simulate.Policy
+			for _, v := range standbyValues {
+				policies = append(policies, simulate.Policy{
+					Name:   fmt.Sprintf("standby=%d", v),
+					Config: daemon.Config{PollInterval: pollInterval, Cron: cron, StandbyValue: v},
+				})
+			}
+			if adaptive {
+				policies = append(policies, simulate.Policy{
+					Name: fmt.Sprintf("adaptive(weight=%.2f)", adaptiveCfg.PowerWeight),
+					Config: daemon.Config{
+						PollInterval: pollInterval,
+						Cron:         cron,
+						StandbyValue: standbyValues[0],
+						Adaptive:     &adaptiveCfg,
+					},
+				})
+			}
+
+			// the daemon logs every transition; keep the report readable
+			if !verbose && logrus.GetLevel() > logrus.WarnLevel {
+				logrus.SetLevel(logrus.WarnLevel)
+			}
+
+			devs := trace.Devices()
+			fmt.Fprintf(cmd.OutOrStdout(), "replaying %d events on %v from %s to %s\n\n", len(trace), devs, from.Format(time.RFC3339), to.Format(time.RFC3339))
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "POLICY\tSPIN-UPS\tACTIVE(h)\tSTANDBY(h)\tENERGY(kWh)")
+			hours := to.Sub(from).Hours() * float64(len(devs))
+			fmt.Fprintf(w, "always-on\t0\t%.1f\t0.0\t%.3f\n", hours, model.ActiveWatts*hours/1000)
+			for _, p := range policies {
+				report, err := simulate.Run(trace, p, from, to, model)
+				if err != nil {
+					return fmt.Errorf("simulate %s: %w", p.Name, err)
+				}
+				fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%.3f\n", report.Policy, report.SpinUps, report.ActiveHours, report.StandbyHours, report.EnergyKWh)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&tracePath, "trace", "f", "", "trace file with one '<timestamp> <device> [io|active|standby]' event per line [required]")
+	cmd.Flags().VarP(cron, "time", "t", "daily time (hour min) to set standby timeout")
+	cmd.Flags().IntSliceVarP(&standbyValues, "standby", "s", []int{120}, "standby timeout values to evaluate, one policy each")
+	cmd.Flags().DurationVarP(&pollInterval, "poll", "p", 10*time.Second, "simulated poll interval")
+	cmd.Flags().BoolVar(&adaptive, "adaptive", false, "also evaluate the adaptive policy")
+	cmd.Flags().Float64Var(&adaptiveCfg.PowerWeight, "adaptive-weight", 0.5, "adaptive policy weighting in [0,1]: 0 minimizes spin-ups, 1 minimizes spinning time")
+	cmd.Flags().IntVar(&adaptiveCfg.MinSamples, "adaptive-min-samples", 10, "access gaps required before the adaptive policy overrides --standby")
+	cmd.Flags().StringVar(&start, "start", "", "simulation start (RFC3339); defaults to the first event")
+	cmd.Flags().StringVar(&end, "end", "", "simulation end (RFC3339); defaults to the last event")
+	cmd.Flags().Float64Var(&model.ActiveWatts, "active-watts", model.ActiveWatts, "drive power while spinning")
+	cmd.Flags().Float64Var(&model.StandbyWatts, "standby-watts", model.StandbyWatts, "drive power in standby")
+	cmd.Flags().Float64Var(&model.SpinUpJoules, "spinup-joules", model.SpinUpJoules, "energy of a single spin-up")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "keep daemon logs of the simulated runs")
+	// nolint:errcheck
+	cmd.MarkFlagRequired("trace")
+	return cmd
+}
@@ -3,6 +3,7 @@ package standby
 import (
 	"fmt"
 
+	"github.com/chain710/hd-smart-idle/internal/config"
 	"github.com/chain710/hd-smart-idle/internal/hw"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -12,6 +13,8 @@ func NewStandbyCmd() *cobra.Command {
 	var (
 		standbyValue int
 		dryRun       bool
+		force        bool
+		now          bool
 		devices      []string
 	)
 
@@ -19,7 +22,14 @@ func NewStandbyCmd() *cobra.Command {
 		Use:   "standby",
 		Short: "Set standby timeout for mechanical disks",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			controller := hw.NewHDDControl()
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if devices, err = hw.ResolveDevices(devices); err != nil {
+				return err
+			}
+			var controller hw.HDDControl = hw.NewSafeHDDControl(hw.NewHDDControl(fileCfg.Quirks...), force)
 			if dryRun {
 				controller = hw.NewDryRunHDDControl(controller)
 			}
@@ -35,6 +45,15 @@ func NewStandbyCmd() *cobra.Command {
 				} else {
 					logrus.Infof("set standby timeout %d on %s", standbyValue, dev)
 				}
+				if !now {
+					continue
+				}
+				if err := controller.StandbyNow(dev); err != nil {
+					logrus.Errorf("failed to spin down %s: %v", dev, err)
+					hasError = true
+				} else {
+					logrus.Infof("spun down %s", dev)
+				}
 			}
 
 			if hasError {
@@ -46,7 +65,9 @@ func NewStandbyCmd() *cobra.Command {
 	}
 
 	cmd.Flags().IntVarP(&standbyValue, "value", "s", 120, "standby timeout value in 5 seconds units (e.g. 120 = 10 minutes)")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "issue commands even on disks in standby or whose state cannot be verified, which may spin them up")
 	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do not issue standby, only log actions")
+	cmd.Flags().BoolVarP(&now, "now", "y", false, "also spin the devices down immediately")
 	cmd.Flags().StringSliceVarP(&devices, "devices", "D", nil, "specific devices to configure (e.g. /dev/sda,/dev/sdb) [required]")
 	// nolint:errcheck
 	cmd.MarkFlagRequired("devices")
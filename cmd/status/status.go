@@ -0,0 +1,162 @@
+package status
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/attribution"
+	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/spf13/cobra"
+)
+
+func NewStatusCmd() *cobra.Command {
+	var (
+		socket  string
+		metrics bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the state and estimated energy of the disks monitored by the running daemon",
+		Long: `Show the state and estimated energy of the disks monitored by the running
+daemon. NOT APPLIED counts the APM levels and EPC timers a drive reported
+differently after setting them, and the standby timers set on drives without
+the Power Management feature set. The standby timer value itself is never read
+back: drives do not report it.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := daemon.NewClient(socket)
+			if metrics {
+				text, err := client.Metrics()
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(cmd.OutOrStdout(), text)
+				return nil
+			}
+			st, err := client.Status()
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "DEVICE\tSTATE\tARMED\tACTIVE\tIDLE\tSTANDBY\tSPIN-UPS\tKWH\tSAVED KWH\tWOULD WAKE\tNOT APPLIED\tHELD BY\tMOUNTS")
+			wouldWake, mismatches := 0, 0
+			for _, dev := range st.Devices {
+				state := dev.State
+				if state == "" {
+					state = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%d\t%d\t%s\t%s\n", dev.Device, state, dev.Armed, usage(dev.Usage), dev.WouldWake, dev.Mismatches, holds(dev.Holds), mounts(dev.Mounts))
+				wouldWake += dev.WouldWake
+				mismatches += dev.Mismatches
+			}
+			fmt.Fprintf(w, "TOTAL\t\t\t%s\t%d\t%d\n", usage(st.Total), wouldWake, mismatches)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if len(st.Groups) > 0 {
+				fmt.Fprintln(cmd.OutOrStdout())
+				w = tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "GROUP\tMEMBERS\tSPINNING\tACTIVE\tIDLE\tSTANDBY\tSPIN-UPS\tKWH\tSAVED KWH")
+				for _, g := range st.Groups {
+					fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", g.Name, strings.Join(g.Members, ","), g.Spinning, len(g.Members), usage(g.Usage))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+			if len(st.Wakes) > 0 {
+				fmt.Fprintln(cmd.OutOrStdout())
+				w = tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "WOKEN AT\tDEVICE\tFROM\tTO\tBY")
+				for _, ev := range st.Wakes {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ev.Time.Local().Format(time.DateTime), ev.Device, ev.From, ev.To, culprits(ev.Culprits))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+			if len(st.Retries) > 0 {
+				fmt.Fprintln(cmd.OutOrStdout())
+				w = tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "RETRY AT\tDEVICE\tCOMMAND\tFAILURES\tERROR")
+				for _, r := range st.Retries {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", r.Due.Local().Format(time.DateTime), r.Device, r.Command, r.Failures, r.Error)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+			for _, dev := range st.Devices {
+				if dev.Degraded != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "\n%s degraded, commands no longer retried: %s\n", dev.Device, dev.Degraded)
+				}
+			}
+			if len(st.Drifts) == 0 {
+				return nil
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout())
+			w = tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "DRIFTED AT\tDEVICE\tSETTING\tFOUND\tWANTED\tREASSERTED\tLIKELY BY")
+			for _, ev := range st.Drifts {
+				likely := "-"
+				if len(ev.Managers) > 0 {
+					likely = strings.Join(ev.Managers, ", ")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%s\n", ev.Time.Local().Format(time.DateTime), ev.Device, ev.Setting, ev.Found, ev.Want, ev.Reasserted, likely)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&socket, "socket", daemon.DefaultSocket, "control socket of the daemon")
+	cmd.Flags().BoolVar(&metrics, "metrics", false, "print the metrics in the Prometheus text format")
+	return cmd
+}
+
+// holds formats the holds of a device.
+func holds(holds []daemon.Hold) string {
+	if len(holds) == 0 {
+		return "-"
+	}
+	var s []string
+	for _, h := range holds {
+		if h.Until.IsZero() {
+			s = append(s, h.Reason)
+		} else {
+			s = append(s, fmt.Sprintf("%s until %s", h.Reason, h.Until.Local().Format(time.TimeOnly)))
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// mounts formats the mount points on a device.
+func mounts(mounts []string) string {
+	if len(mounts) == 0 {
+		return "-"
+	}
+	return strings.Join(mounts, ",")
+}
+
+// culprits formats the processes that woke a device.
+func culprits(culprits []attribution.Culprit) string {
+	if len(culprits) == 0 {
+		return "-"
+	}
+	var s []string
+	for _, c := range culprits {
+		s = append(s, c.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// usage formats the time and energy columns.
+func usage(u power.Usage) string {
+	return fmt.Sprintf("%s\t%s\t%s\t%d\t%.3f\t%.3f",
+		u.Active.Round(time.Second), u.Idle.Round(time.Second), u.Standby.Round(time.Second),
+		u.SpinUps, u.KWh(), u.SavedKWh())
+}
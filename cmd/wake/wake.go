@@ -0,0 +1,69 @@
+package wake
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewWakeCmd() *cobra.Command {
+	var (
+		delay         time.Duration
+		maxConcurrent int
+		dryRun        bool
+		devices       []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wake",
+		Short: "Spin up mechanical disks, staggered to limit the power supply inrush",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFlag(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if devices, err = hw.ResolveDevices(devices); err != nil {
+				return err
+			}
+			stagger := fileCfg.Stagger
+			if cmd.Flags().Changed("delay") {
+				stagger.Delay = delay
+			}
+			if cmd.Flags().Changed("max-concurrent") {
+				stagger.MaxConcurrent = maxConcurrent
+			}
+			if err := stagger.Validate(); err != nil {
+				return err
+			}
+
+			controller := hw.NewHDDControl(fileCfg.Quirks...)
+			if dryRun {
+				controller = hw.NewDryRunHDDControl(controller)
+			}
+
+			errs := daemon.WakeStaggered(controller, stagger, devices, time.Sleep)
+			for _, dev := range devices {
+				if err, ok := errs[dev]; ok {
+					logrus.Errorf("failed to wake %s: %v", dev, err)
+				}
+			}
+			if len(errs) > 0 {
+				return fmt.Errorf("failed to wake one or more devices")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&delay, "delay", 0, "pause between two batches of devices (default from the stagger config)")
+	cmd.Flags().IntVar(&maxConcurrent, "max-concurrent", 0, "devices spun up at once, 0 for all (default from the stagger config)")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do not spin up, only log actions")
+	cmd.Flags().StringSliceVarP(&devices, "devices", "D", nil, "specific devices (e.g. /dev/sda,/dev/sdb) [required]")
+	// nolint:errcheck
+	cmd.MarkFlagRequired("devices")
+	return cmd
+}
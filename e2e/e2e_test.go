@@ -0,0 +1,411 @@
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	binary     string
+	fakeHDParm string
+)
+
+// TestMain builds hd-smart-idle and the fake hdparm once for all tests.
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		dir, err := os.MkdirTemp("", "hd-smart-idle-e2e")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+
+		binary = filepath.Join(dir, "hd-smart-idle")
+		fakeHDParm = filepath.Join(dir, "hdparm")
+		for target, pkg := range map[string]string{binary: "..", fakeHDParm: "./testdata/fakehdparm"} {
+			out, err := exec.Command(goBin(), "build", "-o", target, pkg).CombinedOutput()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "build %s: %v\n%s", pkg, err, out)
+				return 1
+			}
+		}
+		return m.Run()
+	}())
+}
+
+func goBin() string {
+	return filepath.Join(runtime.GOROOT(), "bin", "go")
+}
+
+// harness runs hd-smart-idle against the fake hdparm with its own state and
+// invocation log. Tests use device names absent from the host sysfs so that
+// every device is treated as ATA.
+type harness struct {
+	t      *testing.T
+	state  string
+	log    string
+	socket string
+}
+
+type fakeDevice struct {
+	States  []string `json:"states,omitempty"`
+	SetExit int      `json:"set_exit,omitempty"`
+	NoPM    bool     `json:"no_pm,omitempty"`
+}
+
+func newHarness(t *testing.T, devices map[string]fakeDevice) *harness {
+	dir := t.TempDir()
+	h := &harness{
+		t:      t,
+		state:  filepath.Join(dir, "state.json"),
+		log:    filepath.Join(dir, "hdparm.log"),
+		socket: filepath.Join(dir, "control.sock"),
+	}
+	data, err := json.Marshal(devices)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(h.state, data, 0o644))
+	return h
+}
+
+func (h *harness) command(args ...string) (*exec.Cmd, *bytes.Buffer) {
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(),
+		"HDPARM_PATH="+fakeHDParm,
+		"FAKE_HDPARM_STATE="+h.state,
+		"FAKE_HDPARM_LOG="+h.log,
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	return cmd, &out
+}
+
+// invocations returns the argument lists hdparm was called with, in order.
+func (h *harness) invocations() [][]string {
+	f, err := os.Open(h.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(h.t, err)
+	defer f.Close()
+
+	var calls [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var args []string
+		require.NoError(h.t, json.Unmarshal(scanner.Bytes(), &args))
+		calls = append(calls, args)
+	}
+	require.NoError(h.t, scanner.Err())
+	return calls
+}
+
+// runDaemon starts `run`, waits until hdparm was called at least minCalls
+// times including each of until, then stops the daemon with SIGTERM and
+// returns its output.
+func (h *harness) runDaemon(devices string, until [][]string, minCalls int) string {
+	cmd, out := h.command("--log-level", "debug", "run", "--poll", "100ms", "--socket", h.socket, "--devices", devices)
+	require.NoError(h.t, cmd.Start())
+
+	require.Eventually(h.t, func() bool {
+		calls := h.invocations()
+		if len(calls) < minCalls {
+			return false
+		}
+		for _, want := range until {
+			if !slices.ContainsFunc(calls, func(c []string) bool { return slices.Equal(c, want) }) {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond, "hdparm calls: %v", h.invocations())
+
+	require.NoError(h.t, cmd.Process.Signal(syscall.SIGTERM))
+	require.NoError(h.t, cmd.Wait(), "output: %s", out)
+	return out.String()
+}
+
+func TestStandbyCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		devices   map[string]fakeDevice
+		args      []string
+		wantCalls [][]string
+		wantErr   bool
+		wantOut   string
+	}{
+		{
+			name:    "sets value on every device",
+			devices: map[string]fakeDevice{"/dev/fakea": {}, "/dev/fakeb": {}},
+			args:    []string{"standby", "--devices", "/dev/fakea,/dev/fakeb", "--value", "240"},
+			wantCalls: [][]string{
+				{"-C", "/dev/fakea"},
+				{"-S", "240", "/dev/fakea"},
+				{"-C", "/dev/fakeb"},
+				{"-S", "240", "/dev/fakeb"},
+			},
+			wantOut: "set standby timeout 240 on /dev/fakeb",
+		},
+		{
+			name:    "spin down immediately",
+			devices: map[string]fakeDevice{"/dev/fakea": {}},
+			args:    []string{"standby", "--devices", "/dev/fakea", "--value", "60", "--now"},
+			wantCalls: [][]string{
+				{"-C", "/dev/fakea"},
+				{"-S", "60", "/dev/fakea"},
+				{"-y", "/dev/fakea"},
+			},
+			wantOut: "spun down /dev/fakea",
+		},
+		{
+			name:      "standby disk is not woken",
+			devices:   map[string]fakeDevice{"/dev/fakea": {States: []string{"standby"}}},
+			args:      []string{"standby", "--devices", "/dev/fakea"},
+			wantCalls: [][]string{{"-C", "/dev/fakea"}},
+			wantErr:   true,
+			wantOut:   "refused set standby timeout on /dev/fakea (state=standby): would have woken it",
+		},
+		{
+			name:    "forced on standby disk",
+			devices: map[string]fakeDevice{"/dev/fakea": {States: []string{"standby"}}},
+			args:    []string{"standby", "--devices", "/dev/fakea", "--force"},
+			wantCalls: [][]string{
+				{"-C", "/dev/fakea"},
+				{"-S", "120", "/dev/fakea"},
+			},
+			wantOut: "forcing set standby timeout on /dev/fakea (state=standby)",
+		},
+		{
+			name:    "dry-run does not call hdparm",
+			devices: map[string]fakeDevice{"/dev/fakea": {}},
+			args:    []string{"standby", "--devices", "/dev/fakea", "--dry-run"},
+			wantOut: "dry-run: set standby timeout 120 on /dev/fakea",
+		},
+		{
+			name:    "non-zero exit fails the command but keeps going",
+			devices: map[string]fakeDevice{"/dev/fakea": {SetExit: 5}, "/dev/fakeb": {}},
+			args:    []string{"standby", "--devices", "/dev/fakea,/dev/fakeb", "--value", "0"},
+			wantCalls: [][]string{
+				{"-C", "/dev/fakea"},
+				{"-S", "0", "/dev/fakea"},
+				{"-C", "/dev/fakeb"},
+				{"-S", "0", "/dev/fakeb"},
+			},
+			wantErr: true,
+			wantOut: "failed to set standby timeout on one or more devices",
+		},
+		{
+			name:      "missing device",
+			devices:   map[string]fakeDevice{},
+			args:      []string{"standby", "--devices", "/dev/fakez"},
+			wantCalls: [][]string{{"-C", "/dev/fakez"}},
+			wantErr:   true,
+			wantOut:   "failed to set standby on /dev/fakez: file does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, tt.devices)
+			cmd, out := h.command(tt.args...)
+			err := cmd.Run()
+			if tt.wantErr {
+				require.Error(t, err, "output: %s", out)
+			} else {
+				require.NoError(t, err, "output: %s", out)
+			}
+			require.Contains(t, out.String(), tt.wantOut)
+			require.Equal(t, tt.wantCalls, h.invocations())
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		devices   map[string]fakeDevice
+		until     [][]string
+		minCalls  int
+		wantOut   []string
+		wantNever [][]string
+	}{
+		{
+			name: "wake from standby disables spindown timer",
+			devices: map[string]fakeDevice{
+				"/dev/fakea": {States: []string{"standby", "standby", "active/idle"}},
+				"/dev/fakeb": {States: []string{"active/idle"}},
+			},
+			until:     [][]string{{"-C", "/dev/fakeb"}, {"-S", "0", "/dev/fakea"}},
+			wantOut:   []string{"device /dev/fakea left standby"},
+			wantNever: [][]string{{"-S", "0", "/dev/fakeb"}},
+		},
+		{
+			name: "failed disable is logged and polling continues",
+			devices: map[string]fakeDevice{
+				"/dev/fakea": {States: []string{"standby", "active/idle"}, SetExit: 5},
+			},
+			until:   [][]string{{"-S", "0", "/dev/fakea"}},
+			wantOut: []string{"failed to disable spindown on /dev/fakea", "retrying disable spindown on /dev/fakea in"},
+		},
+		{
+			name: "ignored setting is reported",
+			devices: map[string]fakeDevice{
+				"/dev/fakea": {States: []string{"standby", "active/idle"}, NoPM: true},
+			},
+			until:   [][]string{{"-S", "0", "/dev/fakea"}, {"-I", "/dev/fakea"}},
+			wantOut: []string{"failed to disable spindown on /dev/fakea: standby timeout on /dev/fakea, drive reports power management unsupported: setting not applied by the drive"},
+		},
+		{
+			name: "query errors are tolerated",
+			devices: map[string]fakeDevice{
+				"/dev/fakea": {States: []string{"garbage", "fail", "enoent", "active/idle"}},
+			},
+			until:    [][]string{{"-C", "/dev/fakea"}},
+			minCalls: 5,
+			wantOut: []string{
+				"malformed hdparm output",
+				"Input/output error",
+				"get device state(/dev/fakea) error: file does not exist",
+			},
+			wantNever: [][]string{{"-S", "0", "/dev/fakea"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, tt.devices)
+			devs := make([]string, 0, len(tt.devices))
+			for dev := range tt.devices {
+				devs = append(devs, dev)
+			}
+			slices.Sort(devs)
+
+			out := h.runDaemon(strings.Join(devs, ","), tt.until, tt.minCalls)
+			for _, want := range tt.wantOut {
+				require.Contains(t, out, want)
+			}
+			for _, call := range h.invocations() {
+				require.NotContains(t, tt.wantNever, call)
+				// the daemon only ever queries state, sets the standby timer
+				// and reads it back
+				require.Contains(t, []string{"-C", "-S", "-I"}, call[0], "unexpected call %v", call)
+			}
+		})
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	h := newHarness(t, map[string]fakeDevice{
+		"/dev/fakea": {States: []string{"standby"}},
+	})
+	daemon, daemonOut := h.command("run", "--poll", "100ms", "--socket", h.socket, "--devices", "/dev/fakea")
+	require.NoError(t, daemon.Start())
+	defer func() {
+		require.NoError(t, daemon.Process.Signal(syscall.SIGTERM))
+		require.NoError(t, daemon.Wait(), "output: %s", daemonOut)
+	}()
+
+	var out *bytes.Buffer
+	require.Eventually(t, func() bool {
+		var cmd *exec.Cmd
+		cmd, out = h.command("status", "--socket", h.socket)
+		return cmd.Run() == nil && strings.Contains(out.String(), "standby")
+	}, 10*time.Second, 50*time.Millisecond, "daemon output: %s", daemonOut)
+	require.Contains(t, out.String(), "/dev/fakea")
+	require.Contains(t, out.String(), "SAVED KWH")
+
+	cmd, out := h.command("status", "--socket", h.socket, "--metrics")
+	require.NoError(t, cmd.Run(), "output: %s", out)
+	require.Contains(t, out.String(), `hd_smart_idle_device_state{device="/dev/fakea",state="standby"} 1`)
+}
+
+func TestWakeCommand(t *testing.T) {
+	h := newHarness(t, map[string]fakeDevice{
+		"/dev/fakea": {States: []string{"standby"}},
+		"/dev/fakeb": {States: []string{"standby"}},
+		"/dev/fakec": {States: []string{"standby"}, SetExit: 5},
+	})
+	cmd, out := h.command("wake", "--devices", "/dev/fakea,/dev/fakeb,/dev/fakec", "--max-concurrent", "1", "--delay", "200ms")
+	start := time.Now()
+	require.Error(t, cmd.Run())
+	// two pauses between three batches
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	require.Equal(t, [][]string{
+		{"--read-sector", "0", "/dev/fakea"},
+		{"--read-sector", "0", "/dev/fakeb"},
+		{"--read-sector", "0", "/dev/fakec"},
+	}, h.invocations())
+	require.Contains(t, out.String(), "woke /dev/fakea")
+	require.Contains(t, out.String(), "failed to wake /dev/fakec")
+
+	// woken drives report active
+	cmd, out = h.command("standby", "--devices", "/dev/fakea", "--value", "60")
+	require.NoError(t, cmd.Run(), "output: %s", out)
+	require.Contains(t, h.invocations(), []string{"-S", "60", "/dev/fakea"})
+}
+
+func TestInhibitCommand(t *testing.T) {
+	h := newHarness(t, map[string]fakeDevice{
+		"/dev/fakea": {States: []string{"active"}},
+		"/dev/fakeb": {States: []string{"standby"}},
+	})
+	daemon, daemonOut := h.command("run", "--poll", "100ms", "--socket", h.socket, "--devices", "/dev/fakea,/dev/fakeb")
+	require.NoError(t, daemon.Start())
+	defer func() {
+		require.NoError(t, daemon.Process.Signal(syscall.SIGTERM))
+		require.NoError(t, daemon.Wait(), "output: %s", daemonOut)
+	}()
+	require.Eventually(t, func() bool {
+		cmd, _ := h.command("status", "--socket", h.socket)
+		return cmd.Run() == nil
+	}, 10*time.Second, 50*time.Millisecond, "daemon output: %s", daemonOut)
+
+	// the command sees the disk held by its lease
+	cmd, out := h.command("inhibit", "--socket", h.socket, "--device", "/dev/fakea", "--", binary, "status", "--socket", h.socket)
+	require.NoError(t, cmd.Run(), "output: %s", out)
+	require.Regexp(t, `/dev/fakea .*lease [0-9a-f]+ \(.*status --socket.*\) until`, out.String())
+	require.NotRegexp(t, `/dev/fakeb .*lease`, out.String())
+
+	// the exit status of the command is kept and the lease released
+	cmd, out = h.command("inhibit", "--socket", h.socket, "--", "sh", "-c", "exit 3")
+	var exit *exec.ExitError
+	require.ErrorAs(t, cmd.Run(), &exit, "output: %s", out)
+	require.Equal(t, 3, exit.ExitCode())
+	cmd, out = h.command("status", "--socket", h.socket)
+	require.NoError(t, cmd.Run(), "output: %s", out)
+	require.NotContains(t, out.String(), "lease")
+
+	cmd, out = h.command("inhibit", "--socket", h.socket, "--device", "/dev/sdz", "--", "true")
+	require.Error(t, cmd.Run())
+	require.Contains(t, out.String(), "/dev/sdz is not monitored")
+}
+
+func TestDoctorCommand(t *testing.T) {
+	h := newHarness(t, map[string]fakeDevice{
+		"/dev/fakea": {States: []string{"standby"}},
+		"/dev/fakeb": {States: []string{"fail"}},
+	})
+	cmd, out := h.command("doctor", "--devices", "/dev/fakea,/dev/fakeb")
+	var exit *exec.ExitError
+	require.ErrorAs(t, cmd.Run(), &exit, "output: %s", out)
+	require.Equal(t, 1, exit.ExitCode())
+	require.Contains(t, out.String(), "[ok  ] hdparm: "+fakeHDParm+" (hdparm v9.65)")
+	require.Contains(t, out.String(), "[ok  ] /dev/fakea: power mode standby")
+	require.Contains(t, out.String(), "[fail] /dev/fakeb: cannot query the power mode")
+	require.NotContains(t, out.String(), "Usage:")
+	// the power mode is only ever queried
+	require.Equal(t, [][]string{{"-V"}, {"-C", "/dev/fakea"}, {"-C", "/dev/fakeb"}}, h.invocations())
+}
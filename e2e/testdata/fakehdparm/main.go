@@ -0,0 +1,137 @@
+// fakehdparm mimics the subset of hdparm used by hd-smart-idle. Every
+// invocation is appended as a JSON array to $FAKE_HDPARM_LOG, and device
+// behavior is driven by the JSON state file at $FAKE_HDPARM_STATE:
+//
+//	{"/dev/sda": {"states": ["standby", "active/idle"], "set_exit": 0}}
+//
+// Each -C query consumes the next entry of states; the last one repeats.
+// A successful --read-sector spins the drive up: later queries are active.
+// -I reports the Power Management feature set unless no_pm is set.
+// Besides real hdparm states an entry may be "garbage" (unparsable output),
+// "enoent" (missing device) or "fail" (I/O error).
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type device struct {
+	States []string `json:"states"`
+	// exit status of commands changing the drive (-S, -y, --read-sector)
+	SetExit int `json:"set_exit"`
+	// NoPM leaves the Power Management feature set out of -I
+	NoPM bool `json:"no_pm"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if err := appendLog(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 99
+	}
+	if len(args) == 1 && args[0] == "-V" {
+		fmt.Println("hdparm v9.65")
+		return 0
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "fakehdparm: unsupported arguments")
+		return 98
+	}
+
+	statePath := os.Getenv("FAKE_HDPARM_STATE")
+	devices := make(map[string]*device)
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &devices); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 97
+		}
+	}
+
+	dev := args[len(args)-1]
+	d, ok := devices[dev]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: No such file or directory\n", dev)
+		return 2
+	}
+
+	switch {
+	case len(args) == 2 && args[0] == "-C":
+		state := "active/idle"
+		if len(d.States) > 0 {
+			state = d.States[0]
+		}
+		if len(d.States) > 1 {
+			d.States = d.States[1:]
+			if err := save(statePath, devices); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 97
+			}
+		}
+		switch state {
+		case "garbage":
+			fmt.Printf("\n%s:\n SG_IO: bad/missing sense data\n", dev)
+			return 0
+		case "enoent":
+			fmt.Fprintf(os.Stderr, "%s: No such file or directory\n", dev)
+			return 2
+		case "fail":
+			fmt.Printf("\n%s:\n", dev)
+			fmt.Fprintln(os.Stderr, " HDIO_DRIVE_CMD(check) failed: Input/output error")
+			return 5
+		}
+		fmt.Printf("\n%s:\n drive state is:  %s\n", dev, state)
+		return 0
+	case len(args) == 2 && args[0] == "-y":
+		fmt.Printf("\n%s:\n issuing standby command\n", dev)
+		return d.SetExit
+	case len(args) == 3 && args[0] == "--read-sector":
+		if d.SetExit != 0 {
+			fmt.Fprintln(os.Stderr, " READ SECTORS failed: Input/output error")
+			return d.SetExit
+		}
+		fmt.Printf("\n%s:\nreading sector %s: succeeded\n", dev, args[1])
+		d.States = []string{"active/idle"}
+		if err := save(statePath, devices); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 97
+		}
+		return 0
+	case len(args) == 2 && args[0] == "-I":
+		fmt.Printf("\n%s:\n\nATA device, with non-removable media\n\tModel Number:       FAKE HDD\n", dev)
+		fmt.Print("Commands/features:\n\tEnabled\tSupported:\n\t   *\tSMART feature set\n")
+		if !d.NoPM {
+			fmt.Print("\t   *\tPower Management feature set\n")
+		}
+		return 0
+	case len(args) == 3 && args[0] == "-S":
+		fmt.Printf("\n%s:\n setting standby to %s\n", dev, args[1])
+		if d.SetExit != 0 {
+			fmt.Fprintln(os.Stderr, " HDIO_DRIVE_CMD(setidle) failed: Input/output error")
+		}
+		return d.SetExit
+	}
+	fmt.Fprintln(os.Stderr, "fakehdparm: unsupported arguments")
+	return 98
+}
+
+func appendLog(args []string) error {
+	f, err := os.OpenFile(os.Getenv("FAKE_HDPARM_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(args)
+}
+
+func save(path string, devices map[string]*device) error {
+	data, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,137 @@
+// Package attribution finds the processes that woke a disk up: the ones
+// accessing its filesystems, seen by fanotify, or the ones doing storage I/O
+// with files open on it, from /proc/<pid>/io when fanotify is unavailable.
+package attribution
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// maxCulprits is the number of processes reported for a wake.
+const maxCulprits = 5
+
+// Culprit is a process that accessed a device shortly before it woke up.
+type Culprit struct {
+	PID  int    `json:"pid"`
+	Comm string `json:"comm"`
+	// Path is the last file it accessed on the device, if known
+	Path string `json:"path,omitempty"`
+	// Accesses is the number of file opens and writes seen by fanotify
+	Accesses int `json:"accesses,omitempty"`
+	// Bytes is the storage I/O of the process, from /proc/<pid>/io
+	Bytes uint64 `json:"bytes,omitempty"`
+}
+
+func (c Culprit) String() string {
+	s := fmt.Sprintf("pid %d (%s)", c.PID, c.Comm)
+	if c.Path != "" {
+		s += " " + c.Path
+	}
+	if c.Accesses > 0 {
+		s += fmt.Sprintf(" %d accesses", c.Accesses)
+	}
+	if c.Bytes > 0 {
+		s += fmt.Sprintf(" %d bytes", c.Bytes)
+	}
+	return s
+}
+
+// Tracer finds the processes that woke a device.
+type Tracer interface {
+	// Sample records the I/O counters of every process, the baseline of the
+	// next Culprits. It is called on every poll while a device is spun down.
+	Sample()
+	// Culprits returns the processes that accessed dev since the given time,
+	// most active first.
+	Culprits(dev string, since time.Time) []Culprit
+	// Close stops watching.
+	Close() error
+}
+
+// New returns a Tracer for devs. The filesystems mounted from each device
+// are watched with fanotify, which needs CAP_SYS_ADMIN and a 4.20+ kernel;
+// devices without a watched filesystem fall back to /proc/*/io.
+func New(devs []string) Tracer {
+	fsys := os.DirFS("/")
+	t := &tracer{proc: newProcTracer(fsys, os.Getpid())}
+	mounts := make(map[string][]string)
+	for _, dev := range devs {
+		if m := hw.MountPoints(fsys, dev); len(m) > 0 {
+			mounts[dev] = m
+		}
+	}
+	if len(mounts) == 0 {
+		logrus.Infof("wake attribution: no mounted filesystem, using /proc/*/io")
+		return t
+	}
+	fan, err := newFanotify(fsys, mounts)
+	if err != nil {
+		logrus.Warnf("wake attribution: fanotify unavailable, using /proc/*/io: %v", err)
+		return t
+	}
+	t.fan = fan
+	return t
+}
+
+// tracer prefers the file accesses seen by fanotify and falls back to the
+// I/O counters of the processes.
+type tracer struct {
+	proc *procTracer
+	fan  *fanotifyWatcher
+}
+
+func (t *tracer) Sample() { t.proc.sample() }
+
+func (t *tracer) Culprits(dev string, since time.Time) []Culprit {
+	if t.fan != nil {
+		if culprits := t.fan.culprits(dev, since); len(culprits) > 0 {
+			return culprits
+		}
+	}
+	return t.proc.culprits(dev)
+}
+
+func (t *tracer) Close() error {
+	if t.fan != nil {
+		return t.fan.close()
+	}
+	return nil
+}
+
+// top sorts culprits by activity and keeps the first maxCulprits.
+func top(culprits []Culprit) []Culprit {
+	sort.Slice(culprits, func(i, j int) bool {
+		a, b := culprits[i], culprits[j]
+		if a.Accesses != b.Accesses {
+			return a.Accesses > b.Accesses
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.PID < b.PID
+	})
+	return culprits[:min(len(culprits), maxCulprits)]
+}
+
+// comm returns the command name of pid, empty if it exited.
+func comm(fsys fs.FS, pid int) string {
+	data, err := fs.ReadFile(fsys, fmt.Sprintf("proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return string(trimNewline(data))
+}
+
+func trimNewline(b []byte) []byte {
+	if n := len(b); n > 0 && b[n-1] == '\n' {
+		return b[:n-1]
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+package attribution
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sysfs and procfs of a disk sda with a filesystem on sda1, one on an md
+// array over sda2, and an unrelated disk sdb.
+func testFS() fstest.MapFS {
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	return fstest.MapFS{
+		"sys/block/sda/dev":              &fstest.MapFile{Data: []byte("8:0\n")},
+		"sys/block/sda/sda1/dev":         &fstest.MapFile{Data: []byte("8:1\n")},
+		"sys/block/sda/sda1/partition":   &fstest.MapFile{Data: []byte("1\n")},
+		"sys/block/sda/sda2/dev":         &fstest.MapFile{Data: []byte("8:2\n")},
+		"sys/block/sda/sda2/partition":   &fstest.MapFile{Data: []byte("2\n")},
+		"sys/block/sda/sda2/holders/md0": &fstest.MapFile{},
+		"sys/block/md0/dev":              &fstest.MapFile{Data: []byte("9:0\n")},
+		"sys/block/sdb/dev":              &fstest.MapFile{Data: []byte("8:16\n")},
+		"proc/self/mountinfo": &fstest.MapFile{Data: []byte(`22 1 8:16 / / rw,relatime shared:1 - ext4 /dev/sdb rw
+36 22 8:1 / /mnt/my\040data rw,relatime shared:2 - ext4 /dev/sda1 rw
+37 22 9:0 / /srv rw,relatime shared:3 - xfs /dev/md0 rw
+`)},
+		"proc/100/comm":  &fstest.MapFile{Data: []byte("updatedb\n")},
+		"proc/100/io":    &fstest.MapFile{Data: []byte("rchar: 10\nwchar: 0\nread_bytes: 8192\nwrite_bytes: 0\ncancelled_write_bytes: 0\n")},
+		"proc/100/cwd":   link("/"),
+		"proc/100/fd/3":  link("/srv/photos"),
+		"proc/200/comm":  &fstest.MapFile{Data: []byte("sshd\n")},
+		"proc/200/io":    &fstest.MapFile{Data: []byte("read_bytes: 4096\nwrite_bytes: 4096\n")},
+		"proc/200/cwd":   link("/root"),
+		"proc/300/comm":  &fstest.MapFile{Data: []byte("hd-smart-idle\n")},
+		"proc/300/io":    &fstest.MapFile{Data: []byte("read_bytes: 4096\nwrite_bytes: 0\n")},
+		"proc/300/cwd":   link("/srv"),
+		"proc/self/comm": &fstest.MapFile{Data: []byte("hd-smart-idle\n")},
+	}
+}
+
+func TestParseProcIO(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want uint64
+	}{
+		{"empty", "", 0},
+		{"read and write", "rchar: 323934931\nread_bytes: 4096\nwrite_bytes: 323932160\n", 323936256},
+		{"garbage", "read_bytes: lots\nwrite_bytes: 1\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseProcIO([]byte(tt.data)))
+		})
+	}
+}
+
+func TestProcTracer(t *testing.T) {
+	fsys := testFS()
+	p := newProcTracer(fsys, 300)
+	require.Empty(t, p.culprits("/dev/sda"), "no baseline")
+
+	p.sample()
+	require.Empty(t, p.culprits("/dev/sda"), "no I/O since the sample")
+
+	fsys["proc/100/io"] = &fstest.MapFile{Data: []byte("read_bytes: 1056768\nwrite_bytes: 0\n")}
+	fsys["proc/200/io"] = &fstest.MapFile{Data: []byte("read_bytes: 8192\nwrite_bytes: 4096\n")}
+	fsys["proc/300/io"] = &fstest.MapFile{Data: []byte("read_bytes: 8192\nwrite_bytes: 0\n")}
+	// sshd has nothing open on sda, the daemon itself is ignored
+	require.Equal(t, []Culprit{
+		{PID: 100, Comm: "updatedb", Path: "/srv/photos", Bytes: 1048576},
+	}, p.culprits("/dev/sda"))
+	// every process doing I/O is found on the root filesystem
+	require.Equal(t, []Culprit{
+		{PID: 100, Comm: "updatedb", Path: "/", Bytes: 1048576},
+		{PID: 200, Comm: "sshd", Path: "/root", Bytes: 4096},
+	}, p.culprits("/dev/sdb"))
+}
+
+func TestTop(t *testing.T) {
+	var culprits []Culprit
+	for pid := range maxCulprits + 2 {
+		culprits = append(culprits, Culprit{PID: pid, Accesses: pid % 3})
+	}
+	got := top(culprits)
+	require.Len(t, got, maxCulprits)
+	require.Equal(t, []int{2, 5, 1, 4, 0}, []int{got[0].PID, got[1].PID, got[2].PID, got[3].PID, got[4].PID})
+}
+
+func TestCulpritString(t *testing.T) {
+	require.Equal(t, "pid 42 (updatedb) /srv/photos 3 accesses", Culprit{PID: 42, Comm: "updatedb", Path: "/srv/photos", Accesses: 3}.String())
+	require.Equal(t, "pid 7 (rsync) 4096 bytes", Culprit{PID: 7, Comm: "rsync", Bytes: 4096}.String())
+}
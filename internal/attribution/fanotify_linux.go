@@ -0,0 +1,170 @@
+package attribution
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyMask selects the opens and writes of files and directories. Reads
+// are left out: most of them are served from the page cache and every one
+// of them would be an event. Opens of cached files are still seen.
+const fanotifyMask = unix.FAN_OPEN | unix.FAN_MODIFY | unix.FAN_ONDIR
+
+// accessWindow is how long the accesses of a process count towards the
+// next wake.
+const accessWindow = 10 * time.Minute
+
+// maxAccessors is the number of processes remembered per device; the one
+// idle the longest is forgotten first.
+const maxAccessors = 64
+
+const metadataSize = int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+
+// accessor is a process accessing the files of a device, as of its latest
+// access seen by fanotify.
+type accessor struct {
+	at   time.Time
+	comm string
+	path string
+	// accesses counts its accesses since it was idle for accessWindow
+	accesses int
+}
+
+// fanotifyWatcher records the file accesses on the filesystems of each
+// device, one fanotify group per device.
+type fanotifyWatcher struct {
+	fsys  fs.FS
+	files []*os.File
+
+	mu        sync.Mutex
+	accessors map[string]map[int]*accessor
+	wg        sync.WaitGroup
+}
+
+func newFanotify(fsys fs.FS, mounts map[string][]string) (*fanotifyWatcher, error) {
+	w := &fanotifyWatcher{fsys: fsys, accessors: make(map[string]map[int]*accessor)}
+	for dev, points := range mounts {
+		fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+		if err != nil {
+			// nolint:errcheck
+			w.close()
+			return nil, fmt.Errorf("fanotify_init: %w", err)
+		}
+		// a non blocking fd goes through the runtime poller, so Close unblocks Read
+		f := os.NewFile(uintptr(fd), "fanotify "+dev)
+		w.files = append(w.files, f)
+		for _, p := range points {
+			if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanotifyMask, unix.AT_FDCWD, p); err != nil {
+				// nolint:errcheck
+				w.close()
+				return nil, fmt.Errorf("fanotify_mark %s: %w", p, err)
+			}
+		}
+		w.wg.Go(func() { w.watch(dev, f) })
+	}
+	return w, nil
+}
+
+// watch records the accesses read from f until it is closed.
+func (w *fanotifyWatcher) watch(dev string, f *os.File) {
+	self := os.Getpid()
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				logrus.Warnf("wake attribution: fanotify of %s stopped: %v", dev, err)
+			}
+			return
+		}
+		now := time.Now()
+		for off := 0; off+metadataSize <= n; {
+			meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[off]))
+			if int(meta.Event_len) < metadataSize || meta.Vers != unix.FANOTIFY_METADATA_VERSION {
+				break
+			}
+			off += int(meta.Event_len)
+			if meta.Fd < 0 {
+				continue
+			}
+			target, _ := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", meta.Fd))
+			// nolint:errcheck
+			unix.Close(int(meta.Fd))
+			if int(meta.Pid) == self {
+				continue
+			}
+			w.record(dev, int(meta.Pid), target, now)
+		}
+	}
+}
+
+// record remembers pid accessed path on dev at now. The command name is only
+// read for a process not seen for accessWindow, so a busy process costs a
+// map lookup per access.
+func (w *fanotifyWatcher) record(dev string, pid int, path string, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	procs := w.accessors[dev]
+	if procs == nil {
+		procs = make(map[int]*accessor)
+		w.accessors[dev] = procs
+	}
+	a := procs[pid]
+	if a == nil {
+		if len(procs) >= maxAccessors {
+			delete(procs, idlest(procs))
+		}
+		a = &accessor{}
+		procs[pid] = a
+	}
+	if a.accesses == 0 || now.Sub(a.at) > accessWindow {
+		// the pid may have been reused since
+		a.comm = comm(w.fsys, pid)
+		a.accesses = 0
+	}
+	a.at = now
+	a.path = path
+	a.accesses++
+}
+
+// idlest returns the pid of procs accessed the longest ago.
+func idlest(procs map[int]*accessor) int {
+	pid := -1
+	var at time.Time
+	for p, a := range procs {
+		if pid < 0 || a.at.Before(at) {
+			pid, at = p, a.at
+		}
+	}
+	return pid
+}
+
+func (w *fanotifyWatcher) culprits(dev string, since time.Time) []Culprit {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var culprits []Culprit
+	for pid, a := range w.accessors[dev] {
+		if a.at.Before(since) {
+			continue
+		}
+		culprits = append(culprits, Culprit{PID: pid, Comm: a.comm, Path: a.path, Accesses: a.accesses})
+	}
+	return top(culprits)
+}
+
+func (w *fanotifyWatcher) close() error {
+	var errs []error
+	for _, f := range w.files {
+		errs = append(errs, f.Close())
+	}
+	w.wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package attribution
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanotifyWatcher_record(t *testing.T) {
+	fsys := testFS()
+	w := &fanotifyWatcher{fsys: fsys, accessors: make(map[string]map[int]*accessor)}
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	w.record("/dev/sda", 100, "/srv/a", start)
+	w.record("/dev/sda", 200, "/srv/b", start.Add(time.Second))
+	w.record("/dev/sda", 100, "/srv/c", start.Add(2*time.Second))
+	require.Equal(t, []Culprit{
+		{PID: 100, Comm: "updatedb", Path: "/srv/c", Accesses: 2},
+		{PID: 200, Comm: "sshd", Path: "/srv/b", Accesses: 1},
+	}, w.culprits("/dev/sda", start))
+	require.Equal(t, []Culprit{
+		{PID: 100, Comm: "updatedb", Path: "/srv/c", Accesses: 2},
+	}, w.culprits("/dev/sda", start.Add(2*time.Second)), "only the latest access counts")
+
+	// accesses after an idle accessWindow start over
+	w.record("/dev/sda", 100, "/srv/d", start.Add(time.Hour))
+	require.Equal(t, []Culprit{
+		{PID: 100, Comm: "updatedb", Path: "/srv/d", Accesses: 1},
+	}, w.culprits("/dev/sda", start.Add(time.Hour)))
+
+	// the process idle the longest is forgotten past maxAccessors
+	for pid := 1000; pid < 1000+maxAccessors-1; pid++ {
+		w.record("/dev/sda", pid, "/srv/e", start.Add(2*time.Hour))
+	}
+	require.Len(t, w.accessors["/dev/sda"], maxAccessors)
+	require.NotContains(t, w.accessors["/dev/sda"], 200)
+	require.Contains(t, w.accessors["/dev/sda"], 100)
+}
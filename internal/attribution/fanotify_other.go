@@ -0,0 +1,19 @@
+//go:build !linux
+
+package attribution
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// fanotifyWatcher is only available on Linux.
+type fanotifyWatcher struct{}
+
+func newFanotify(fs.FS, map[string][]string) (*fanotifyWatcher, error) {
+	return nil, errors.New("fanotify is only supported on linux")
+}
+
+func (*fanotifyWatcher) culprits(string, time.Time) []Culprit { return nil }
+func (*fanotifyWatcher) close() error                         { return nil }
@@ -0,0 +1,122 @@
+package attribution
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// procSample is the storage I/O of a process.
+type procSample struct {
+	comm  string
+	bytes uint64
+}
+
+// procTracer attributes a wake to the processes whose storage I/O grew since
+// the previous sample and that have a file open on the device. The counters
+// are not per device: without a mounted filesystem to match open files
+// against, every process doing I/O is reported.
+type procTracer struct {
+	fsys    fs.FS
+	self    int
+	samples map[int]procSample
+}
+
+func newProcTracer(fsys fs.FS, self int) *procTracer {
+	return &procTracer{fsys: fsys, self: self}
+}
+
+func (p *procTracer) sample() {
+	p.samples = p.read()
+}
+
+func (p *procTracer) culprits(dev string) []Culprit {
+	if p.samples == nil {
+		return nil
+	}
+	mounts := hw.MountPoints(p.fsys, dev)
+	var culprits []Culprit
+	for pid, s := range p.read() {
+		// a process started since the sample did all of its I/O since
+		prev := p.samples[pid].bytes
+		if s.bytes <= prev {
+			continue
+		}
+		c := Culprit{PID: pid, Comm: s.comm, Bytes: s.bytes - prev}
+		if len(mounts) > 0 {
+			if c.Path = p.openOn(pid, mounts); c.Path == "" {
+				continue
+			}
+		}
+		culprits = append(culprits, c)
+	}
+	return top(culprits)
+}
+
+// read returns the storage I/O of every process but the daemon.
+func (p *procTracer) read() map[int]procSample {
+	samples := make(map[int]procSample)
+	entries, err := fs.ReadDir(p.fsys, "proc")
+	if err != nil {
+		return samples
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == p.self {
+			continue
+		}
+		data, err := fs.ReadFile(p.fsys, fmt.Sprintf("proc/%d/io", pid))
+		if err != nil {
+			continue
+		}
+		samples[pid] = procSample{comm: comm(p.fsys, pid), bytes: parseProcIO(data)}
+	}
+	return samples
+}
+
+// parseProcIO returns the bytes read from and written to storage in the
+// content of /proc/<pid>/io.
+func parseProcIO(data []byte) uint64 {
+	var total uint64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || (key != "read_bytes" && key != "write_bytes") {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64); err == nil {
+			total += n
+		}
+	}
+	return total
+}
+
+// openOn returns a file or directory pid has open below one of mounts, or
+// its working directory there.
+func (p *procTracer) openOn(pid int, mounts []string) string {
+	dir := fmt.Sprintf("proc/%d", pid)
+	links := []string{path.Join(dir, "cwd")}
+	if fds, err := fs.ReadDir(p.fsys, path.Join(dir, "fd")); err == nil {
+		for _, fd := range fds {
+			links = append(links, path.Join(dir, "fd", fd.Name()))
+		}
+	}
+	for _, link := range links {
+		target, err := fs.ReadLink(p.fsys, link)
+		if err != nil {
+			continue
+		}
+		for _, m := range mounts {
+			if hw.Under(target, m) {
+				return target
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Flag is the name of the global flag holding the config file path.
+const Flag = "config"
+
+// Config is the optional YAML configuration file. Command line flags cover
+// the common settings; the file holds the structured ones.
+type Config struct {
+	// Quirks are USB bridge quirks, preferred over hw.DefaultQuirks
+	Quirks []hw.Quirk `yaml:"quirks"`
+	// Discovery selects the disks managed when no device is given
+	Discovery hw.Filters `yaml:"discovery"`
+	// EPC are the daily rules choosing the multi-stage power policy armed by
+	// the daemon on drives supporting Extended Power Conditions
+	EPC []EPCRule `yaml:"epc"`
+	// APM are the daily APM level rules of the daemon
+	APM []APMRule `yaml:"apm"`
+	// Wake are the daily pre-wake rules of the daemon
+	Wake []WakeRule `yaml:"wake"`
+	// Inhibit are the conditions keeping disks spinning while they hold
+	Inhibit []Inhibitor `yaml:"inhibit"`
+	// Power is the power model used to estimate energy consumption
+	Power power.Config `yaml:"power"`
+	// Stagger spreads the scheduled commands of the daemon and the wake-ups
+	// of the wake command over the devices in time
+	Stagger Stagger `yaml:"stagger"`
+	// Retry configures the retries of the failed commands of the daemon
+	Retry Retry `yaml:"retry"`
+	// Drift verifies the settings applied by the daemon are not changed by
+	// another power manager
+	Drift Drift `yaml:"drift"`
+}
+
+// Load reads and validates the config file at path. An empty path yields an
+// empty config.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadFlag loads the config file named by the Flag flag of flags.
+func LoadFlag(flags *pflag.FlagSet) (*Config, error) {
+	path, err := flags.GetString(Flag)
+	if err != nil {
+		return nil, err
+	}
+	return Load(path)
+}
+
+// Validate checks every section of the config.
+func (c *Config) Validate() error {
+	for _, q := range c.Quirks {
+		if err := q.Validate(); err != nil {
+			return fmt.Errorf("quirks: %w", err)
+		}
+	}
+	if err := c.Discovery.Validate(); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	for _, r := range c.EPC {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("epc: %w", err)
+		}
+	}
+	for _, r := range c.APM {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("apm: %w", err)
+		}
+	}
+	for _, r := range c.Wake {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("wake: %w", err)
+		}
+	}
+	for _, i := range c.Inhibit {
+		if err := i.Validate(); err != nil {
+			return fmt.Errorf("inhibit: %w", err)
+		}
+	}
+	if err := c.Power.Validate(); err != nil {
+		return fmt.Errorf("power: %w", err)
+	}
+	if err := c.Stagger.Validate(); err != nil {
+		return fmt.Errorf("stagger: %w", err)
+	}
+	if err := c.Retry.Validate(); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	if err := c.Drift.Validate(); err != nil {
+		return fmt.Errorf("drift: %w", err)
+	}
+	return nil
+}
+
+// ResolveDevices replaces the devices named in the config, which may be
+// mount points, filesystem tags or stacked devices, by the disks resolve
+// maps them to. A power spec naming several disks applies to each of them.
+func (c *Config) ResolveDevices(resolve func(specs []string) ([]string, error)) error {
+	for i := range c.Wake {
+		devs, err := resolve(c.Wake[i].Devices)
+		if err != nil {
+			return fmt.Errorf("wake: %w", err)
+		}
+		c.Wake[i].Devices = devs
+	}
+	for i := range c.Inhibit {
+		devs, err := resolve(c.Inhibit[i].Devices)
+		if err != nil {
+			return fmt.Errorf("inhibit: %w", err)
+		}
+		c.Inhibit[i].Devices = devs
+	}
+	if len(c.Power.Devices) == 0 {
+		return nil
+	}
+	specs := make(map[string]power.Spec, len(c.Power.Devices))
+	for name, spec := range c.Power.Devices {
+		devs, err := resolve([]string{name})
+		if err != nil {
+			return fmt.Errorf("power: %w", err)
+		}
+		for _, dev := range devs {
+			specs[dev] = spec
+		}
+	}
+	c.Power.Devices = specs
+	return nil
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *Config
+		wantErr string
+	}{
+		{
+			name: "quirks",
+			content: `
+quirks:
+  - usb: "152d:0578"
+    name: JMicron JMS578
+    command_set: sat12
+  - usb: "1058:25a2"
+    name: WD Elements
+    query_wakes: true
+`,
+			want: &Config{Quirks: []hw.Quirk{
+				{USBID: "152d:0578", Name: "JMicron JMS578", CommandSet: hw.CommandSetSAT12},
+				{USBID: "1058:25a2", Name: "WD Elements", QueryWakes: true},
+			}},
+		},
+		{
+			name: "epc",
+			content: `
+epc:
+  - time: "22 00"
+    idle_b: 2m
+    standby_z: 30m
+  - time: "07 30"
+`,
+			want: &Config{EPC: []EPCRule{
+				{At: CronExpr{Hour: 22}, Timers: hw.EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}},
+				{At: CronExpr{Hour: 7, Min: 30}},
+			}},
+		},
+		{
+			name:    "invalid epc timer",
+			content: "epc:\n  - time: \"22 00\"\n    idle_a: 50ms\n",
+			wantErr: "epc: at 22 0: invalid idle_a timer",
+		},
+		{
+			name: "apm rules",
+			content: `
+apm:
+  - time: "07 30"
+    level: 254
+  - time: "22 00"
+    level: 127
+`,
+			want: &Config{APM: []APMRule{
+				{At: CronExpr{Hour: 7, Min: 30}, Level: 254},
+				{At: CronExpr{Hour: 22}, Level: 127},
+			}},
+		},
+		{
+			name:    "invalid apm time",
+			content: "apm:\n  - time: \"25 00\"\n    level: 127\n",
+			wantErr: "invalid hour",
+		},
+		{
+			name:    "invalid apm level",
+			content: "apm:\n  - time: \"22 00\"\n    level: 0\n",
+			wantErr: "apm: invalid APM level 0",
+		},
+		{
+			name: "power",
+			content: `
+power:
+  class: nas
+  devices:
+    /dev/sdb:
+      standby_watts: 0.6
+`,
+			want: &Config{Power: power.Config{
+				Spec:    power.Spec{Class: power.ClassNAS},
+				Devices: map[string]power.Spec{"/dev/sdb": {Model: power.Model{StandbyWatts: 0.6}}},
+			}},
+		},
+		{
+			name:    "invalid power class",
+			content: "power:\n  class: tape\n",
+			wantErr: `power: unknown drive class "tape"`,
+		},
+		{
+			name: "wake rules",
+			content: `
+wake:
+  - time: "03 00"
+    lead: 5m
+    hold: 2h
+    devices: [/dev/sda, /dev/sdb]
+`,
+			want: &Config{Wake: []WakeRule{{
+				At:      CronExpr{Hour: 3, Min: 0},
+				Lead:    5 * time.Minute,
+				Hold:    2 * time.Hour,
+				Devices: []string{"/dev/sda", "/dev/sdb"},
+			}}},
+		},
+		{
+			name:    "wake rule without hold",
+			content: "wake:\n  - time: \"03 00\"\n",
+			wantErr: "wake: invalid wake hold 0s",
+		},
+		{
+			name: "inhibitors",
+			content: `
+inhibit:
+  - process: "ffmpeg*"
+    devices: [/dev/sdb]
+  - unit: backup.service
+  - lock_file: /run/rsync.lock
+  - open_under: /mnt/media
+`,
+			want: &Config{Inhibit: []Inhibitor{
+				{Process: "ffmpeg*", Devices: []string{"/dev/sdb"}},
+				{Unit: "backup.service"},
+				{LockFile: "/run/rsync.lock"},
+				{OpenUnder: "/mnt/media"},
+			}},
+		},
+		{
+			name:    "inhibitor with two conditions",
+			content: "inhibit:\n  - process: rsync\n    unit: backup.service\n",
+			wantErr: "inhibit: inhibitor sets 2 conditions",
+		},
+		{
+			name:    "relative lock file",
+			content: "inhibit:\n  - lock_file: run/rsync.lock\n",
+			wantErr: `inhibit: inhibitor path "run/rsync.lock" is not absolute`,
+		},
+		{
+			name: "discovery filters",
+			content: `
+discovery:
+  include:
+    - id: "ata-ST8000VN004*"
+  exclude:
+    - root: true
+    - transport: usb
+      max_size: 2T
+`,
+			want: &Config{Discovery: hw.Filters{
+				Include: []hw.Filter{{ID: "ata-ST8000VN004*"}},
+				Exclude: []hw.Filter{{Root: true}, {Transport: "usb", MaxSize: "2T"}},
+			}},
+		},
+		{
+			name:    "empty discovery filter",
+			content: "discovery:\n  exclude:\n    - {}\n",
+			wantErr: "discovery: empty disk filter",
+		},
+		{
+			name:    "stagger",
+			content: "stagger:\n  delay: 3s\n  max_concurrent: 2\n",
+			want:    &Config{Stagger: Stagger{Delay: 3 * time.Second, MaxConcurrent: 2}},
+		},
+		{
+			name:    "invalid stagger",
+			content: "stagger:\n  max_concurrent: -1\n",
+			wantErr: "stagger: invalid stagger max_concurrent -1",
+		},
+		{
+			name:    "retry",
+			content: "retry:\n  delay: 1m\n  max_delay: 1h\n  attempts: 3\n",
+			want:    &Config{Retry: Retry{Delay: time.Minute, MaxDelay: time.Hour, Attempts: 3}},
+		},
+		{
+			name:    "invalid retry",
+			content: "retry:\n  attempts: -1\n",
+			wantErr: "retry: invalid retry attempts -1",
+		},
+		{
+			name:    "drift",
+			content: "drift:\n  interval: 15m\n  reassert: true\n  reassert_every: 2h\n",
+			want:    &Config{Drift: Drift{Interval: 15 * time.Minute, Reassert: true, ReassertEvery: 2 * time.Hour}},
+		},
+		{
+			name:    "invalid drift",
+			content: "drift:\n  interval: -1m\n",
+			wantErr: "drift: invalid drift interval -1m0s",
+		},
+		{
+			name:    "empty file",
+			content: "",
+			want:    &Config{},
+		},
+		{
+			name:    "unknown field",
+			content: "quirk: []\n",
+			wantErr: "failed to parse config",
+		},
+		{
+			name:    "invalid quirk",
+			content: "quirks:\n  - usb: jmicron\n",
+			wantErr: "invalid usb id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			cfg, err := Load(path)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cfg)
+		})
+	}
+}
+
+func TestLoad_NoPath(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	require.Equal(t, &Config{}, cfg)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConfig_ResolveDevices(t *testing.T) {
+	mounts := map[string][]string{"/srv/media": {"/dev/sdb", "/dev/sdc"}}
+	resolve := func(specs []string) ([]string, error) {
+		var devs []string
+		for _, s := range specs {
+			if s == "/nowhere" {
+				return nil, errors.New("/nowhere: not mounted")
+			}
+			if m, ok := mounts[s]; ok {
+				devs = append(devs, m...)
+				continue
+			}
+			devs = append(devs, s)
+		}
+		return devs, nil
+	}
+
+	cfg := &Config{
+		Wake:    []WakeRule{{Devices: []string{"/srv/media"}}, {}},
+		Inhibit: []Inhibitor{{Process: "rsync", Devices: []string{"/dev/sda", "/srv/media"}}},
+		Power: power.Config{Devices: map[string]power.Spec{
+			"/srv/media": {Class: power.ClassNAS},
+			"/dev/sda":   {Class: power.ClassDesktop},
+		}},
+	}
+	require.NoError(t, cfg.ResolveDevices(resolve))
+	require.Equal(t, []string{"/dev/sdb", "/dev/sdc"}, cfg.Wake[0].Devices)
+	require.Empty(t, cfg.Wake[1].Devices)
+	require.Equal(t, []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}, cfg.Inhibit[0].Devices)
+	require.Equal(t, map[string]power.Spec{
+		"/dev/sda": {Class: power.ClassDesktop},
+		"/dev/sdb": {Class: power.ClassNAS},
+		"/dev/sdc": {Class: power.ClassNAS},
+	}, cfg.Power.Devices)
+
+	cfg = &Config{Power: power.Config{Devices: map[string]power.Spec{"/nowhere": {}}}}
+	require.ErrorContains(t, cfg.ResolveDevices(resolve), "power: /nowhere: not mounted")
+}
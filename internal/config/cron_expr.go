@@ -1,4 +1,4 @@
-package daemon
+package config
 
 import (
 	"fmt"
@@ -68,6 +68,11 @@ func (ce *CronExpr) Set(value string) error {
 	return ce.Parse(value)
 }
 
+// UnmarshalText implements encoding.TextUnmarshaler, used by YAML config files
+func (ce *CronExpr) UnmarshalText(text []byte) error {
+	return ce.Parse(string(text))
+}
+
 // Type implements pflag.Value interface (optional, for better help text)
 func (ce *CronExpr) Type() string {
 	return "hour min"
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// APMRule sets the APM level of every drive daily at a given time, e.g.
+// performance (254) in the morning and power saving (127) overnight.
+type APMRule struct {
+	At    CronExpr `yaml:"time"`
+	Level int      `yaml:"level"`
+}
+
+// Validate checks the level is a valid hdparm -B value.
+func (r APMRule) Validate() error {
+	if r.Level < 1 || r.Level > hw.APMDisabled {
+		return fmt.Errorf("invalid APM level %d at %s: must be 1-%d", r.Level, &r.At, hw.APMDisabled)
+	}
+	return nil
+}
+
+// EPCRule makes Timers, from a given time daily, the multi-stage power policy
+// armed at the scheduled windows, e.g. a short idle_b and standby_z overnight
+// and a long standby_z during the day. A rule without timers arms the standby
+// timer instead.
+type EPCRule struct {
+	At     CronExpr     `yaml:"time"`
+	Timers hw.EPCTimers `yaml:",inline"`
+}
+
+// Validate checks the timers of the rule.
+func (r EPCRule) Validate() error {
+	if err := r.Timers.Validate(); err != nil {
+		return fmt.Errorf("at %s: %w", &r.At, err)
+	}
+	return nil
+}
+
+// WakeRule spins devices up daily ahead of a known workload, e.g. a backup,
+// and holds them spinning for a while so the workload does not wait for
+// sequential spin-ups.
+type WakeRule struct {
+	// At is when the workload starts
+	At CronExpr `yaml:"time"`
+	// Lead is how long before At the devices are spun up
+	Lead time.Duration `yaml:"lead"`
+	// Hold is how long after At the devices are kept spinning
+	Hold time.Duration `yaml:"hold"`
+	// Devices to wake, all monitored devices when empty
+	Devices []string `yaml:"devices"`
+}
+
+// Validate checks the lead and hold durations.
+func (r WakeRule) Validate() error {
+	if r.Lead < 0 || r.Lead >= 24*time.Hour {
+		return fmt.Errorf("invalid wake lead %s at %s: must be 0-24h", r.Lead, &r.At)
+	}
+	if r.Hold <= 0 {
+		return fmt.Errorf("invalid wake hold %s at %s: must be positive", r.Hold, &r.At)
+	}
+	return nil
+}
+
+// Inhibitor keeps devices spinning while a condition holds: a process is
+// running, a systemd unit is active, a lock file exists or a file is open
+// under a directory. Exactly one condition is set.
+type Inhibitor struct {
+	// Process is a shell pattern matched against the command names of the
+	// running processes, e.g. "ffmpeg*"
+	Process string `yaml:"process"`
+	// Unit is a systemd unit, e.g. "backup.service"
+	Unit string `yaml:"unit"`
+	// LockFile is the absolute path of a file whose existence inhibits
+	LockFile string `yaml:"lock_file"`
+	// OpenUnder is an absolute directory, usually a mount point, under which
+	// any process having a file open inhibits
+	OpenUnder string `yaml:"open_under"`
+	// Devices to keep spinning, all monitored devices when empty
+	Devices []string `yaml:"devices"`
+}
+
+// Validate checks exactly one condition is set and well formed.
+func (i Inhibitor) Validate() error {
+	set := 0
+	for _, c := range []string{i.Process, i.Unit, i.LockFile, i.OpenUnder} {
+		if c != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("inhibitor sets %d conditions: expected exactly one of process, unit, lock_file and open_under", set)
+	}
+	if _, err := path.Match(i.Process, ""); err != nil {
+		return fmt.Errorf("invalid process pattern %q: %w", i.Process, err)
+	}
+	for _, p := range []string{i.LockFile, i.OpenUnder} {
+		if p != "" && !path.IsAbs(p) {
+			return fmt.Errorf("inhibitor path %q is not absolute", p)
+		}
+	}
+	return nil
+}
+
+// Stagger spreads commands over several devices in time, so that an
+// enclosure full of drives does not spin them all up at once and overload its
+// power supply with their inrush current.
+type Stagger struct {
+	// Delay is the pause between two batches of devices
+	Delay time.Duration `yaml:"delay"`
+	// MaxConcurrent is the number of devices of a batch, 0 for all of them
+	MaxConcurrent int `yaml:"max_concurrent"`
+}
+
+// Validate checks the delay and batch size are not negative.
+func (s Stagger) Validate() error {
+	if s.Delay < 0 {
+		return fmt.Errorf("invalid stagger delay %s", s.Delay)
+	}
+	if s.MaxConcurrent < 0 {
+		return fmt.Errorf("invalid stagger max_concurrent %d", s.MaxConcurrent)
+	}
+	return nil
+}
+
+// Batches splits devs into consecutive batches of at most MaxConcurrent
+// devices.
+func (s Stagger) Batches(devs []string) [][]string {
+	size := s.MaxConcurrent
+	if size <= 0 {
+		size = len(devs)
+	}
+	var batches [][]string
+	for len(devs) > 0 {
+		n := min(size, len(devs))
+		batches = append(batches, devs[:n])
+		devs = devs[n:]
+	}
+	return batches
+}
+
+// Retry configures the retries of the failed commands changing the power
+// settings of a device: the standby timer, the EPC timers and the APM level.
+type Retry struct {
+	// Delay before the first retry, doubled after each failed retry up to
+	// MaxDelay. A random jitter of up to half the delay is taken off.
+	Delay    time.Duration `yaml:"delay"`
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// Attempts is the number of retries after which the device is marked
+	// degraded and its commands are no longer retried
+	Attempts int `yaml:"attempts"`
+}
+
+// Validate checks the settings are not negative.
+func (c Retry) Validate() error {
+	if c.Delay < 0 {
+		return fmt.Errorf("invalid retry delay %s", c.Delay)
+	}
+	if c.MaxDelay < 0 {
+		return fmt.Errorf("invalid retry max_delay %s", c.MaxDelay)
+	}
+	if c.Attempts < 0 {
+		return fmt.Errorf("invalid retry attempts %d", c.Attempts)
+	}
+	return nil
+}
+
+// Drift configures the verification of the settings applied by the daemon,
+// which udisks, tlp, hd-idle or /etc/hdparm.conf may change behind its back.
+type Drift struct {
+	// Interval between two verifications of the spinning devices, 0
+	// disables them. Drifted standby timers are found whatever the interval.
+	Interval time.Duration `yaml:"interval"`
+	// Reassert applies the intended setting again on drift
+	Reassert bool `yaml:"reassert"`
+	// ReassertEvery is the minimum time between two re-assertions of a
+	// setting on a device, so that the daemon does not fight another
+	// manager in a loop
+	ReassertEvery time.Duration `yaml:"reassert_every"`
+}
+
+// Validate checks the durations are not negative.
+func (c Drift) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("invalid drift interval %s", c.Interval)
+	}
+	if c.ReassertEvery < 0 {
+		return fmt.Errorf("invalid drift reassert_every %s", c.ReassertEvery)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStagger_Batches(t *testing.T) {
+	devs := []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		want          [][]string
+	}{
+		{name: "unlimited", want: [][]string{devs}},
+		{name: "one at a time", maxConcurrent: 1, want: [][]string{{"/dev/sda"}, {"/dev/sdb"}, {"/dev/sdc"}}},
+		{name: "pairs", maxConcurrent: 2, want: [][]string{{"/dev/sda", "/dev/sdb"}, {"/dev/sdc"}}},
+		{name: "larger than devices", maxConcurrent: 5, want: [][]string{devs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Stagger{MaxConcurrent: tt.maxConcurrent}.Batches(devs))
+		})
+	}
+	require.Empty(t, Stagger{}.Batches(nil))
+	require.Error(t, Stagger{Delay: -time.Second}.Validate())
+	require.Error(t, Stagger{MaxConcurrent: -1}.Validate())
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// nextAPMRule returns the first rule firing after t and when it fires, or
+// nil if there are no rules.
+func nextAPMRule(rules []config.APMRule, t time.Time) (time.Time, *config.APMRule) {
+	var next time.Time
+	var rule *config.APMRule
+	for i := range rules {
+		if at := rules[i].At.Next(t); rule == nil || at.Before(next) {
+			next, rule = at, &rules[i]
+		}
+	}
+	return next, rule
+}
+
+// currentAPMRule returns the rule that fired last before t, or nil if there
+// are no rules.
+func currentAPMRule(rules []config.APMRule, t time.Time) *config.APMRule {
+	var last time.Time
+	var rule *config.APMRule
+	for i := range rules {
+		if at := rules[i].At.Next(t).Add(-24 * time.Hour); rule == nil || at.After(last) {
+			last, rule = at, &rules[i]
+		}
+	}
+	return rule
+}
+
+// applyAPMRule makes level the wanted APM level and sets it on every active
+// device. Spun down devices get it when they wake up, since the command may
+// spin them up.
+func (d *Daemon) applyAPMRule(level int) {
+	d.apmLevel = level
+	logrus.Infof("set APM level: value=%d", level)
+	var devs []string
+	for _, dev := range d.getActiveDevices() {
+		if !d.noAPM[dev] {
+			devs = append(devs, dev)
+		}
+	}
+	sort.Strings(devs)
+	d.staggered("set APM level", devs, func(dev string) func() {
+		err := d.controller.SetAPM(dev, level)
+		return func() { d.apmSet(dev, err) }
+	})
+}
+
+// reapplyAPM restores the wanted APM level on dev, which drives tend to
+// forget across spin-ups and resets.
+func (d *Daemon) reapplyAPM(dev string) {
+	if d.apmLevel == 0 || d.noAPM[dev] {
+		return
+	}
+	level, err := d.controller.GetAPM(dev)
+	if err != nil {
+		d.apmFailed(dev, err)
+		return
+	}
+	if level == d.apmLevel {
+		return
+	}
+	logrus.Infof("device %s APM level is %d, setting %d", dev, level, d.apmLevel)
+	d.setAPM(dev)
+}
+
+// setAPM sets the wanted APM level on dev and retries it later if it fails.
+func (d *Daemon) setAPM(dev string) {
+	d.apmSet(dev, d.controller.SetAPM(dev, d.apmLevel))
+}
+
+// apmRounding is the APM level a drive reports after being set to another
+// one it does not support.
+type apmRounding struct {
+	want, found int
+}
+
+// apmSet records the outcome err of setting the wanted APM level on dev.
+func (d *Daemon) apmSet(dev string, err error) {
+	if errors.Is(err, hw.ErrAPMUnsupported) {
+		d.apmFailed(dev, err)
+		return
+	}
+	if errors.Is(err, hw.ErrNotApplied) {
+		// remember the level the drive rounded to, not to report it as drifted
+		if level, gerr := d.controller.GetAPM(dev); gerr == nil {
+			d.apmRounded[dev] = apmRounding{want: d.apmLevel, found: level}
+		}
+	}
+	set := func() error { return d.controller.SetAPM(dev, d.apmLevel) }
+	d.settle(retryKey{dev: dev, kind: retryAPM}, "set APM level", set, err)
+}
+
+// apmApplied tells whether level, read back from dev, is the wanted APM
+// level or the one the drive rounds it to.
+func (d *Daemon) apmApplied(dev string, level int) bool {
+	r, ok := d.apmRounded[dev]
+	return level == d.apmLevel || ok && r.want == d.apmLevel && r.found == level
+}
+
+// apmFailed logs an APM error and stops managing APM on drives lacking it.
+func (d *Daemon) apmFailed(dev string, err error) {
+	if errors.Is(err, hw.ErrAPMUnsupported) {
+		logrus.Infof("device %s does not support APM", dev)
+		d.noAPM[dev] = true
+		return
+	}
+	commandFailed("manage APM", dev, err)
+}
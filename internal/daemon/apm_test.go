@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+var testAPMRules = []config.APMRule{
+	{At: config.CronExpr{Hour: 7, Min: 30}, Level: 254},
+	{At: config.CronExpr{Hour: 22, Min: 0}, Level: 127},
+}
+
+func TestAPMRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		now         time.Time
+		wantNext    time.Time
+		wantNextLvl int
+		wantCurLvl  int
+	}{
+		{
+			name:        "overnight",
+			now:         time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC),
+			wantNext:    time.Date(2025, 1, 2, 7, 30, 0, 0, time.UTC),
+			wantNextLvl: 254,
+			wantCurLvl:  127,
+		},
+		{
+			name:        "daytime",
+			now:         time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC),
+			wantNext:    time.Date(2025, 1, 2, 22, 0, 0, 0, time.UTC),
+			wantNextLvl: 127,
+			wantCurLvl:  254,
+		},
+		{
+			name:        "at rule time",
+			now:         time.Date(2025, 1, 2, 22, 0, 0, 0, time.UTC),
+			wantNext:    time.Date(2025, 1, 3, 7, 30, 0, 0, time.UTC),
+			wantNextLvl: 254,
+			wantCurLvl:  127,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, rule := nextAPMRule(testAPMRules, tt.now)
+			require.Equal(t, tt.wantNext, next)
+			require.Equal(t, tt.wantNextLvl, rule.Level)
+			require.Equal(t, tt.wantCurLvl, currentAPMRule(testAPMRules, tt.now).Level)
+		})
+	}
+
+	_, rule := nextAPMRule(nil, time.Now())
+	require.Nil(t, rule)
+	require.Nil(t, currentAPMRule(nil, time.Now()))
+}
+
+func TestDaemon_APM(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{StandbyValue: 120, APM: testAPMRules}, mockCtrl)
+	d.apmLevel = 254
+
+	// first seen: restore the level of the current rule, skip drives without APM
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sda").Return(128, nil).Once()
+	mockCtrl.EXPECT().SetAPM("/dev/sda", 254).Return(nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdb").Return(0, fmt.Errorf("/dev/sdb: %w", hw.ErrAPMUnsupported)).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdc").Return(hw.DriveStateStandby, nil).Once()
+	d.scan([]string{"/dev/sda", "/dev/sdb", "/dev/sdc"})
+
+	// overnight rule: only active drives supporting APM
+	mockCtrl.EXPECT().SetAPM("/dev/sda", 127).Return(nil).Once()
+	d.applyAPMRule(127)
+
+	// spin-up: the drive forgot its level
+	mockCtrl.EXPECT().GetState("/dev/sdc").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdc", 0).Return(nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdc").Return(254, nil).Once()
+	mockCtrl.EXPECT().SetAPM("/dev/sdc", 127).Return(nil).Once()
+	d.scan([]string{"/dev/sdc"})
+
+	// spin-up keeping its level
+	mockCtrl.EXPECT().GetState("/dev/sdc").Return(hw.DriveStateStandby, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdc").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdc", 0).Return(nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdc").Return(127, nil).Once()
+	d.scan([]string{"/dev/sdc"})
+	d.scan([]string{"/dev/sdc"})
+}
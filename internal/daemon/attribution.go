@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/attribution"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// maxWakes is the number of recent wake-ups kept for the status.
+const maxWakes = 32
+
+// WakeEvent is a spun down device seen spinning again.
+type WakeEvent struct {
+	Device string    `json:"device"`
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	// Culprits are the processes that accessed the device since the
+	// previous poll, empty without wake attribution or when none was found
+	Culprits []attribution.Culprit `json:"culprits,omitempty"`
+}
+
+// recordWake records the spin-up of dev found by scan at now, with the
+// processes that woke it when attribution is enabled.
+func (d *Daemon) recordWake(dev, from, to string, now time.Time) {
+	ev := WakeEvent{Device: dev, Time: now, From: from, To: to}
+	if d.tracer != nil {
+		ev.Culprits = d.tracer.Culprits(dev, d.lastScan)
+		if len(ev.Culprits) == 0 {
+			logrus.Infof("device %s woken by an unknown process", dev)
+		}
+		for _, c := range ev.Culprits {
+			logrus.Infof("device %s woken by %s", dev, c)
+		}
+	}
+	d.wakes = append(d.wakes, ev)
+	if len(d.wakes) > maxWakes {
+		d.wakes = d.wakes[len(d.wakes)-maxWakes:]
+	}
+}
+
+// sampleIO takes the I/O baseline of the tracer while any device is spun
+// down, so that the processes waking it can be told apart.
+func (d *Daemon) sampleIO(devs []string) {
+	if d.tracer == nil {
+		return
+	}
+	for _, dev := range devs {
+		if state, ok := d.last[dev]; ok && hw.IsSpunDown(state) {
+			d.tracer.Sample()
+			return
+		}
+	}
+}
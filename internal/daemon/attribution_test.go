@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/attribution"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTracer blames fixed culprits and records the calls of the daemon.
+type fakeTracer struct {
+	culprits map[string][]attribution.Culprit
+	samples  int
+	since    []time.Time
+}
+
+func (f *fakeTracer) Sample() { f.samples++ }
+
+func (f *fakeTracer) Culprits(dev string, since time.Time) []attribution.Culprit {
+	f.since = append(f.since, since)
+	return f.culprits[dev]
+}
+
+func (f *fakeTracer) Close() error { return nil }
+
+func TestDaemon_recordWake(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandby, nil).Twice()
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Times(3)
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+
+	now := time.Date(2025, 1, 1, 4, 0, 0, 0, time.UTC)
+	culprit := attribution.Culprit{PID: 42, Comm: "updatedb", Path: "/mnt/data/x", Accesses: 3}
+	tracer := &fakeTracer{culprits: map[string][]attribution.Culprit{"/dev/sda": {culprit}}}
+	d := newDaemon(Config{Devices: []string{"/dev/sda", "/dev/sdb"}}, mockCtrl)
+	d.now = func() time.Time { return now }
+	d.tracer = tracer
+
+	devs := []string{"/dev/sda", "/dev/sdb"}
+	d.scan(devs)
+	d.scan(devs)
+	now = now.Add(10 * time.Second)
+	d.scan(devs)
+
+	// sampled while /dev/sda was spun down, blamed since the previous scan
+	require.Equal(t, 2, tracer.samples)
+	require.Equal(t, []time.Time{now.Add(-10 * time.Second)}, tracer.since)
+	require.Equal(t, []WakeEvent{{
+		Device:   "/dev/sda",
+		Time:     now,
+		From:     hw.DriveStateStandby,
+		To:       hw.DriveStateActive,
+		Culprits: []attribution.Culprit{culprit},
+	}}, d.status().Wakes)
+}
+
+func TestDaemon_recordWake_Ring(t *testing.T) {
+	d := newDaemon(Config{}, hw.NewMockHDDControl(t))
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range maxWakes + 3 {
+		d.recordWake("/dev/sda", hw.DriveStateStandby, hw.DriveStateActive, start.Add(time.Duration(i)*time.Minute))
+	}
+	require.Len(t, d.wakes, maxWakes)
+	require.Equal(t, start.Add(3*time.Minute), d.wakes[0].Time)
+}
@@ -0,0 +1,305 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultSocket is the default path of the control socket.
+const DefaultSocket = "/run/hd-smart-idle.sock"
+
+// errSocketInUse is returned by listenControl when another daemon answers on
+// the control socket.
+var errSocketInUse = errors.New("control socket in use by another instance")
+
+// listenControl listens on the unix socket at path. A socket left behind by
+// a previous instance is replaced, one another instance answers on is not.
+func listenControl(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			// nolint:errcheck
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", path, errSocketInUse)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serveControl serves the status, metrics and leases of the daemon over
+// HTTP on the control socket ln until ctx is done:
+//
+//	GET /status           Status as JSON
+//	GET /metrics          Prometheus text exposition
+//	POST /leases          acquire a Lease with a LeaseRequest
+//	PUT /leases/{id}      renew it with a LeaseRequest carrying a TTL
+//	DELETE /leases/{id}   release it
+func (d *Daemon) serveControl(ctx context.Context, ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		st, err := d.requestStatus(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// nolint:errcheck
+		json.NewEncoder(w).Encode(st)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		st, err := d.requestStatus(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, st)
+	})
+
+	mux.HandleFunc("POST /leases", func(w http.ResponseWriter, r *http.Request) {
+		d.handleLease(w, r, leaseOp{})
+	})
+	mux.HandleFunc("PUT /leases/{id}", func(w http.ResponseWriter, r *http.Request) {
+		d.handleLease(w, r, leaseOp{id: r.PathValue("id")})
+	})
+	mux.HandleFunc("DELETE /leases/{id}", func(w http.ResponseWriter, r *http.Request) {
+		d.handleLease(w, r, leaseOp{id: r.PathValue("id"), release: true})
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		// nolint:errcheck
+		srv.Close()
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handleLease decodes the LeaseRequest body of r, except on release, runs
+// op in the main loop and replies with the lease.
+func (d *Daemon) handleLease(w http.ResponseWriter, r *http.Request, op leaseOp) {
+	if !op.release {
+		if err := json.NewDecoder(r.Body).Decode(&op.req); err != nil {
+			http.Error(w, fmt.Sprintf("malformed lease request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	lease, err := d.requestLease(r.Context(), op)
+	switch {
+	case errors.Is(err, ErrLeaseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInvalidLease):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// nolint:errcheck
+	json.NewEncoder(w).Encode(lease)
+}
+
+// requestStatus asks the main loop for a snapshot.
+func (d *Daemon) requestStatus(ctx context.Context) (Status, error) {
+	reply := make(chan Status, 1)
+	select {
+	case d.statusCh <- reply:
+	case <-ctx.Done():
+		return Status{}, ctx.Err()
+	}
+	select {
+	case st := <-reply:
+		return st, nil
+	case <-ctx.Done():
+		return Status{}, ctx.Err()
+	}
+}
+
+// writeMetrics writes st in the Prometheus text format.
+func writeMetrics(w io.Writer, st Status) {
+	fmt.Fprintln(w, "# HELP hd_smart_idle_device_state Last polled power mode of the device.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_device_state gauge")
+	for _, dev := range st.Devices {
+		if dev.State != "" {
+			fmt.Fprintf(w, "hd_smart_idle_device_state{device=%q,state=%q} 1\n", dev.Device, dev.State)
+		}
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_group_spinning_members Members of the group last seen spinning.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_group_spinning_members gauge")
+	for _, g := range st.Groups {
+		fmt.Fprintf(w, "hd_smart_idle_group_spinning_members{group=%q} %d\n", g.Name, g.Spinning)
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_spin_ups_total Spin-ups observed since the daemon started.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_spin_ups_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_spin_ups_total{device=%q} %d\n", dev.Device, dev.Usage.SpinUps)
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_mode_seconds_total Time spent in each power mode.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_mode_seconds_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_mode_seconds_total{device=%q,mode=\"active\"} %g\n", dev.Device, dev.Usage.Active.Seconds())
+		fmt.Fprintf(w, "hd_smart_idle_mode_seconds_total{device=%q,mode=\"idle\"} %g\n", dev.Device, dev.Usage.Idle.Seconds())
+		fmt.Fprintf(w, "hd_smart_idle_mode_seconds_total{device=%q,mode=\"standby\"} %g\n", dev.Device, dev.Usage.Standby.Seconds())
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_energy_joules_total Estimated energy consumed.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_energy_joules_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_energy_joules_total{device=%q} %g\n", dev.Device, dev.Usage.Joules)
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_baseline_energy_joules_total Estimated energy of an always spinning drive.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_baseline_energy_joules_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_baseline_energy_joules_total{device=%q} %g\n", dev.Device, dev.Usage.BaselineJoules)
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_would_wake_total Commands refused because they would have woken the device.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_would_wake_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_would_wake_total{device=%q} %d\n", dev.Device, dev.WouldWake)
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_drifts_total Applied settings found changed by another power manager.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_drifts_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_drifts_total{device=%q} %d\n", dev.Device, dev.Drifts)
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_setting_mismatches_total Settings accepted by the device but not applied; standby timer values are never read back.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_setting_mismatches_total counter")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_setting_mismatches_total{device=%q} %d\n", dev.Device, dev.Mismatches)
+	}
+	pending := make(map[string]int)
+	for _, r := range st.Retries {
+		pending[r.Device]++
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_pending_retries Failed commands waiting for their retry.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_pending_retries gauge")
+	for _, dev := range st.Devices {
+		fmt.Fprintf(w, "hd_smart_idle_pending_retries{device=%q} %d\n", dev.Device, pending[dev.Device])
+	}
+	fmt.Fprintln(w, "# HELP hd_smart_idle_degraded Whether the commands of the device failed too many times to be retried.")
+	fmt.Fprintln(w, "# TYPE hd_smart_idle_degraded gauge")
+	for _, dev := range st.Devices {
+		degraded := 0
+		if dev.Degraded != "" {
+			degraded = 1
+		}
+		fmt.Fprintf(w, "hd_smart_idle_degraded{device=%q} %d\n", dev.Device, degraded)
+	}
+}
+
+// Client talks to a running daemon over its control socket.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a Client for the control socket at path.
+func NewClient(path string) *Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &Client{http: &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// get issues GET on the control socket and returns the response body.
+func (c *Client) get(endpoint string) ([]byte, error) {
+	return c.do(http.MethodGet, endpoint, nil)
+}
+
+// do issues a request with body encoded as JSON, if any, on the control
+// socket and returns the response body.
+func (c *Client) do(method, endpoint string, body any) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://hd-smart-idle"+endpoint, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, bytes.TrimSpace(respBody))
+	}
+	return respBody, nil
+}
+
+// Status fetches the status of the daemon.
+func (c *Client) Status() (*Status, error) {
+	body, err := c.get("/status")
+	if err != nil {
+		return nil, err
+	}
+	st := &Status{}
+	if err := json.Unmarshal(body, st); err != nil {
+		return nil, fmt.Errorf("malformed status: %w", err)
+	}
+	return st, nil
+}
+
+// Metrics fetches the metrics of the daemon in the Prometheus text format.
+func (c *Client) Metrics() (string, error) {
+	body, err := c.get("/metrics")
+	return string(body), err
+}
+
+// Acquire takes a lease keeping devs, all monitored devices when empty,
+// spinning for ttl unless renewed.
+func (c *Client) Acquire(devs []string, ttl time.Duration, owner string) (*Lease, error) {
+	return c.lease(http.MethodPost, "/leases", LeaseRequest{Devices: devs, TTL: ttl.String(), Owner: owner})
+}
+
+// Renew extends the lease id to ttl from now.
+func (c *Client) Renew(id string, ttl time.Duration) (*Lease, error) {
+	return c.lease(http.MethodPut, "/leases/"+url.PathEscape(id), LeaseRequest{TTL: ttl.String()})
+}
+
+// Release drops the lease id.
+func (c *Client) Release(id string) error {
+	_, err := c.do(http.MethodDelete, "/leases/"+url.PathEscape(id), nil)
+	return err
+}
+
+func (c *Client) lease(method, endpoint string, req LeaseRequest) (*Lease, error) {
+	body, err := c.do(method, endpoint, req)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{}
+	if err := json.Unmarshal(body, l); err != nil {
+		return nil, fmt.Errorf("malformed lease: %w", err)
+	}
+	return l, nil
+}
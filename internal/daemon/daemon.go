@@ -2,23 +2,70 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
 	"sort"
 	"syscall"
 	"time"
 
+	"github.com/chain710/hd-smart-idle/internal/attribution"
+	"github.com/chain710/hd-smart-idle/internal/config"
 	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/managers"
+	"github.com/chain710/hd-smart-idle/internal/policy"
+	"github.com/chain710/hd-smart-idle/internal/power"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
 	Devices      []string
 	PollInterval time.Duration
-	Cron         *CronExpr
+	Cron         *config.CronExpr
 	StandbyValue int
 	DryRun       bool
+	// Adaptive, when set, replaces StandbyValue with a per-device timeout
+	// learned from observed access gaps at each scheduled window.
+	Adaptive *policy.AdaptiveConfig
+	// Controller overrides the hdparm backed hw.NewHDDControl, e.g. with a
+	// simulated backend.
+	Controller hw.HDDControl
+	// Quirks are user supplied USB bridge quirks, preferred over hw.DefaultQuirks
+	Quirks []hw.Quirk
+	// EPC are the daily rules choosing the multi-stage power policy armed at
+	// each scheduled window on drives supporting Extended Power Conditions,
+	// in place of the standby timer. Other drives keep using StandbyValue.
+	EPC []config.EPCRule
+	// APM are the daily APM level rules. The level of the last rule fired is
+	// also restored whenever a drive spins up.
+	APM []config.APMRule
+	// Wake are the daily rules spinning devices up ahead of known workloads
+	Wake []config.WakeRule
+	// Inhibit are the conditions keeping devices spinning while they hold
+	Inhibit []config.Inhibitor
+	// Power selects the power model of each device for the energy report
+	Power power.Config
+	// Socket is the path of the control socket serving status and metrics,
+	// disabled when empty
+	Socket string
+	// Stagger spreads the scheduled commands over the devices in time
+	Stagger config.Stagger
+	// Filters select the disks found by auto-discovery when Devices is empty
+	Filters hw.Filters
+	// Arrays are the RAID arrays, pools and filesystems whose monitored
+	// members are managed as a group
+	Arrays []hw.Array
+	// Attribution, when set, finds the processes that woke each device up
+	Attribution bool
+	// Retry configures the retries of the failed commands
+	Retry config.Retry
+	// Drift verifies the applied settings were not changed by another
+	// power manager
+	Drift config.Drift
 }
 
 type Daemon struct {
@@ -26,29 +73,219 @@ type Daemon struct {
 	controller hw.HDDControl
 	// device -> last known state
 	last map[string]string
+	// nil unless Config.Adaptive is set
+	adaptive *policy.Adaptive
+	// device -> last observed I/O count, only tracked for the adaptive policy
+	ioCounts map[string]uint64
+	// device -> whether its I/O count moved at the previous poll
+	ioBusy map[string]bool
+	// device -> whether its EPC timers are armed; devices without EPC map to false
+	epc map[string]bool
+	// wanted APM level, 0 when APM is not managed
+	apmLevel int
+	// devices found without APM
+	noAPM map[string]bool
+	// device -> APM level it reports instead of the wanted one
+	apmRounded map[string]apmRounding
+	// device -> power settings last applied, restored after resets
+	applied map[string]settings
+	// devices whose timers the daemon failed to disable
+	stuckArmed map[string]bool
+	// devices whose settings must be reapplied, "" for all of them
+	reapplyCh chan string
+	// safety layer of the controller, nil when not wrapped
+	safety *hw.SafeHDDControl
+	// layer of the controller reading back applied settings, nil when not
+	// wrapped
+	verifier *hw.VerifiedHDDControl
+	// device -> reason -> end of the holds keeping it spinning
+	holds map[string]map[string]time.Time
+	// held devices whose policy is armed once released
+	rearm map[string]bool
+	// whether the condition of each of Config.Inhibit held at the last
+	// poll; inhibitors sharing a condition may hold different devices
+	inhibited []bool
+	probes    probes
+	// device -> group it belongs to, if any
+	groups map[string]*group
+	// device -> transport, to pick the default power model
+	transports map[string]string
+	meter      *power.Meter
+	// usage at the last daily energy summary
+	summarized map[string]power.Usage
+	// status requests of the control socket, served by the main loop
+	statusCh chan chan Status
+	// lease operations of the control socket, served by the main loop
+	leaseCh chan leaseOp
+	// lease id -> lease of a control socket client
+	leases map[string]*Lease
+	// finds the processes waking devices, nil unless Config.Attribution is set
+	tracer attribution.Tracer
+	// recent wake-ups, oldest first
+	wakes []WakeEvent
+	// time of the previous scan
+	lastScan time.Time
+	// recent drifts of the applied settings, oldest first
+	drifts []DriftEvent
+	// device -> drifts found since the daemon started
+	driftCount map[string]int
+	// last time a drifted setting was reasserted
+	reasserted map[driftKey]time.Time
+	// failed commands waiting for their retry
+	retries map[retryKey]*pendingRetry
+	// device -> last failure of the device whose commands are no longer
+	// retried
+	degraded map[string]string
+	// commands whose setting the drive did not apply, logged once
+	notApplied map[retryKey]bool
+	// jitter returns a random duration below its argument
+	jitter func(time.Duration) time.Duration
+	// managers finds the other power managers, the likely cause of drifts
+	managers func() []managers.Manager
+	// mountsOf returns the mount points on a device, for the status
+	mountsOf func(dev string) []string
+	// now is the daemon clock, replaced by a virtual clock in simulations
+	now func() time.Time
+	// after returns a channel firing at a time of the daemon clock
+	after func(time.Time) <-chan time.Time
+	// staggered operations running or waiting for their next batch
+	staggerings []*staggering
+	// async runs the job of a device of a staggered batch, and its
+	// bookkeeping on the main loop
+	async func(job func() (done func()))
+	// doneCh carries the bookkeeping of the jobs run in goroutines
+	doneCh chan func()
 }
 
-func New(cfg Config) (*Daemon, error) {
-	var controller = hw.NewHDDControl()
+func newDaemon(cfg Config, controller hw.HDDControl) *Daemon {
+	d := &Daemon{
+		cfg:        cfg,
+		controller: controller,
+		last:       make(map[string]string),
+		ioCounts:   make(map[string]uint64),
+		ioBusy:     make(map[string]bool),
+		epc:        make(map[string]bool),
+		noAPM:      make(map[string]bool),
+		apmRounded: make(map[string]apmRounding),
+		applied:    make(map[string]settings),
+		stuckArmed: make(map[string]bool),
+		reapplyCh:  make(chan string, 16),
+		transports: make(map[string]string),
+		summarized: make(map[string]power.Usage),
+		statusCh:   make(chan chan Status),
+		leaseCh:    make(chan leaseOp),
+		leases:     make(map[string]*Lease),
+		holds:      make(map[string]map[string]time.Time),
+		rearm:      make(map[string]bool),
+		inhibited:  make([]bool, len(cfg.Inhibit)),
+		driftCount: make(map[string]int),
+		reasserted: make(map[driftKey]time.Time),
+		retries:    make(map[retryKey]*pendingRetry),
+		degraded:   make(map[string]string),
+		notApplied: make(map[retryKey]bool),
+		jitter:     randomJitter,
+		managers:   managers.Detect,
+		probes:     systemProbes(),
+		groups:     newGroups(cfg.Arrays, cfg.Devices),
+		now:        time.Now,
+		after:      afterClock,
+		async:      runInline,
+		doneCh:     make(chan func()),
+	}
+	d.meter = power.NewMeter(func(dev string) power.Model {
+		return cfg.Power.Model(dev, d.transports[dev])
+	})
+	if rule := currentAPMRule(cfg.APM, d.now()); rule != nil {
+		d.apmLevel = rule.Level
+	}
+	if cfg.Adaptive != nil {
+		d.adaptive = policy.NewAdaptive(*cfg.Adaptive)
+	}
+	return d
+}
 
-	// Honor DryRun by wrapping the controller with a dry-run wrapper.
-	if cfg.DryRun {
-		controller = hw.NewDryRunHDDControl(controller)
+func New(cfg Config) (*Daemon, error) {
+	var controller = cfg.Controller
+	if controller == nil {
+		controller = hw.NewHDDControl(cfg.Quirks...)
 	}
+	controller, safety, verifier := wrapController(cfg, controller, os.DirFS("/"))
 
+	transports := make(map[string]string)
 	if len(cfg.Devices) == 0 {
-		disks, err := controller.List()
+		disks, err := discoverDisks(cfg, controller)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list devices: %w", err)
 		}
-		cfg.Devices = disks
+		for _, disk := range disks {
+			if disk.Quirk != nil {
+				logrus.Infof("device %s: usb bridge %s, applying quirk %q", disk.Path, disk.USBID, disk.Quirk.Name)
+			}
+			transports[disk.Path] = disk.Transport
+		}
+		cfg.Devices = hw.DiskPaths(disks)
+	} else {
+		for _, dev := range cfg.Devices {
+			transports[dev] = hw.DetectTransport(os.DirFS("/"), dev)
+		}
 	}
 
-	return &Daemon{
-		cfg:        cfg,
-		controller: controller,
-		last:       make(map[string]string),
-	}, nil
+	if err := checkWakeDevices(cfg.Wake, cfg.Devices); err != nil {
+		return nil, err
+	}
+	if err := checkInhibitDevices(cfg.Inhibit, cfg.Devices); err != nil {
+		return nil, err
+	}
+
+	d := newDaemon(cfg, controller)
+	d.async = d.runAsync
+	d.safety = safety
+	d.verifier = verifier
+	d.transports = transports
+	d.mountsOf = func(dev string) []string { return hw.MountPoints(os.DirFS("/"), dev) }
+	if cfg.Attribution {
+		d.tracer = attribution.New(cfg.Devices)
+	}
+	return d, nil
+}
+
+// wrapController returns controller wrapped with the safety layer reading
+// sysfs from fsys, the read back of applied settings and, with DryRun, the
+// dry-run wrapper, along with the safety and read back layers.
+func wrapController(cfg Config, controller hw.HDDControl, fsys fs.FS) (hw.HDDControl, *hw.SafeHDDControl, *hw.VerifiedHDDControl) {
+	// Never wake a spun down disk, whatever the last polled state says.
+	safety := hw.NewSafeHDDControlFS(controller, fsys, false)
+	controller = safety
+
+	// Read back every setting applied, drives may silently ignore them.
+	verifier := hw.NewVerifiedHDDControl(controller)
+	controller = verifier
+
+	// Honor DryRun by wrapping the controller with a dry-run wrapper.
+	if cfg.DryRun {
+		controller = hw.NewDryRunHDDControl(controller)
+	}
+	return controller, safety, verifier
+}
+
+// discoverDisks returns the disks selected by the discovery filters, or
+// the ones listed by a controller overriding the hardware.
+func discoverDisks(cfg Config, controller hw.HDDControl) ([]hw.Disk, error) {
+	if cfg.Controller != nil {
+		return controller.List()
+	}
+	candidates, err := hw.Discover(cfg.Filters, cfg.Quirks...)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range candidates {
+		if !c.Selected {
+			logrus.Infof("device %s skipped: %s", c.Path, c.Reason)
+		} else if c.Reason != "rotational" {
+			logrus.Infof("device %s %s", c.Path, c.Reason)
+		}
+	}
+	return hw.Selected(candidates), nil
 }
 
 // Run starts the daemon loops and blocks until error or context cancel
@@ -57,10 +294,26 @@ func (d *Daemon) Run() error {
 		return fmt.Errorf("nil cron expression")
 	}
 
+	// two daemons would fight over the timers of the same disks
+	var control net.Listener
+	if d.cfg.Socket != "" {
+		var err error
+		control, err = listenControl(d.cfg.Socket)
+		switch {
+		case errors.Is(err, errSocketInUse):
+			return err
+		case err != nil:
+			logrus.Warnf("control socket unavailable, no status and metrics: %v", err)
+		}
+	}
+
 	// canonicalize devices
 	devs := append([]string{}, d.cfg.Devices...)
 	sort.Strings(devs)
 	logrus.Infof("monitoring devices: %v", devs)
+	for _, g := range d.groupList() {
+		logrus.Infof("group %s: %v", g.name, g.members)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -77,42 +330,420 @@ func (d *Daemon) Run() error {
 		cancel()
 	}()
 
+	// SIGUSR1 is sent by the systemd sleep hook after resume
+	resumeChan := make(chan os.Signal, 1)
+	signal.Notify(resumeChan, syscall.SIGUSR1)
+	defer signal.Stop(resumeChan)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resumeChan:
+				logrus.Infof("received resume notification")
+				d.requestReapply("")
+			}
+		}
+	}()
+
+	if control != nil {
+		go func() {
+			if err := d.serveControl(ctx, control); err != nil {
+				logrus.Warnf("control socket unavailable, no status and metrics: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		if err := watchUevents(ctx, d.requestReapply); err != nil {
+			logrus.Warnf("device uevents unavailable, resets are only detected on spin-up: %v", err)
+		}
+	}()
+
 	d.mainLoop(ctx, devs)
+	if d.tracer != nil {
+		if err := d.tracer.Close(); err != nil {
+			logrus.Warnf("failed to stop wake attribution: %v", err)
+		}
+	}
+	if d.safety != nil {
+		logrus.Infof("commands refused because they would have woken a disk: %v", d.safety.WouldWake())
+	}
+	if d.verifier != nil {
+		logrus.Infof("settings not applied by the drives: %v", d.verifier.Mismatches())
+	}
 	return nil
 }
 
-func (d *Daemon) mainLoop(ctx context.Context, devs []string) {
-	pollTicker := time.NewTicker(d.cfg.PollInterval)
-	defer pollTicker.Stop()
+// timers are the next due times of the periodic work of the main loop.
+type timers struct {
+	poll     time.Time
+	lastPoll time.Time
+	schedule time.Time
+	summary  time.Time
+	verify   time.Time
+	apm      time.Time
+	apmRule  *config.APMRule
+	wake     time.Time
+	wakeRule *config.WakeRule
+}
 
-	nextScheduledTime := d.cfg.Cron.Next(time.Now())
-	logrus.Infof("scheduler: next run at %s", nextScheduledTime.Format(time.RFC3339))
+func (d *Daemon) newTimers(now time.Time) *timers {
+	t := &timers{
+		poll:     now.Add(d.cfg.PollInterval),
+		lastPoll: now,
+		schedule: d.cfg.Cron.Next(now),
+		summary:  summaryAt.Next(now),
+		verify:   now.Add(d.cfg.Drift.Interval),
+	}
+	t.apm, t.apmRule = nextAPMRule(d.cfg.APM, now)
+	t.wake, t.wakeRule = nextWakeRule(d.cfg.Wake, now)
+	logrus.Infof("scheduler: next run at %s", t.schedule.Format(time.RFC3339))
+	return t
+}
 
+// next returns when the first timer of t or of the daemon state is due.
+func (d *Daemon) next(t *timers) time.Time {
+	due := t.poll
+	earliest := func(enabled bool, at time.Time) {
+		if enabled && at.Before(due) {
+			due = at
+		}
+	}
+	earliest(true, t.schedule)
+	earliest(true, t.summary)
+	earliest(d.cfg.Drift.Interval > 0, t.verify)
+	earliest(t.apmRule != nil, t.apm)
+	earliest(t.wakeRule != nil, t.wake)
+	releaseTime, releasing := d.nextRelease()
+	earliest(releasing, releaseTime)
+	retryTime, retrying := d.nextRetry()
+	earliest(retrying, retryTime)
+	batchTime, batching := d.nextBatch()
+	earliest(batching, batchTime)
+	return due
+}
+
+// fire runs the work of the timers of t due at now.
+func (d *Daemon) fire(t *timers, devs []string, now time.Time) {
+	if !t.poll.After(now) {
+		if slept := suspended(now.Round(0).Sub(t.lastPoll.Round(0)), now.Sub(t.lastPoll)); slept > 0 {
+			logrus.Infof("system resumed from a %s suspend", slept.Round(time.Second))
+			d.reapply(devs)
+		}
+		t.lastPoll = now
+		d.scan(devs)
+		d.checkInhibitors(devs)
+		// like a ticker, polls missed meanwhile are dropped
+		for !t.poll.After(now) {
+			t.poll = t.poll.Add(d.cfg.PollInterval)
+		}
+	}
+	if !t.schedule.After(now) {
+		d.applySchedule()
+		t.schedule = d.cfg.Cron.Next(now)
+		logrus.Infof("set standby timeout: next run at %s", t.schedule.Format(time.RFC3339))
+	}
+	if !t.summary.After(now) {
+		d.logEnergy()
+		t.summary = summaryAt.Next(now)
+	}
+	if t.wakeRule != nil && !t.wake.After(now) {
+		d.applyWakeRule(t.wakeRule, devs)
+		t.wake, t.wakeRule = nextWakeRule(d.cfg.Wake, now)
+		logrus.Infof("wake rule: next run at %s", t.wake.Format(time.RFC3339))
+	}
+	if releaseTime, ok := d.nextRelease(); ok && !releaseTime.After(now) {
+		d.releaseExpired(now)
+	}
+	if retryTime, ok := d.nextRetry(); ok && !retryTime.After(now) {
+		d.runRetries(now)
+	}
+	if batchTime, ok := d.nextBatch(); ok && !batchTime.After(now) {
+		d.startBatches(now)
+	}
+	if d.cfg.Drift.Interval > 0 && !t.verify.After(now) {
+		d.verifySettings(devs)
+		t.verify = now.Add(d.cfg.Drift.Interval)
+	}
+	if t.apmRule != nil && !t.apm.After(now) {
+		d.applyAPMRule(t.apmRule.Level)
+		t.apm, t.apmRule = nextAPMRule(d.cfg.APM, now)
+		logrus.Infof("set APM level: next run at %s", t.apm.Format(time.RFC3339))
+	}
+}
+
+// mainLoop serves the control socket and reapply requests, and runs the
+// timers on the daemon clock until ctx is done. Simulate runs it on a
+// virtual clock.
+func (d *Daemon) mainLoop(ctx context.Context, devs []string) {
+	t := d.newTimers(d.now())
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-pollTicker.C:
-			d.scan(devs)
-		case <-time.After(time.Until(nextScheduledTime)):
-			// should not wake up inactive devices by `SetStandbyTimeout`
-			actives := d.getActiveDevices()
-			logrus.Infof("set standby timeout: value=%d", d.cfg.StandbyValue)
-			for _, dev := range actives {
-				if err := d.controller.SetStandbyTimeout(dev, d.cfg.StandbyValue); err != nil {
-					logrus.Errorf("failed to set standby on %s: %v", dev, err)
-				}
+		case dev := <-d.reapplyCh:
+			if dev == "" {
+				d.reapply(devs)
+			} else if slices.Contains(devs, dev) {
+				d.reapply([]string{dev})
 			}
-			nextScheduledTime = d.cfg.Cron.Next(time.Now())
-			logrus.Infof("set standby timeout: next run at %s", nextScheduledTime.Format(time.RFC3339))
+		case reply := <-d.statusCh:
+			reply <- d.status()
+		case op := <-d.leaseCh:
+			lease, err := d.serveLease(op)
+			op.reply <- leaseReply{lease: lease, err: err}
+		case done := <-d.doneCh:
+			done()
+		case <-d.after(d.next(t)):
+			d.fire(t, devs, d.now())
+		}
+	}
+}
+
+// afterClock returns a channel firing at t on the system clock.
+func afterClock(t time.Time) <-chan time.Time {
+	return time.After(time.Until(t))
+}
+
+// applySchedule arms the standby timer of every active device.
+func (d *Daemon) applySchedule() {
+	// should not wake up inactive devices by `SetStandbyTimeout`
+	var actives []string
+	for _, dev := range d.getActiveDevices() {
+		if d.held(dev) {
+			logrus.Infof("device %s held, armed once released", dev)
+			d.rearm[dev] = true
+			continue
 		}
+		actives = append(actives, dev)
 	}
+	logrus.Infof("scheduled window: arming %v", actives)
+	d.armDevices(actives)
 }
 
+// armDevices arms the power policy on the spinning devices of devs.
+func (d *Daemon) armDevices(actives []string) {
+	sort.Strings(actives)
+
+	// the spinning members of a group are armed one after the other, so
+	// that their timers expire together
+	var order []string
+	groups := make(map[string]groupPolicy)
+	for _, dev := range actives {
+		g := d.groups[dev]
+		if g == nil {
+			order = append(order, dev)
+			continue
+		}
+		if _, ok := groups[dev]; ok {
+			continue
+		}
+		gp := d.groupPolicy(g)
+		for _, m := range g.members {
+			if slices.Contains(actives, m) {
+				groups[m] = gp
+				order = append(order, m)
+			}
+		}
+	}
+
+	// the policy of each device is chosen here, only the commands run in
+	// the batches
+	epc := d.epcPolicy()
+	plans := make(map[string]armPlan, len(order))
+	for _, dev := range order {
+		var p armPlan
+		if gp, grouped := groups[dev]; grouped {
+			p.standby, p.group = gp.standby, d.groups[dev].name
+			if gp.epc && d.epcCandidate(dev, epc) {
+				p.epc = epc
+			}
+		} else {
+			p.standby = d.standbyValue(dev)
+			if d.epcCandidate(dev, epc) {
+				p.epc = epc
+			}
+		}
+		plans[dev] = p
+	}
+	d.staggered("arm", order, func(dev string) func() {
+		p := plans[dev]
+		if p.epc.Enabled() {
+			err := d.controller.SetEPC(dev, p.epc)
+			if err == nil {
+				return func() { d.epcArmed(dev, p.epc, nil) }
+			}
+			standbyErr := d.controller.SetStandbyTimeout(dev, p.standby)
+			return func() {
+				d.epcArmed(dev, p.epc, err)
+				d.standbyArmed(dev, p, standbyErr)
+			}
+		}
+		err := d.controller.SetStandbyTimeout(dev, p.standby)
+		return func() { d.standbyArmed(dev, p, err) }
+	})
+}
+
+// armPlan is the policy armed on a device: the EPC timers when set, with the
+// standby timer as a fallback.
+type armPlan struct {
+	epc     hw.EPCTimers
+	standby int
+	// group of the device, if any
+	group string
+}
+
+// armStandby arms the standby timer of dev.
+func (d *Daemon) armStandby(dev string, value int) {
+	d.command(dev, retryPolicy, "set standby", d.setStandby(dev, value))
+}
+
+// setStandby returns the command arming the standby timer of dev.
+func (d *Daemon) setStandby(dev string, value int) func() error {
+	return func() error {
+		if err := d.controller.SetStandbyTimeout(dev, value); err != nil {
+			return err
+		}
+		d.applied[dev] = settings{standby: value}
+		return nil
+	}
+}
+
+// standbyArmed records the outcome err of arming the standby timer of p on
+// dev in a batch.
+func (d *Daemon) standbyArmed(dev string, p armPlan, err error) {
+	if p.group != "" {
+		logrus.Infof("set standby timeout on %s: value=%d (group %s)", dev, p.standby, p.group)
+	} else {
+		logrus.Infof("set standby timeout on %s: value=%d", dev, p.standby)
+	}
+	if err == nil {
+		d.applied[dev] = settings{standby: p.standby}
+	}
+	d.settle(retryKey{dev: dev, kind: retryPolicy}, "set standby", d.setStandby(dev, p.standby), err)
+}
+
+// epcCandidate tells whether timers are to be tried on dev: drives found
+// without EPC keep the standby timer.
+func (d *Daemon) epcCandidate(dev string, timers hw.EPCTimers) bool {
+	if !timers.Enabled() {
+		return false
+	}
+	supported, ok := d.epc[dev]
+	return !ok || supported
+}
+
+// armEPC arms the EPC timers on dev and tells whether it did. Drives found
+// without EPC are remembered, and like drives failing the command, or not
+// applying the timers, fall back to the standby timer: EPC is tried again at
+// the next window.
+func (d *Daemon) armEPC(dev string, timers hw.EPCTimers) bool {
+	if !d.epcCandidate(dev, timers) {
+		return false
+	}
+	return d.epcArmed(dev, timers, d.controller.SetEPC(dev, timers))
+}
+
+// epcArmed records the outcome err of setting timers on dev and tells
+// whether they are armed.
+func (d *Daemon) epcArmed(dev string, timers hw.EPCTimers, err error) bool {
+	switch {
+	case errors.Is(err, hw.ErrEPCUnsupported):
+		logrus.Infof("device %s does not support epc, using standby timer", dev)
+		d.epc[dev] = false
+		return false
+	case err != nil:
+		commandFailed("set epc", dev, err)
+		return false
+	}
+	logrus.Infof("set epc timers on %s: %s", dev, timers)
+	d.epc[dev] = true
+	d.applied[dev] = settings{epc: timers}
+	// supersedes a failed command of the previous policy
+	d.settle(retryKey{dev: dev, kind: retryPolicy}, "set epc", nil, nil)
+	return true
+}
+
+// commandFailed logs a failed command. A command refused because the device
+// spun down since it was last polled is expected and only logged at debug
+// level, the safety layer already reported it.
+func commandFailed(command, dev string, err error) {
+	if errors.Is(err, hw.ErrWouldWake) {
+		logrus.Debugf("skipped %s on %s: %v", command, dev, err)
+		return
+	}
+	logrus.Errorf("failed to %s on %s: %v", command, dev, err)
+}
+
+// disarm disables the power policy armed on dev by applySchedule.
+func (d *Daemon) disarm(dev string) {
+	prev := d.applied[dev]
+	d.applied[dev] = settings{}
+	// a group member supporting EPC may have been armed with the timer
+	if prev.epc.Enabled() || (prev.standby == 0 && d.epc[dev]) {
+		d.command(dev, retryPolicy, "disable epc", func() error {
+			return d.disarmed(dev, d.controller.SetEPC(dev, hw.EPCTimers{}))
+		})
+		return
+	}
+	d.command(dev, retryPolicy, "disable spindown", func() error {
+		return d.disarmed(dev, d.controller.SetStandbyTimeout(dev, 0))
+	})
+}
+
+// disarmed records the outcome err of disabling the timers of dev: until it
+// succeeds the drive keeps the timer the daemon armed, and spinning down is
+// not a drift.
+func (d *Daemon) disarmed(dev string, err error) error {
+	if err != nil {
+		d.stuckArmed[dev] = true
+	} else {
+		delete(d.stuckArmed, dev)
+	}
+	return err
+}
+
+// standbyValue returns the standby timeout to arm for dev: the adaptive
+// choice when enabled and trained, otherwise the configured value.
+func (d *Daemon) standbyValue(dev string) int {
+	if d.adaptive == nil {
+		return d.cfg.StandbyValue
+	}
+	value := d.adaptive.Timeout(dev, d.cfg.StandbyValue)
+	logrus.Infof("adaptive standby for %s: value=%d (samples=%d)", dev, value, len(d.adaptive.Gaps(dev)))
+	return value
+}
+
+// observeIO feeds the adaptive policy with an access whenever the I/O count
+// of dev moved since the previous poll. Consecutive polls with I/O are one
+// busy period: only the idle gaps between busy periods are learned.
+func (d *Daemon) observeIO(dev string, now time.Time) {
+	count, err := d.controller.IOCount(dev)
+	if err != nil {
+		logrus.Debugf("get device io count(%s) error: %v", dev, err)
+		return
+	}
+	prev, ok := d.ioCounts[dev]
+	d.ioCounts[dev] = count
+	if !ok {
+		return
+	}
+	busy := count != prev
+	switch {
+	case busy && d.ioBusy[dev]:
+		d.adaptive.Busy(dev, now)
+	case busy:
+		d.adaptive.Observe(dev, now)
+	}
+	d.ioBusy[dev] = busy
+}
+
+// getActiveDevices returns the devices last seen spinning.
 func (d *Daemon) getActiveDevices() []string {
 	var active []string
 	for dev, state := range d.last {
-		if state == hw.DriveStateActive {
+		if !hw.IsSpunDown(state) {
 			active = append(active, dev)
 		}
 	}
@@ -120,34 +751,57 @@ func (d *Daemon) getActiveDevices() []string {
 }
 
 // scan checks the state of all devices
-// if state changed from standby to active, disable spindown timer
+// if state changed from spun down to spinning, disable spindown timer
 func (d *Daemon) scan(devs []string) {
 	logrus.Debugf("scanning devices: %v", devs)
+	now := d.now()
 	for _, dev := range devs {
+		if d.adaptive != nil {
+			d.observeIO(dev, now)
+		}
+
+		// the SafeHDDControl reports a sleeping drive without querying it
+		// until it has served I/O
+		last, ok := d.last[dev]
 		state, err := d.controller.GetState(dev)
+		if errors.Is(err, hw.ErrStateUnavailable) {
+			logrus.Debugf("skip device %s: %v", dev, err)
+			continue
+		}
 		if err != nil {
+			// keep the last known state, an unknown one must not be mistaken for a transition
 			logrus.Errorf("get device state(%s) error: %v", dev, err)
+			continue
 		}
 
-		last, ok := d.last[dev]
 		if ok {
-			switch last {
-			case state:
+			switch {
+			case last == state:
 				logrus.Debugf("device %s state unchanged (state=%s)", dev, state)
-			case hw.DriveStateStandby:
-				logrus.Infof("device %s left standby (state=%s) — disabling spindown timer", dev, state)
-				if err := d.controller.SetStandbyTimeout(dev, 0); err != nil {
-					logrus.Errorf("failed to disable spindown on %s: %v", dev, err)
-				}
-			case hw.DriveStateActive:
-				logrus.Infof("device %s became standby (state=%s)", dev, state)
+			case hw.IsSpunDown(last) && !hw.IsSpunDown(state):
+				logrus.Infof("device %s left %s (state=%s) — disabling spindown timer", dev, last, state)
+				d.recordWake(dev, last, state, now)
+				d.disarm(dev)
+				d.wakeGroup(dev)
+				d.reapplyAPM(dev)
+			case !hw.IsSpunDown(last) && hw.IsSpunDown(state):
+				logrus.Infof("device %s became %s (last=%s)", dev, state, last)
+				d.spunDownDisarmed(dev, state)
 			default:
-				panic(fmt.Sprintf("invalid last state(%s)! current state(%s)", last, state))
+				logrus.Infof("device %s changed power mode %s -> %s", dev, last, state)
 			}
 		} else {
 			logrus.Infof("first set device %s state=%s", dev, state)
+			if !hw.IsSpunDown(state) {
+				d.reapplyAPM(dev)
+			}
+		}
+		d.meter.Observe(dev, state, now)
+		if hw.IsEPCState(state) && !hw.IsEPCState(last) {
+			logrus.Infof("device %s reports extended power conditions (state=%s)", dev, state)
 		}
-
 		d.last[dev] = state
 	}
+	d.sampleIO(devs)
+	d.lastScan = now
 }
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"maps"
 	"testing"
+	"testing/fstest"
 	"testing/synctest"
 	"time"
 
+	"github.com/chain710/hd-smart-idle/internal/config"
 	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/policy"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
@@ -24,7 +28,7 @@ func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
 			devs: []string{"/dev/sda", "/dev/sdb"},
 			cfg: Config{
 				PollInterval: 10 * time.Second,
-				Cron:         &CronExpr{Hour: 22, Min: 0},
+				Cron:         &config.CronExpr{Hour: 22, Min: 0},
 				StandbyValue: 120,
 			},
 			steps: []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second},
@@ -38,7 +42,7 @@ func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
 			devs: []string{"/dev/sda"},
 			cfg: Config{
 				PollInterval: 5 * time.Second,
-				Cron:         &CronExpr{Hour: 22, Min: 0},
+				Cron:         &config.CronExpr{Hour: 22, Min: 0},
 				StandbyValue: 120,
 			},
 			steps: []time.Duration{5 * time.Second, 5 * time.Second},
@@ -53,7 +57,7 @@ func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
 			devs: []string{"/dev/sda"},
 			cfg: Config{
 				PollInterval: 3 * time.Second,
-				Cron:         &CronExpr{Hour: 22, Min: 0},
+				Cron:         &config.CronExpr{Hour: 22, Min: 0},
 				StandbyValue: 120,
 			},
 			steps: []time.Duration{3 * time.Second, 3 * time.Second},
@@ -68,7 +72,7 @@ func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
 			devs: []string{"/dev/sda"},
 			cfg: Config{
 				PollInterval: 5 * time.Second,
-				Cron:         &CronExpr{Hour: 22, Min: 0},
+				Cron:         &config.CronExpr{Hour: 22, Min: 0},
 				StandbyValue: 120,
 			},
 			steps: []time.Duration{5 * time.Second},
@@ -76,12 +80,25 @@ func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
 				m.EXPECT().GetState("/dev/sda").Return("", fmt.Errorf("device error")).Once()
 			},
 		},
+		{
+			name: "state_unavailable_is_skipped",
+			devs: []string{"/dev/sda"},
+			cfg: Config{
+				PollInterval: 5 * time.Second,
+				Cron:         &config.CronExpr{Hour: 22, Min: 0},
+				StandbyValue: 120,
+			},
+			steps: []time.Duration{5 * time.Second, 5 * time.Second},
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return("", fmt.Errorf("usb bridge: %w", hw.ErrStateUnavailable)).Twice()
+			},
+		},
 		{
 			name: "multiple_devices_transitions",
 			devs: []string{"/dev/sda", "/dev/sdb", "/dev/sdc"},
 			cfg: Config{
 				PollInterval: 7 * time.Second,
-				Cron:         &CronExpr{Hour: 2, Min: 30},
+				Cron:         &config.CronExpr{Hour: 2, Min: 30},
 				StandbyValue: 240,
 			},
 			steps: []time.Duration{7 * time.Second, 7 * time.Second},
@@ -105,11 +122,7 @@ func TestDaemon_mainLoop_PollDrivenScenarios(t *testing.T) {
 				mockCtrl := hw.NewMockHDDControl(t)
 				tc.setup(mockCtrl)
 
-				d := &Daemon{
-					cfg:        tc.cfg,
-					controller: mockCtrl,
-					last:       make(map[string]string),
-				}
+				d := newDaemon(tc.cfg, mockCtrl)
 
 				ctx, cancel := context.WithCancel(context.Background())
 				defer cancel()
@@ -185,17 +198,14 @@ func TestDaemon_mainLoop_ScheduledStandbySet(t *testing.T) {
 				tc.setupMock(mockCtrl)
 
 				now := time.Now()
-				cron := &CronExpr{Hour: now.Hour(), Min: now.Minute()}
-
-				d := &Daemon{
-					cfg: Config{
-						PollInterval: tc.poll,
-						Cron:         cron,
-						StandbyValue: tc.standby,
-					},
-					controller: mockCtrl,
-					last:       maps.Clone(tc.lastState),
-				}
+				cron := &config.CronExpr{Hour: now.Hour(), Min: now.Minute()}
+
+				d := newDaemon(Config{
+					PollInterval: tc.poll,
+					Cron:         cron,
+					StandbyValue: tc.standby,
+				}, mockCtrl)
+				d.last = maps.Clone(tc.lastState)
 
 				ctx, cancel := context.WithCancel(context.Background())
 				defer cancel()
@@ -232,16 +242,12 @@ func TestDaemon_mainLoop_ContextCancellation(t *testing.T) {
 		// May or may not be called depending on timing
 		mockCtrl.On("GetState", "/dev/sda").Return(hw.DriveStateActive, nil).Maybe()
 
-		cron := &CronExpr{Hour: 1, Min: 0}
-		d := &Daemon{
-			cfg: Config{
-				PollInterval: 5 * time.Second,
-				Cron:         cron,
-				StandbyValue: 120,
-			},
-			controller: mockCtrl,
-			last:       make(map[string]string),
-		}
+		cron := &config.CronExpr{Hour: 1, Min: 0}
+		d := newDaemon(Config{
+			PollInterval: 5 * time.Second,
+			Cron:         cron,
+			StandbyValue: 120,
+		}, mockCtrl)
 
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
@@ -261,3 +267,214 @@ func TestDaemon_mainLoop_ContextCancellation(t *testing.T) {
 		<-done
 	})
 }
+
+func TestDaemon_AdaptiveStandbyValue(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		mockCtrl := hw.NewMockHDDControl(t)
+		counts := []uint64{10, 11, 11, 12, 12, 13}
+		for _, c := range counts {
+			mockCtrl.EXPECT().IOCount("/dev/sda").Return(c, nil).Once()
+		}
+		mockCtrl.EXPECT().IOCount("/dev/sdb").Return(0, fmt.Errorf("no stat")).Times(len(counts))
+		mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Times(len(counts))
+		mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Times(len(counts))
+
+		d := newDaemon(Config{
+			StandbyValue: 120,
+			Adaptive:     &policy.AdaptiveConfig{PowerWeight: 0, MinSamples: 2},
+		}, mockCtrl)
+
+		for range counts {
+			d.scan([]string{"/dev/sda", "/dev/sdb"})
+			time.Sleep(time.Minute)
+		}
+
+		// learned 2 minute gaps on sda, sdb falls back to the configured value
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 24).Return(nil).Once()
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Once()
+		d.applySchedule()
+	})
+}
+
+func TestDaemon_AdaptiveStandbyValue_BusyPeriods(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	// three 10 minute busy periods 30 minutes apart, polled every minute
+	var count uint64
+	for period := range 3 {
+		for range 10 {
+			count++
+			mockCtrl.EXPECT().IOCount("/dev/sda").Return(count, nil).Once()
+		}
+		if period < 2 {
+			mockCtrl.EXPECT().IOCount("/dev/sda").Return(count, nil).Times(30)
+		}
+	}
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Times(90)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{
+		StandbyValue: 120,
+		Adaptive:     &policy.AdaptiveConfig{PowerWeight: 0.5, MinSamples: 2},
+	}, mockCtrl)
+	d.now = func() time.Time { return now }
+	for range 90 {
+		d.scan([]string{"/dev/sda"})
+		now = now.Add(time.Minute)
+	}
+
+	// only the two idle gaps are learned, the busy polls do not pull the
+	// timeout down to a minute
+	require.Equal(t, []time.Duration{31 * time.Minute, 31 * time.Minute}, d.adaptive.Gaps("/dev/sda"))
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 242).Return(nil).Once()
+	d.applySchedule()
+}
+
+func TestDaemon_mainLoop_SimBackend(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		sim := hw.NewSimHDDControl(time.Now, "/dev/sda", "/dev/sdb")
+		window := time.Now().Add(2 * time.Minute)
+		d := newDaemon(Config{
+			PollInterval: time.Minute,
+			// first window after the first poll
+			Cron:         &config.CronExpr{Hour: window.Hour(), Min: window.Minute()},
+			StandbyValue: 60,
+		}, sim)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			d.mainLoop(ctx, []string{"/dev/sda", "/dev/sdb"})
+			close(done)
+		}()
+
+		// window arms both timers, drives spin down 5 minutes later
+		time.Sleep(10 * time.Minute)
+		synctest.Wait()
+		require.Equal(t, map[string]string{"/dev/sda": hw.DriveStateStandby, "/dev/sdb": hw.DriveStateStandby}, d.last)
+
+		// woken drive gets its timer disabled and stays up
+		require.NoError(t, sim.Access("/dev/sda"))
+		// unplugged drive keeps its last known state
+		sim.Remove("/dev/sdb")
+		time.Sleep(time.Hour)
+		synctest.Wait()
+		require.Equal(t, hw.DriveStateActive, d.last["/dev/sda"])
+
+		cancel()
+		<-done
+
+		stats, err := sim.Stats("/dev/sda")
+		require.NoError(t, err)
+		require.Equal(t, 1, stats.SpinUps)
+	})
+}
+
+func TestDaemon_scan_ErrorKeepsLastState(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandby, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sda").Return("", fmt.Errorf("malformed hdparm output")).Once()
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+
+	d := newDaemon(Config{StandbyValue: 120}, mockCtrl)
+	for range 3 {
+		d.scan([]string{"/dev/sda"})
+	}
+	require.Equal(t, hw.DriveStateActive, d.last["/dev/sda"])
+}
+
+func TestDaemon_scan_PowerModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  []string
+		disable bool
+	}{
+		{name: "idle modes are not a wake", states: []string{hw.DriveStateActive, hw.DriveStateIdleA, hw.DriveStateIdleC, hw.DriveStateActive}},
+		{name: "standby_y to idle_b wakes", states: []string{hw.DriveStateStandbyY, hw.DriveStateIdleB}, disable: true},
+		{name: "standby_z to active wakes", states: []string{hw.DriveStateIdleB, hw.DriveStateStandbyZ, hw.DriveStateActive}, disable: true},
+		{name: "unknown is spinning", states: []string{hw.DriveStateUnknown, hw.DriveStateStandby}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := hw.NewMockHDDControl(t)
+			for _, state := range tt.states {
+				mockCtrl.EXPECT().GetState("/dev/sda").Return(state, nil).Once()
+			}
+			if tt.disable {
+				mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+			}
+
+			d := newDaemon(Config{StandbyValue: 120}, mockCtrl)
+			for range tt.states {
+				d.scan([]string{"/dev/sda"})
+			}
+			require.Equal(t, tt.states[len(tt.states)-1], d.last["/dev/sda"])
+		})
+	}
+}
+
+func TestDaemon_scan_SleepingNotPolled(t *testing.T) {
+	devs := []string{"/dev/sda", "/dev/sdb"}
+	mockCtrl := hw.NewMockHDDControl(t)
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateSleeping, nil).Once()
+	mockCtrl.EXPECT().IOCount("/dev/sda").Return(100, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Times(4)
+
+	// the safety layer keeps track of the sleeping drives
+	d := newDaemon(Config{StandbyValue: 120}, hw.NewSafeHDDControlFS(mockCtrl, fstest.MapFS{}, false))
+	d.scan(devs)
+	require.Equal(t, []string{"/dev/sdb"}, d.getActiveDevices())
+
+	// no I/O while asleep: never queried
+	mockCtrl.EXPECT().IOCount("/dev/sda").Return(100, nil).Twice()
+	d.scan(devs)
+	d.scan(devs)
+	mockCtrl.AssertNumberOfCalls(t, "GetState", 4)
+	require.Equal(t, hw.DriveStateSleeping, d.last["/dev/sda"])
+	require.Equal(t, []string{"/dev/sdb"}, d.getActiveDevices())
+
+	// kernel reset the drive to serve I/O, its state is verified again
+	// before its timer is disabled
+	mockCtrl.EXPECT().IOCount("/dev/sda").Return(104, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Twice()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+	d.scan(devs)
+	require.Equal(t, hw.DriveStateActive, d.last["/dev/sda"])
+	require.ElementsMatch(t, devs, d.getActiveDevices())
+}
+
+func TestDaemon_EPCPolicy(t *testing.T) {
+	epc := hw.EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}
+	mockCtrl := hw.NewMockHDDControl(t)
+	mockCtrl.EXPECT().SetEPC("/dev/sda", epc).Return(nil).Twice()
+	mockCtrl.EXPECT().SetEPC("/dev/sdb", epc).Return(fmt.Errorf("/dev/sdb: %w", hw.ErrEPCUnsupported)).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Twice()
+	// timers not applied: the standby timer is armed, EPC tried again at the
+	// next window
+	mockCtrl.EXPECT().SetEPC("/dev/sdc", epc).Return(hw.ErrNotApplied).Once()
+	mockCtrl.EXPECT().SetEPC("/dev/sdc", epc).Return(nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdc", 120).Return(nil).Once()
+
+	d := newDaemon(Config{StandbyValue: 120, EPC: []config.EPCRule{{Timers: epc}}}, mockCtrl)
+	d.last["/dev/sda"] = hw.DriveStateActive
+	d.last["/dev/sdb"] = hw.DriveStateActive
+	d.last["/dev/sdc"] = hw.DriveStateActive
+	d.applySchedule()
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sdc"])
+	// the second window does not retry EPC on /dev/sdb
+	d.applySchedule()
+	require.Equal(t, settings{epc: epc}, d.applied["/dev/sdc"])
+	delete(d.last, "/dev/sdc")
+
+	// waking up disables EPC on drives it was armed on, the timer on others
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandbyZ, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateIdleB, nil).Once()
+	mockCtrl.EXPECT().SetEPC("/dev/sda", hw.EPCTimers{}).Return(nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateStandby, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(nil).Once()
+	d.scan([]string{"/dev/sda", "/dev/sdb"})
+	d.scan([]string{"/dev/sda", "/dev/sdb"})
+}
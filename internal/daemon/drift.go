@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDrifts is the number of recent drifts kept for the status.
+const maxDrifts = 32
+
+// defaultReassertEvery is the minimum time between two re-assertions of a
+// setting on a device when Drift.ReassertEvery is not set.
+const defaultReassertEvery = time.Hour
+
+// DriftEvent is a setting found different from the one the daemon applied.
+type DriftEvent struct {
+	Device string    `json:"device"`
+	Time   time.Time `json:"time"`
+	// Setting is apm, epc or standby
+	Setting string `json:"setting"`
+	Found   string `json:"found"`
+	Want    string `json:"want"`
+	// Managers are the other power managers found on the host, the likely
+	// culprits
+	Managers []string `json:"managers,omitempty"`
+	// Reasserted tells the wanted setting was applied again
+	Reasserted bool `json:"reasserted"`
+}
+
+// verifySettings reads back the APM level and EPC timers of the spinning
+// devices of devs and reports those differing from the applied ones. The
+// standby timer of ATA drives cannot be read back, spunDownDisarmed catches
+// it instead.
+func (d *Daemon) verifySettings(devs []string) {
+	devs = append([]string{}, devs...)
+	sort.Strings(devs)
+	for _, dev := range devs {
+		if state, ok := d.last[dev]; !ok || hw.IsSpunDown(state) {
+			// the queries could wake it up
+			continue
+		}
+		if d.apmLevel != 0 && !d.noAPM[dev] {
+			want := d.apmLevel
+			level, err := d.controller.GetAPM(dev)
+			switch {
+			case err != nil:
+				d.apmFailed(dev, err)
+			case !d.apmApplied(dev, level):
+				d.drifted(dev, "apm", strconv.Itoa(level), strconv.Itoa(want), func() error {
+					return d.controller.SetAPM(dev, want)
+				})
+			}
+		}
+		// a drive supporting EPC may be armed with the standby timer, its
+		// own EPC timers are then not the daemon's
+		s, ok := d.applied[dev]
+		if !ok || !d.epc[dev] || !s.epc.Enabled() {
+			continue
+		}
+		want := s.epc
+		timers, err := d.controller.GetEPC(dev)
+		switch {
+		case err != nil:
+			commandFailed("read epc", dev, err)
+		case timers != want:
+			d.drifted(dev, "epc", timers.String(), want.String(), func() error {
+				return d.controller.SetEPC(dev, want)
+			})
+		}
+	}
+}
+
+// spunDownDisarmed reports dev spun down on its own although the daemon
+// disabled its timer, unless its APM level permits spin-downs or disabling
+// the timer failed.
+func (d *Daemon) spunDownDisarmed(dev, state string) {
+	if s, ok := d.applied[dev]; !ok || s != (settings{}) || d.stuckArmed[dev] {
+		return
+	}
+	if d.apmLevel > 0 && d.apmLevel < 128 {
+		return
+	}
+	// nothing to reassert: the timer is disabled again when it wakes up
+	d.drifted(dev, "standby", "spun down to "+state, "timer disabled", nil)
+}
+
+// driftKey is a setting of a device.
+type driftKey struct {
+	dev, setting string
+}
+
+// drifted records a drift of setting on dev and applies the wanted setting
+// again with reassert when enabled and not done recently.
+func (d *Daemon) drifted(dev, setting, found, want string, reassert func() error) {
+	now := d.now()
+	ev := DriftEvent{Device: dev, Time: now, Setting: setting, Found: found, Want: want}
+	for _, m := range d.managers() {
+		ev.Managers = append(ev.Managers, m.Name+": "+m.Evidence)
+	}
+	culprits := "an unknown manager or the drive firmware"
+	if len(ev.Managers) > 0 {
+		culprits = fmt.Sprintf("%v", ev.Managers)
+	}
+	logrus.Warnf("device %s %s drifted to %s, want %s; likely changed by %s", dev, setting, found, want, culprits)
+
+	if reassert != nil && d.cfg.Drift.Reassert {
+		every := d.cfg.Drift.ReassertEvery
+		if every == 0 {
+			every = defaultReassertEvery
+		}
+		key := driftKey{dev: dev, setting: setting}
+		if last, ok := d.reasserted[key]; ok && now.Sub(last) < every {
+			logrus.Warnf("device %s %s not reasserted, last done at %s", dev, setting, last.Format(time.RFC3339))
+		} else if err := reassert(); err != nil {
+			commandFailed("reassert "+setting, dev, err)
+		} else {
+			logrus.Infof("device %s %s reasserted to %s", dev, setting, want)
+			d.reasserted[key] = now
+			ev.Reasserted = true
+		}
+	}
+
+	d.driftCount[dev]++
+	d.drifts = append(d.drifts, ev)
+	if len(d.drifts) > maxDrifts {
+		d.drifts = d.drifts[len(d.drifts)-maxDrifts:]
+	}
+}
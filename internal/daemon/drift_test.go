@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/managers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_verifySettings(t *testing.T) {
+	epc := hw.EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}
+	mockCtrl := hw.NewMockHDDControl(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{
+		Devices:      []string{"/dev/sda", "/dev/sdb", "/dev/sdc"},
+		StandbyValue: 120,
+		EPC:          []config.EPCRule{{Timers: epc}},
+		Drift:        config.Drift{Interval: 10 * time.Minute, Reassert: true},
+	}, mockCtrl)
+	d.now = func() time.Time { return now }
+	d.managers = func() []managers.Manager {
+		return []managers.Manager{{Name: "tlp", Evidence: "DISK_APM_LEVEL_ON_AC in /etc/tlp.conf"}}
+	}
+	d.apmLevel = 254
+	d.last = map[string]string{"/dev/sda": hw.DriveStateActive, "/dev/sdb": hw.DriveStateActive, "/dev/sdc": hw.DriveStateStandby}
+	d.epc = map[string]bool{"/dev/sda": true, "/dev/sdb": false}
+	d.applied = map[string]settings{"/dev/sda": {epc: epc}, "/dev/sdb": {standby: 120}}
+	devs := []string{"/dev/sdc", "/dev/sdb", "/dev/sda"}
+
+	// the APM level and EPC timers of sda were reset, both reasserted;
+	// spun down sdc is not queried
+	mockCtrl.EXPECT().GetAPM("/dev/sda").Return(128, nil).Once()
+	mockCtrl.EXPECT().SetAPM("/dev/sda", 254).Return(nil).Once()
+	mockCtrl.EXPECT().GetEPC("/dev/sda").Return(hw.EPCTimers{}, nil).Once()
+	mockCtrl.EXPECT().SetEPC("/dev/sda", epc).Return(nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdb").Return(254, nil).Once()
+	d.verifySettings(devs)
+
+	// rate limited: sda drifted again too soon
+	now = now.Add(10 * time.Minute)
+	mockCtrl.EXPECT().GetAPM("/dev/sda").Return(128, nil).Once()
+	mockCtrl.EXPECT().GetEPC("/dev/sda").Return(epc, nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdb").Return(254, nil).Once()
+	d.verifySettings(devs)
+
+	tlp := []string{"tlp: DISK_APM_LEVEL_ON_AC in /etc/tlp.conf"}
+	st := d.status()
+	require.Equal(t, []DriftEvent{
+		{Device: "/dev/sda", Time: now.Add(-10 * time.Minute), Setting: "apm", Found: "128", Want: "254", Managers: tlp, Reasserted: true},
+		{Device: "/dev/sda", Time: now.Add(-10 * time.Minute), Setting: "epc", Found: "disabled", Want: epc.String(), Managers: tlp, Reasserted: true},
+		{Device: "/dev/sda", Time: now, Setting: "apm", Found: "128", Want: "254", Managers: tlp},
+	}, st.Drifts)
+	require.Equal(t, 3, st.Devices[0].Drifts)
+	metrics := new(strings.Builder)
+	writeMetrics(metrics, st)
+	require.Contains(t, metrics.String(), `hd_smart_idle_drifts_total{device="/dev/sda"} 3`)
+	require.Equal(t, 0, st.Devices[1].Drifts)
+}
+
+func TestDaemon_verifySettings_StandbyFallback(t *testing.T) {
+	epc := hw.EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{
+		StandbyValue: 120,
+		EPC:          []config.EPCRule{{Timers: epc}},
+		Drift:        config.Drift{Interval: 10 * time.Minute, Reassert: true},
+	}, mockCtrl)
+	d.managers = func() []managers.Manager { return nil }
+	d.last["/dev/sda"] = hw.DriveStateActive
+
+	// EPC armed at the first window, the standby timer at the second one
+	// after the EPC command failed
+	mockCtrl.EXPECT().SetEPC("/dev/sda", epc).Return(nil).Once()
+	d.applySchedule()
+	mockCtrl.EXPECT().SetEPC("/dev/sda", epc).Return(errors.New("SG_IO: bad/missing sense data")).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	d.applySchedule()
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sda"])
+
+	// the timers the drive reports are not compared, nor disabled
+	d.verifySettings([]string{"/dev/sda"})
+	require.Empty(t, d.drifts)
+}
+
+func TestDaemon_spunDownDisarmed(t *testing.T) {
+	tests := []struct {
+		name     string
+		applied  map[string]settings
+		apmLevel int
+		want     int
+	}{
+		{name: "never armed", applied: map[string]settings{}},
+		{name: "timer armed", applied: map[string]settings{"/dev/sda": {standby: 120}}},
+		{name: "timer disabled", applied: map[string]settings{"/dev/sda": {}}, want: 1},
+		{name: "APM permits spin-down", applied: map[string]settings{"/dev/sda": {}}, apmLevel: 127},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := hw.NewMockHDDControl(t)
+			mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+			mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandby, nil).Once()
+			if tt.apmLevel != 0 {
+				mockCtrl.EXPECT().GetAPM("/dev/sda").Return(tt.apmLevel, nil).Once()
+			}
+			d := newDaemon(Config{StandbyValue: 120, Drift: config.Drift{Reassert: true}}, mockCtrl)
+			d.managers = func() []managers.Manager { return nil }
+			d.apmLevel = tt.apmLevel
+			d.scan([]string{"/dev/sda"})
+			d.applied = tt.applied
+			d.scan([]string{"/dev/sda"})
+
+			require.Len(t, d.drifts, tt.want)
+			if tt.want > 0 {
+				require.Equal(t, DriftEvent{Device: "/dev/sda", Time: d.drifts[0].Time, Setting: "standby", Found: "spun down to standby", Want: "timer disabled"}, d.drifts[0])
+			}
+		})
+	}
+}
+
+func TestDaemon_spunDownDisarmed_DisarmFailed(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{StandbyValue: 120, Retry: config.Retry{Attempts: 1}}, mockCtrl)
+	d.managers = func() []managers.Manager { return nil }
+	d.last["/dev/sda"] = hw.DriveStateStandby
+	d.applied["/dev/sda"] = settings{standby: 120}
+
+	// woken up, the timer it was armed with is not disabled
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(errors.New("Input/output error")).Once()
+	d.scan([]string{"/dev/sda"})
+
+	// spinning down on that timer is not a drift
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandby, nil).Once()
+	d.scan([]string{"/dev/sda"})
+	require.Empty(t, d.drifts)
+
+	// once disabled, it is
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+	d.scan([]string{"/dev/sda"})
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandby, nil).Once()
+	d.scan([]string{"/dev/sda"})
+	require.Len(t, d.drifts, 1)
+}
@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/sirupsen/logrus"
+)
+
+// summaryAt is when the daily energy summary is logged.
+var summaryAt = &config.CronExpr{Hour: 0, Min: 0}
+
+// Status is a snapshot of the daemon served on the control socket.
+type Status struct {
+	Time    time.Time      `json:"time"`
+	Devices []DeviceStatus `json:"devices"`
+	Groups  []GroupStatus  `json:"groups,omitempty"`
+	// Wakes are the recent wake-ups, oldest first
+	Wakes []WakeEvent `json:"wakes,omitempty"`
+	// Drifts are the recent drifts of the applied settings, oldest first
+	Drifts []DriftEvent `json:"drifts,omitempty"`
+	// Retries are the failed commands waiting for their retry
+	Retries []RetryStatus `json:"retries,omitempty"`
+	// Total is the usage of all devices since the daemon started
+	Total power.Usage `json:"total"`
+}
+
+// GroupStatus is the state of a group of devices managed together.
+type GroupStatus struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	// Spinning is the number of members last seen spinning
+	Spinning int `json:"spinning"`
+	// Usage is the sum of the usages of the members
+	Usage power.Usage `json:"usage"`
+}
+
+// DeviceStatus is the state of a single monitored device.
+type DeviceStatus struct {
+	Device string `json:"device"`
+	// State is the last polled power mode, empty if never polled
+	State string `json:"state"`
+	// Armed tells the standby policy of the last window is armed
+	Armed bool `json:"armed"`
+	// WouldWake counts the commands refused because they would have woken it
+	WouldWake int `json:"would_wake"`
+	// Drifts counts the applied settings found changed by another manager
+	Drifts int `json:"drifts"`
+	// Mismatches counts the settings the drive accepted but did not apply;
+	// the standby timer value is never read back, only the support of it
+	Mismatches int `json:"mismatches"`
+	// Degraded is the last failure of a device whose commands failed too
+	// many times to be retried, empty if it is healthy
+	Degraded string `json:"degraded,omitempty"`
+	// Holds keep the device spinning
+	Holds []Hold `json:"holds,omitempty"`
+	// Mounts are the mount points of the filesystems stored on the device
+	Mounts []string `json:"mounts,omitempty"`
+	// Usage is the time in each power mode and energy since the daemon started
+	Usage power.Usage `json:"usage"`
+}
+
+// status takes a snapshot of the daemon. Only the main loop may call it.
+func (d *Daemon) status() Status {
+	now := d.now()
+	usage := d.meter.Usage(now)
+	var wouldWake map[string]int
+	if d.safety != nil {
+		wouldWake = d.safety.WouldWake()
+	}
+	var mismatches map[string]int
+	if d.verifier != nil {
+		mismatches = d.verifier.Mismatches()
+	}
+
+	devs := append([]string{}, d.cfg.Devices...)
+	sort.Strings(devs)
+	st := Status{Time: now, Wakes: slices.Clone(d.wakes), Drifts: slices.Clone(d.drifts), Retries: d.retryStatus()}
+	for _, dev := range devs {
+		applied := d.applied[dev]
+		var mounts []string
+		if d.mountsOf != nil {
+			mounts = d.mountsOf(dev)
+		}
+		st.Devices = append(st.Devices, DeviceStatus{
+			Device:     dev,
+			State:      d.last[dev],
+			Armed:      applied.epc.Enabled() || applied.standby > 0,
+			WouldWake:  wouldWake[dev],
+			Drifts:     d.driftCount[dev],
+			Mismatches: mismatches[dev],
+			Degraded:   d.degraded[dev],
+			Holds:      d.holdsOf(dev),
+			Mounts:     mounts,
+			Usage:      usage[dev],
+		})
+		st.Total = st.Total.Add(usage[dev])
+	}
+	for _, g := range d.groupList() {
+		gs := GroupStatus{Name: g.name, Members: g.members}
+		for _, m := range g.members {
+			if state, ok := d.last[m]; ok && !hw.IsSpunDown(state) {
+				gs.Spinning++
+			}
+			gs.Usage = gs.Usage.Add(usage[m])
+		}
+		st.Groups = append(st.Groups, gs)
+	}
+	return st
+}
+
+// logEnergy logs the energy consumed and saved since the previous summary.
+func (d *Daemon) logEnergy() {
+	usage := d.meter.Usage(d.now())
+	var day, total power.Usage
+	var devices []string
+	for _, dev := range d.meter.Devices() {
+		delta := usage[dev].Sub(d.summarized[dev])
+		day = day.Add(delta)
+		total = total.Add(usage[dev])
+		devices = append(devices, fmt.Sprintf("%s: %.3f/%.3f kWh, %d spin-ups", dev, delta.KWh(), delta.SavedKWh(), delta.SpinUps))
+	}
+	d.summarized = usage
+	logrus.Infof("daily energy: consumed %.3f kWh, saved %.3f kWh vs always-on, %d spin-ups (%s); since start: consumed %.3f kWh, saved %.3f kWh",
+		day.KWh(), day.SavedKWh(), day.SpinUps, strings.Join(devices, "; "), total.KWh(), total.SavedKWh())
+}
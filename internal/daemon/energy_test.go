@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_Energy(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateStandby, nil).Once()
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{
+		Devices: []string{"/dev/sda", "/dev/sdb"},
+		Power:   power.Config{Spec: power.Spec{Class: power.ClassNAS}},
+	}, mockCtrl)
+	d.now = func() time.Time { return now }
+
+	d.scan([]string{"/dev/sda"})
+	now = now.Add(time.Hour)
+	d.scan([]string{"/dev/sda"})
+	now = now.Add(time.Hour)
+
+	nas := power.Classes[power.ClassNAS]
+	want := power.Usage{
+		Active:         time.Hour,
+		Standby:        time.Hour,
+		SpinUps:        1,
+		Joules:         (nas.ActiveWatts+nas.StandbyWatts)*3600 + nas.SpinUpJoules,
+		BaselineJoules: nas.ActiveWatts * 2 * 3600,
+	}
+	st := d.status()
+	require.Equal(t, Status{
+		Time: now,
+		Devices: []DeviceStatus{
+			{Device: "/dev/sda", State: hw.DriveStateActive, Usage: want},
+			{Device: "/dev/sdb"},
+		},
+		Wakes: []WakeEvent{
+			{Device: "/dev/sda", Time: now.Add(-time.Hour), From: hw.DriveStateStandby, To: hw.DriveStateActive},
+		},
+		Total: want,
+	}, st)
+
+	// the summary starts a new day
+	d.logEnergy()
+	require.Equal(t, want, d.summarized["/dev/sda"])
+}
+
+func TestDaemon_serveControl(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateIdleB, nil).Once()
+	d := newDaemon(Config{
+		Devices:      []string{"/dev/sda"},
+		PollInterval: time.Hour,
+		Cron:         &config.CronExpr{Hour: 22, Min: 0},
+	}, mockCtrl)
+	d.scan([]string{"/dev/sda"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.mainLoop(ctx, nil)
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	served := make(chan error, 1)
+	ln, err := listenControl(socket)
+	require.NoError(t, err)
+	go func() { served <- d.serveControl(ctx, ln) }()
+
+	client := NewClient(socket)
+	require.Eventually(t, func() bool {
+		_, err := client.Status()
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	st, err := client.Status()
+	require.NoError(t, err)
+	require.Len(t, st.Devices, 1)
+	require.Equal(t, hw.DriveStateIdleB, st.Devices[0].State)
+
+	metrics, err := client.Metrics()
+	require.NoError(t, err)
+	require.Contains(t, metrics, `hd_smart_idle_device_state{device="/dev/sda",state="idle_b"} 1`)
+	require.Contains(t, metrics, `hd_smart_idle_spin_ups_total{device="/dev/sda"} 0`)
+	require.Contains(t, metrics, "# TYPE hd_smart_idle_energy_joules_total counter")
+
+	cancel()
+	require.NoError(t, <-served)
+}
+
+func TestListenControl(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "control.sock")
+
+	// a live instance is not replaced
+	ln, err := listenControl(socket)
+	require.NoError(t, err)
+	_, err = listenControl(socket)
+	require.ErrorIs(t, err, errSocketInUse)
+
+	require.NoError(t, ln.Close())
+
+	// a socket left behind is
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	require.FileExists(t, socket)
+	ln, err = listenControl(socket)
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+
+	// anything else is left alone
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err = listenControl(file)
+	require.Error(t, err)
+	require.FileExists(t, file)
+}
+
+func TestDaemon_status_Mounts(t *testing.T) {
+	d := newDaemon(Config{Devices: []string{"/dev/sdb", "/dev/sda"}}, hw.NewMockHDDControl(t))
+	d.mountsOf = func(dev string) []string {
+		if dev == "/dev/sdb" {
+			return []string{"/srv/media", "/mnt/backup"}
+		}
+		return nil
+	}
+	st := d.status()
+	require.Equal(t, "/dev/sda", st.Devices[0].Device)
+	require.Empty(t, st.Devices[0].Mounts)
+	require.Equal(t, []string{"/srv/media", "/mnt/backup"}, st.Devices[1].Mounts)
+}
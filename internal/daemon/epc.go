@@ -0,0 +1,35 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// currentEPCRule returns the rule that fired last before t, or nil if there
+// are no rules.
+func currentEPCRule(rules []config.EPCRule, t time.Time) *config.EPCRule {
+	var last time.Time
+	var rule *config.EPCRule
+	for i := range rules {
+		if at := rules[i].At.Next(t).Add(-24 * time.Hour); rule == nil || at.After(last) {
+			last, rule = at, &rules[i]
+		}
+	}
+	return rule
+}
+
+// EPCPolicy returns the EPC timers the rules arm at t, disabled when the
+// standby timer is used.
+func EPCPolicy(rules []config.EPCRule, t time.Time) hw.EPCTimers {
+	if rule := currentEPCRule(rules, t); rule != nil {
+		return rule.Timers
+	}
+	return hw.EPCTimers{}
+}
+
+// epcPolicy returns the EPC timers to arm now.
+func (d *Daemon) epcPolicy() hw.EPCTimers {
+	return EPCPolicy(d.cfg.EPC, d.now())
+}
@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_epcPolicy(t *testing.T) {
+	night := hw.EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}
+	rules := []config.EPCRule{
+		{At: config.CronExpr{Hour: 7, Min: 30}},
+		{At: config.CronExpr{Hour: 22, Min: 0}, Timers: night},
+	}
+	tests := []struct {
+		name  string
+		rules []config.EPCRule
+		now   time.Time
+		want  hw.EPCTimers
+	}{
+		{name: "no rules", now: time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)},
+		{name: "overnight", rules: rules, now: time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), want: night},
+		{name: "at rule time", rules: rules, now: time.Date(2025, 1, 2, 22, 0, 0, 0, time.UTC), want: night},
+		{name: "daytime uses the standby timer", rules: rules, now: time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDaemon(Config{EPC: tt.rules}, hw.NewMockHDDControl(t))
+			d.now = func() time.Time { return tt.now }
+			require.Equal(t, tt.want, d.epcPolicy())
+		})
+	}
+}
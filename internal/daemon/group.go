@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// group is a set of monitored devices backing a single volume, an md RAID,
+// ZFS pool or btrfs filesystem. I/O on the volume spins up all of them, so
+// they share one policy and their timers are armed together: spinning them
+// down one by one would only cause staggered spin-ups.
+type group struct {
+	name    string
+	members []string
+}
+
+// newGroups returns the group of each device of devs member of one of
+// arrays. Arrays sharing a device are merged, and arrays with less than two
+// monitored members are ignored.
+func newGroups(arrays []hw.Array, devs []string) map[string]*group {
+	groups := make(map[string]*group)
+	for _, a := range arrays {
+		var members []string
+		for _, m := range a.Members {
+			if slices.Contains(devs, m) {
+				members = append(members, m)
+			}
+		}
+		if len(members) < 2 {
+			continue
+		}
+
+		g := &group{name: a.String()}
+		merged := make(map[*group]bool)
+		for _, m := range members {
+			if prev := groups[m]; prev != nil && !merged[prev] {
+				merged[prev] = true
+				g.name = prev.name + "+" + g.name
+				members = append(members, prev.members...)
+			}
+		}
+		sort.Strings(members)
+		g.members = slices.Compact(members)
+		for _, m := range g.members {
+			groups[m] = g
+		}
+	}
+	return groups
+}
+
+// groupPolicy is the single policy armed on every member of a group.
+type groupPolicy struct {
+	epc bool
+	// standby is the timer of the members without EPC, the largest value
+	// chosen for any member so that none spins down before the others
+	standby int
+}
+
+// groupPolicy returns the policy to arm on the members of g.
+func (d *Daemon) groupPolicy(g *group) groupPolicy {
+	gp := groupPolicy{epc: d.groupEPC(g)}
+	for _, m := range g.members {
+		gp.standby = max(gp.standby, d.standbyValue(m))
+	}
+	logrus.Infof("group %s: arming %v (epc=%v standby=%d)", g.name, g.members, gp.epc, gp.standby)
+	return gp
+}
+
+// groupEPC tells whether the EPC policy applies to g: every member must
+// support EPC, a member known without it makes the whole group use the
+// standby timer. The support of members never armed is probed.
+func (d *Daemon) groupEPC(g *group) bool {
+	if !d.epcPolicy().Enabled() {
+		return false
+	}
+	for _, m := range g.members {
+		if _, ok := d.epc[m]; !ok && !hw.IsSpunDown(d.last[m]) {
+			_, err := d.controller.GetEPC(m)
+			switch {
+			case err == nil:
+				d.epc[m] = true
+			case errors.Is(err, hw.ErrEPCUnsupported):
+				logrus.Infof("device %s does not support epc, group %s uses standby timer", m, g.name)
+				d.epc[m] = false
+			default:
+				commandFailed("read epc", m, err)
+			}
+		}
+		if supported, ok := d.epc[m]; ok && !supported {
+			return false
+		}
+	}
+	return true
+}
+
+// wakeGroup disarms the spinning members of the group of dev, which just
+// woke up: the volume is in use again, none of them must spin down alone.
+func (d *Daemon) wakeGroup(dev string) {
+	g := d.groups[dev]
+	if g == nil {
+		return
+	}
+	for _, m := range g.members {
+		if m == dev || hw.IsSpunDown(d.last[m]) || d.applied[m] == (settings{}) {
+			continue
+		}
+		logrus.Infof("device %s woke group %s — disabling spindown timer on %s", dev, g.name, m)
+		d.disarm(m)
+	}
+}
+
+// groupList returns the groups sorted by name.
+func (d *Daemon) groupList() []*group {
+	var groups []*group
+	for _, g := range d.groups {
+		if !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGroups(t *testing.T) {
+	devs := []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd", "/dev/sde"}
+	groups := newGroups([]hw.Array{
+		{Kind: hw.ArrayMD, Name: "md0", Members: []string{"/dev/sda", "/dev/sdb"}},
+		// shares /dev/sdb with md0
+		{Kind: hw.ArrayMD, Name: "md1", Members: []string{"/dev/sdb", "/dev/sdc"}},
+		{Kind: hw.ArrayZFS, Name: "tank", Members: []string{"/dev/sdd", "/dev/sde"}},
+		// a single monitored member
+		{Kind: hw.ArrayBtrfs, Name: "media", Members: []string{"/dev/sde", "/dev/nvme0n1"}},
+	}, devs)
+
+	require.Equal(t, &group{name: "md:md0+md:md1", members: []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}}, groups["/dev/sda"])
+	require.Same(t, groups["/dev/sda"], groups["/dev/sdc"])
+	require.Equal(t, &group{name: "zfs:tank", members: []string{"/dev/sdd", "/dev/sde"}}, groups["/dev/sde"])
+	require.Len(t, groups, 5)
+}
+
+func TestNewGroups_PrefixNames(t *testing.T) {
+	devs := []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd", "/dev/sde", "/dev/sdf"}
+	groups := newGroups([]hw.Array{
+		{Kind: hw.ArrayMD, Name: "md1", Members: []string{"/dev/sda", "/dev/sdb"}},
+		// shares /dev/sdb with md1, whose name is a prefix of its own
+		{Kind: hw.ArrayMD, Name: "md10", Members: []string{"/dev/sdb", "/dev/sdc"}},
+		// shares two devices with the merged group, merged once
+		{Kind: hw.ArrayMD, Name: "md100", Members: []string{"/dev/sda", "/dev/sdc", "/dev/sdd"}},
+		// named like md1 but unrelated
+		{Kind: hw.ArrayMD, Name: "md11", Members: []string{"/dev/sde", "/dev/sdf"}},
+	}, devs)
+
+	want := &group{name: "md:md1+md:md10+md:md100", members: []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"}}
+	for _, dev := range want.members {
+		require.Equal(t, want, groups[dev], dev)
+	}
+	require.Equal(t, &group{name: "md:md11", members: []string{"/dev/sde", "/dev/sdf"}}, groups["/dev/sdf"])
+	require.NotSame(t, groups["/dev/sda"], groups["/dev/sde"])
+}
+
+func TestDaemon_GroupPolicy(t *testing.T) {
+	epc := hw.EPCTimers{StandbyZ: 30 * time.Minute}
+	tests := []struct {
+		name  string
+		epc   []config.EPCRule
+		setup func(*hw.MockHDDControl)
+	}{
+		{
+			name: "one standby value",
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+				m.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Once()
+				m.EXPECT().SetStandbyTimeout("/dev/sdd", 120).Return(nil).Once()
+			},
+		},
+		{
+			name: "epc on every member",
+			epc:  []config.EPCRule{{Timers: epc}},
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetEPC("/dev/sda").Return(hw.EPCTimers{}, nil).Once()
+				m.EXPECT().GetEPC("/dev/sdb").Return(hw.EPCTimers{}, nil).Once()
+				m.EXPECT().SetEPC("/dev/sda", epc).Return(nil).Once()
+				m.EXPECT().SetEPC("/dev/sdb", epc).Return(nil).Once()
+				m.EXPECT().SetEPC("/dev/sdd", epc).Return(nil).Once()
+			},
+		},
+		{
+			name: "a member without epc",
+			epc:  []config.EPCRule{{Timers: epc}},
+			setup: func(m *hw.MockHDDControl) {
+				m.EXPECT().GetEPC("/dev/sda").Return(hw.EPCTimers{}, nil).Once()
+				m.EXPECT().GetEPC("/dev/sdb").Return(hw.EPCTimers{}, fmt.Errorf("/dev/sdb: %w", hw.ErrEPCUnsupported)).Once()
+				m.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+				m.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Once()
+				m.EXPECT().SetEPC("/dev/sdd", epc).Return(nil).Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := hw.NewMockHDDControl(t)
+			tt.setup(mockCtrl)
+			d := newDaemon(Config{
+				Devices:      []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"},
+				StandbyValue: 120,
+				EPC:          tt.epc,
+				Arrays:       []hw.Array{{Kind: hw.ArrayMD, Name: "md0", Members: []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}}},
+			}, mockCtrl)
+			d.last["/dev/sda"] = hw.DriveStateActive
+			d.last["/dev/sdb"] = hw.DriveStateIdleB
+			// spun down members are not touched
+			d.last["/dev/sdc"] = hw.DriveStateStandby
+			d.last["/dev/sdd"] = hw.DriveStateActive
+			d.applySchedule()
+		})
+	}
+}
+
+func TestDaemon_GroupWake(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{
+		Devices:      []string{"/dev/sda", "/dev/sdb", "/dev/sdc"},
+		StandbyValue: 120,
+		Arrays:       []hw.Array{{Kind: hw.ArrayZFS, Name: "tank", Members: []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}}},
+	}, mockCtrl)
+	d.last["/dev/sda"] = hw.DriveStateStandby
+	d.last["/dev/sdb"] = hw.DriveStateActive
+	d.last["/dev/sdc"] = hw.DriveStateStandby
+	d.applied["/dev/sdb"] = settings{standby: 120}
+
+	// sda wakes: sdb must not spin down on its own, sdc is left to its own wake
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(nil).Once()
+	d.scan([]string{"/dev/sda"})
+
+	st := d.status()
+	require.Len(t, st.Groups, 1)
+	require.Equal(t, "zfs:tank", st.Groups[0].Name)
+	require.Equal(t, 2, st.Groups[0].Spinning)
+}
@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// Hold keeps a device spinning for a reason, e.g. a pre-wake rule.
+type Hold struct {
+	Reason string `json:"reason"`
+	// Until is when the hold expires, zero until it is released
+	Until time.Time `json:"until,omitzero"`
+}
+
+// hold keeps dev spinning for reason until the given time, zero for until
+// released. A held device has its standby timer disabled and is skipped by
+// the scheduled window; the policy it had, or the one of a window passed
+// meanwhile, is armed again once its last hold is released.
+func (d *Daemon) hold(dev, reason string, until time.Time) {
+	if d.holds[dev] == nil {
+		d.holds[dev] = make(map[string]time.Time)
+		d.rearm[dev] = d.applied[dev] != (settings{})
+		// a spun down device is disarmed when it wakes up
+		if state, ok := d.last[dev]; ok && !hw.IsSpunDown(state) {
+			d.disarm(dev)
+		}
+	}
+	d.holds[dev][reason] = until
+	logrus.Infof("holding %s for %s until %s", dev, reason, holdEnd(until))
+}
+
+// release drops the hold of dev for reason.
+func (d *Daemon) release(dev, reason string) {
+	if _, ok := d.holds[dev][reason]; !ok {
+		return
+	}
+	delete(d.holds[dev], reason)
+	logrus.Infof("released %s held for %s", dev, reason)
+	d.unheld([]string{dev})
+}
+
+// releaseExpired drops the holds expired at now.
+func (d *Daemon) releaseExpired(now time.Time) {
+	var devs []string
+	for dev, reasons := range d.holds {
+		for reason, until := range reasons {
+			if !until.IsZero() && !until.After(now) {
+				delete(reasons, reason)
+				logrus.Infof("hold of %s for %s expired", dev, reason)
+			}
+		}
+		devs = append(devs, dev)
+	}
+	sort.Strings(devs)
+	d.expireLeases(now)
+	d.unheld(devs)
+}
+
+// unheld arms the policy of the devices of devs left without a hold.
+func (d *Daemon) unheld(devs []string) {
+	var arm []string
+	for _, dev := range devs {
+		if len(d.holds[dev]) > 0 {
+			continue
+		}
+		delete(d.holds, dev)
+		rearm := d.rearm[dev]
+		delete(d.rearm, dev)
+		if state, ok := d.last[dev]; rearm && ok && !hw.IsSpunDown(state) {
+			arm = append(arm, dev)
+		}
+	}
+	if len(arm) > 0 {
+		logrus.Infof("rearming released devices %v", arm)
+		d.armDevices(arm)
+	}
+}
+
+// held tells whether dev has a hold.
+func (d *Daemon) held(dev string) bool {
+	return len(d.holds[dev]) > 0
+}
+
+// nextRelease returns when the first hold expires, and false when no hold
+// expires.
+func (d *Daemon) nextRelease() (time.Time, bool) {
+	var next time.Time
+	for _, reasons := range d.holds {
+		for _, until := range reasons {
+			if !until.IsZero() && (next.IsZero() || until.Before(next)) {
+				next = until
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// holdsOf returns the holds of dev sorted by reason.
+func (d *Daemon) holdsOf(dev string) []Hold {
+	var holds []Hold
+	for reason, until := range d.holds[dev] {
+		holds = append(holds, Hold{Reason: reason, Until: until})
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].Reason < holds[j].Reason })
+	return holds
+}
+
+func holdEnd(until time.Time) string {
+	if until.IsZero() {
+		return "released"
+	}
+	return until.Format(time.RFC3339)
+}
@@ -0,0 +1,186 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// inhibitorReason names the holds of i.
+func inhibitorReason(i config.Inhibitor) string {
+	switch {
+	case i.Process != "":
+		return "inhibitor process " + i.Process
+	case i.Unit != "":
+		return "inhibitor unit " + i.Unit
+	case i.LockFile != "":
+		return "inhibitor lock file " + i.LockFile
+	default:
+		return "inhibitor open files under " + i.OpenUnder
+	}
+}
+
+// checkInhibitDevices fails when an inhibitor names a device not monitored:
+// it would never keep that device spinning.
+func checkInhibitDevices(inhibitors []config.Inhibitor, devs []string) error {
+	for _, i := range inhibitors {
+		for _, dev := range i.Devices {
+			if !slices.Contains(devs, dev) {
+				return fmt.Errorf("%s: device %s is not monitored", inhibitorReason(i), dev)
+			}
+		}
+	}
+	return nil
+}
+
+// probes query the system for the inhibitor conditions.
+type probes struct {
+	// fsys is the root filesystem, with procfs
+	fsys fs.FS
+	// unitActive tells whether a systemd unit is active
+	unitActive func(unit string) (bool, error)
+}
+
+func systemProbes() probes {
+	return probes{fsys: os.DirFS("/"), unitActive: systemdUnitActive}
+}
+
+// active tells whether the condition of i holds.
+func (p probes) active(i config.Inhibitor) (bool, error) {
+	switch {
+	case i.Process != "":
+		return p.processRunning(i.Process), nil
+	case i.Unit != "":
+		return p.unitActive(i.Unit)
+	case i.LockFile != "":
+		_, err := fs.Stat(p.fsys, strings.TrimPrefix(i.LockFile, "/"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return p.openUnder(i.OpenUnder), nil
+	}
+}
+
+// processRunning tells whether the command name or the executable name of
+// a process matches pattern.
+func (p probes) processRunning(pattern string) bool {
+	return p.anyProcess(func(dir string) bool {
+		if comm, err := fs.ReadFile(p.fsys, path.Join(dir, "comm")); err == nil {
+			if ok, _ := path.Match(pattern, strings.TrimSuffix(string(comm), "\n")); ok {
+				return true
+			}
+		}
+		// the command name is truncated to 15 characters
+		cmdline, err := fs.ReadFile(p.fsys, path.Join(dir, "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			return false
+		}
+		argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+		ok, _ := path.Match(pattern, path.Base(argv0))
+		return ok
+	})
+}
+
+// openUnder tells whether a process has a file or its working directory
+// under dir.
+func (p probes) openUnder(dir string) bool {
+	dir = strings.TrimSuffix(dir, "/")
+	return p.anyProcess(func(proc string) bool {
+		links := []string{path.Join(proc, "cwd")}
+		if fds, err := fs.ReadDir(p.fsys, path.Join(proc, "fd")); err == nil {
+			for _, fd := range fds {
+				links = append(links, path.Join(proc, "fd", fd.Name()))
+			}
+		}
+		for _, link := range links {
+			target, err := fs.ReadLink(p.fsys, link)
+			if err == nil && (target == dir || strings.HasPrefix(target, dir+"/")) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// anyProcess tells whether match returns true for the procfs directory of
+// a process other than the daemon.
+func (p probes) anyProcess(match func(dir string) bool) bool {
+	entries, err := fs.ReadDir(p.fsys, "proc")
+	if err != nil {
+		return false
+	}
+	self := strconv.Itoa(os.Getpid())
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil || e.Name() == self {
+			continue
+		}
+		if match(path.Join("proc", e.Name())) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemdUnitActive asks systemctl whether unit is active.
+func systemdUnitActive(unit string) (bool, error) {
+	err := exec.Command(systemctlPath(), "is-active", "--quiet", unit).Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		// inactive, failed or unknown
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func systemctlPath() string {
+	if p, ok := os.LookupEnv("SYSTEMCTL_PATH"); ok && p != "" {
+		return p
+	}
+	return "/usr/bin/systemctl"
+}
+
+// checkInhibitors holds the devices of the inhibitors whose condition
+// became true and releases the ones of those that cleared. A condition that
+// cannot be checked keeps its previous state.
+func (d *Daemon) checkInhibitors(devs []string) {
+	for n, i := range d.cfg.Inhibit {
+		reason := inhibitorReason(i)
+		active, err := d.probes.active(i)
+		if err != nil {
+			logrus.Warnf("failed to check inhibitor %s: %v", reason, err)
+			continue
+		}
+		if active == d.inhibited[n] {
+			continue
+		}
+		d.inhibited[n] = active
+		targets := devs
+		if len(i.Devices) > 0 {
+			targets = i.Devices
+		}
+		if active {
+			logrus.Infof("%s active", reason)
+		} else {
+			logrus.Infof("%s cleared", reason)
+		}
+		for _, dev := range targets {
+			if active {
+				d.hold(dev, reason, time.Time{})
+			} else {
+				d.release(dev, reason)
+			}
+		}
+	}
+}
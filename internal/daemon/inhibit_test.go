@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInhibitor_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		i       config.Inhibitor
+		wantErr string
+	}{
+		{name: "process", i: config.Inhibitor{Process: "rsync"}},
+		{name: "open under", i: config.Inhibitor{OpenUnder: "/mnt/media"}},
+		{name: "no condition", i: config.Inhibitor{Devices: []string{"/dev/sda"}}, wantErr: "inhibitor sets 0 conditions"},
+		{name: "bad pattern", i: config.Inhibitor{Process: "[ffmpeg"}, wantErr: `invalid process pattern "[ffmpeg"`},
+		{name: "relative", i: config.Inhibitor{OpenUnder: "mnt"}, wantErr: `inhibitor path "mnt" is not absolute`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.i.Validate()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckInhibitDevices(t *testing.T) {
+	devs := []string{"/dev/sda", "/dev/sdb"}
+	tests := []struct {
+		name       string
+		inhibitors []config.Inhibitor
+		wantErr    string
+	}{
+		{name: "no inhibitors"},
+		{name: "all devices", inhibitors: []config.Inhibitor{{Process: "rsync"}}},
+		{name: "monitored", inhibitors: []config.Inhibitor{{Process: "rsync", Devices: []string{"/dev/sdb"}}}},
+		{
+			name:       "unknown",
+			inhibitors: []config.Inhibitor{{Unit: "backup.service", Devices: []string{"/dev/sda", "/dev/sdc"}}},
+			wantErr:    "inhibitor unit backup.service: device /dev/sdc is not monitored",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkInhibitDevices(tt.inhibitors, devs)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestProbes_active(t *testing.T) {
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	p := probes{
+		fsys: fstest.MapFS{
+			"proc/10/comm":    &fstest.MapFile{Data: []byte("jellyfin-ffmpeg\n")},
+			"proc/10/cmdline": &fstest.MapFile{Data: []byte("/usr/lib/jellyfin-ffmpeg/ffmpeg\x00-i\x00/mnt/media/a.mkv\x00")},
+			"proc/10/cwd":     link("/var/lib/jellyfin"),
+			"proc/10/fd/4":    link("/mnt/media/a.mkv"),
+			"proc/20/comm":    &fstest.MapFile{Data: []byte("rsync-backup-da\n")},
+			"proc/20/cmdline": &fstest.MapFile{Data: []byte("/usr/local/bin/rsync-backup-daily\x00")},
+			"proc/20/cwd":     link("/mnt/mediaextra"),
+			"run/rsync.lock":  &fstest.MapFile{},
+		},
+		unitActive: func(unit string) (bool, error) {
+			if unit == "broken.service" {
+				return false, errors.New("dbus unavailable")
+			}
+			return unit == "backup.service", nil
+		},
+	}
+	tests := []struct {
+		name    string
+		i       config.Inhibitor
+		want    bool
+		wantErr bool
+	}{
+		{name: "command name", i: config.Inhibitor{Process: "jellyfin-*"}, want: true},
+		{name: "executable name", i: config.Inhibitor{Process: "ffmpeg"}, want: true},
+		{name: "truncated command name", i: config.Inhibitor{Process: "rsync-backup-daily"}, want: true},
+		{name: "no process", i: config.Inhibitor{Process: "rsync"}},
+		{name: "active unit", i: config.Inhibitor{Unit: "backup.service"}, want: true},
+		{name: "inactive unit", i: config.Inhibitor{Unit: "plex.service"}},
+		{name: "unit error", i: config.Inhibitor{Unit: "broken.service"}, wantErr: true},
+		{name: "lock file", i: config.Inhibitor{LockFile: "/run/rsync.lock"}, want: true},
+		{name: "no lock file", i: config.Inhibitor{LockFile: "/run/backup.lock"}},
+		{name: "open file", i: config.Inhibitor{OpenUnder: "/mnt/media/"}, want: true},
+		{name: "working directory", i: config.Inhibitor{OpenUnder: "/mnt/mediaextra"}, want: true},
+		{name: "nothing open", i: config.Inhibitor{OpenUnder: "/srv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.active(tt.i)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDaemon_checkInhibitors_SharedCondition(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	fsys := fstest.MapFS{}
+	d := newDaemon(Config{
+		StandbyValue: 120,
+		Inhibit: []config.Inhibitor{
+			{LockFile: "/run/rsync.lock", Devices: []string{"/dev/sda"}},
+			{LockFile: "/run/rsync.lock", Devices: []string{"/dev/sdb"}},
+		},
+	}, mockCtrl)
+	d.probes = probes{fsys: fsys}
+	d.last["/dev/sda"] = hw.DriveStateStandby
+	d.last["/dev/sdb"] = hw.DriveStateStandby
+	devs := []string{"/dev/sda", "/dev/sdb"}
+
+	fsys["run/rsync.lock"] = &fstest.MapFile{}
+	d.checkInhibitors(devs)
+	require.True(t, d.held("/dev/sda"))
+	require.True(t, d.held("/dev/sdb"))
+
+	delete(fsys, "run/rsync.lock")
+	d.checkInhibitors(devs)
+	require.False(t, d.held("/dev/sda"))
+	require.False(t, d.held("/dev/sdb"))
+}
+
+func TestDaemon_checkInhibitors(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	fsys := fstest.MapFS{}
+	var unitActive bool
+	var unitErr error
+	d := newDaemon(Config{
+		StandbyValue: 120,
+		Inhibit: []config.Inhibitor{
+			{LockFile: "/run/rsync.lock", Devices: []string{"/dev/sdb"}},
+			{Unit: "backup.service"},
+		},
+	}, mockCtrl)
+	d.probes = probes{fsys: fsys, unitActive: func(string) (bool, error) { return unitActive, unitErr }}
+	d.last["/dev/sda"] = hw.DriveStateActive
+	d.last["/dev/sdb"] = hw.DriveStateStandby
+	d.applied["/dev/sda"] = settings{standby: 120}
+	d.applied["/dev/sdb"] = settings{standby: 120}
+	devs := []string{"/dev/sda", "/dev/sdb"}
+
+	d.checkInhibitors(devs)
+	require.False(t, d.held("/dev/sdb"))
+
+	// the spun down device is disarmed when it wakes up
+	fsys["run/rsync.lock"] = &fstest.MapFile{}
+	d.checkInhibitors(devs)
+	require.Equal(t, []Hold{{Reason: "inhibitor lock file /run/rsync.lock"}}, d.holdsOf("/dev/sdb"))
+	require.False(t, d.held("/dev/sda"))
+
+	// the window is deferred until the inhibitor clears
+	d.last["/dev/sdb"] = hw.DriveStateActive
+	d.applied["/dev/sdb"] = settings{}
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	d.applySchedule()
+
+	// a spinning device is disarmed right away
+	unitActive = true
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+	d.checkInhibitors(devs)
+	require.Equal(t, []Hold{{Reason: "inhibitor unit backup.service"}}, d.holdsOf("/dev/sda"))
+	require.Len(t, d.holdsOf("/dev/sdb"), 2)
+
+	// a condition that cannot be checked keeps its holds
+	unitErr = errors.New("dbus unavailable")
+	d.checkInhibitors(devs)
+	require.True(t, d.held("/dev/sda"))
+
+	unitActive, unitErr = false, nil
+	delete(fsys, "run/rsync.lock")
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Once()
+	d.checkInhibitors(devs)
+	require.False(t, d.held("/dev/sda"))
+	require.False(t, d.held("/dev/sdb"))
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sdb"])
+}
@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxLeaseTTL bounds the time to live of a lease, so that a client dying
+// without releasing it does not keep disks spinning for long.
+const MaxLeaseTTL = 24 * time.Hour
+
+var (
+	// ErrLeaseNotFound is returned for an unknown or expired lease.
+	ErrLeaseNotFound = errors.New("lease not found")
+	// ErrInvalidLease is returned for a lease request that cannot be granted.
+	ErrInvalidLease = errors.New("invalid lease")
+)
+
+// LeaseRequest acquires or renews a lease.
+type LeaseRequest struct {
+	// Devices to keep spinning, all monitored devices when empty. Ignored on
+	// renewal.
+	Devices []string `json:"devices,omitempty"`
+	// TTL is how long the lease lasts unless renewed, e.g. "5m"
+	TTL string `json:"ttl"`
+	// Owner describes the client, e.g. its command
+	Owner string `json:"owner,omitempty"`
+}
+
+// Lease holds devices spinning for a client until it is released or its
+// TTL passes without renewal.
+type Lease struct {
+	ID      string    `json:"id"`
+	Owner   string    `json:"owner,omitempty"`
+	Devices []string  `json:"devices"`
+	Expires time.Time `json:"expires"`
+}
+
+// reason names the holds of the lease.
+func (l *Lease) reason() string {
+	if l.Owner == "" {
+		return "lease " + l.ID
+	}
+	return fmt.Sprintf("lease %s (%s)", l.ID, l.Owner)
+}
+
+// leaseOp is a lease operation of the control socket, served by the main
+// loop.
+type leaseOp struct {
+	// id is the lease to renew or release, empty to acquire one
+	id      string
+	release bool
+	req     LeaseRequest
+	reply   chan leaseReply
+}
+
+type leaseReply struct {
+	lease Lease
+	err   error
+}
+
+// parseTTL parses the TTL of a lease request.
+func parseTTL(s string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: ttl: %w", ErrInvalidLease, err)
+	}
+	if ttl <= 0 || ttl > MaxLeaseTTL {
+		return 0, fmt.Errorf("%w: ttl %s must be in (0, %s]", ErrInvalidLease, ttl, MaxLeaseTTL)
+	}
+	return ttl, nil
+}
+
+// serveLease runs op. Only the main loop may call it.
+func (d *Daemon) serveLease(op leaseOp) (Lease, error) {
+	now := d.now()
+	d.expireLeases(now)
+	if op.id == "" {
+		return d.acquireLease(op.req, now)
+	}
+	l, ok := d.leases[op.id]
+	if !ok {
+		return Lease{}, fmt.Errorf("%w: %s", ErrLeaseNotFound, op.id)
+	}
+	if op.release {
+		delete(d.leases, op.id)
+		logrus.Infof("%s released", l.reason())
+		for _, dev := range l.Devices {
+			d.release(dev, l.reason())
+		}
+		return *l, nil
+	}
+	ttl, err := parseTTL(op.req.TTL)
+	if err != nil {
+		return Lease{}, err
+	}
+	l.Expires = now.Add(ttl)
+	for _, dev := range l.Devices {
+		if _, ok := d.holds[dev][l.reason()]; ok {
+			d.holds[dev][l.reason()] = l.Expires
+		}
+	}
+	logrus.Debugf("%s renewed until %s", l.reason(), l.Expires.Format(time.RFC3339))
+	return *l, nil
+}
+
+// acquireLease holds the devices of req for its TTL.
+func (d *Daemon) acquireLease(req LeaseRequest, now time.Time) (Lease, error) {
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		return Lease{}, err
+	}
+	devs := slices.Clone(req.Devices)
+	if len(devs) == 0 {
+		devs = slices.Clone(d.cfg.Devices)
+	}
+	for _, dev := range devs {
+		if !slices.Contains(d.cfg.Devices, dev) {
+			return Lease{}, fmt.Errorf("%w: %s is not monitored", ErrInvalidLease, dev)
+		}
+	}
+	sort.Strings(devs)
+	devs = slices.Compact(devs)
+
+	id := make([]byte, 4)
+	// nolint:errcheck
+	rand.Read(id)
+	l := &Lease{ID: hex.EncodeToString(id), Owner: req.Owner, Devices: devs, Expires: now.Add(ttl)}
+	d.leases[l.ID] = l
+	for _, dev := range devs {
+		d.hold(dev, l.reason(), l.Expires)
+	}
+	return *l, nil
+}
+
+// expireLeases forgets the leases expired at now, whose holds are dropped
+// by releaseExpired.
+func (d *Daemon) expireLeases(now time.Time) {
+	for id, l := range d.leases {
+		if !l.Expires.After(now) {
+			delete(d.leases, id)
+		}
+	}
+}
+
+// requestLease asks the main loop to run op.
+func (d *Daemon) requestLease(ctx context.Context, op leaseOp) (Lease, error) {
+	op.reply = make(chan leaseReply, 1)
+	select {
+	case d.leaseCh <- op:
+	case <-ctx.Done():
+		return Lease{}, ctx.Err()
+	}
+	select {
+	case r := <-op.reply:
+		return r.lease, r.err
+	case <-ctx.Done():
+		return Lease{}, ctx.Err()
+	}
+}
@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_serveLease(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{Devices: []string{"/dev/sda", "/dev/sdb"}, StandbyValue: 120}, mockCtrl)
+	d.now = func() time.Time { return now }
+	d.last["/dev/sda"] = hw.DriveStateActive
+	d.last["/dev/sdb"] = hw.DriveStateStandby
+	d.applied["/dev/sda"] = settings{standby: 120}
+
+	tests := []struct {
+		name    string
+		op      leaseOp
+		wantErr error
+	}{
+		{name: "no ttl", op: leaseOp{req: LeaseRequest{}}, wantErr: ErrInvalidLease},
+		{name: "ttl too long", op: leaseOp{req: LeaseRequest{TTL: "48h"}}, wantErr: ErrInvalidLease},
+		{name: "unmonitored device", op: leaseOp{req: LeaseRequest{TTL: "1m", Devices: []string{"/dev/sdz"}}}, wantErr: ErrInvalidLease},
+		{name: "renew unknown", op: leaseOp{id: "beef", req: LeaseRequest{TTL: "1m"}}, wantErr: ErrLeaseNotFound},
+		{name: "release unknown", op: leaseOp{id: "beef", release: true}, wantErr: ErrLeaseNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := d.serveLease(tt.op)
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+	require.Empty(t, d.holds)
+
+	// all devices by default, the spinning one disarmed
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+	lease, err := d.serveLease(leaseOp{req: LeaseRequest{TTL: "1m", Owner: "backup.sh"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/dev/sda", "/dev/sdb"}, lease.Devices)
+	require.Equal(t, now.Add(time.Minute), lease.Expires)
+	require.Equal(t, []Hold{{Reason: "lease " + lease.ID + " (backup.sh)", Until: now.Add(time.Minute)}}, d.holdsOf("/dev/sda"))
+
+	now = now.Add(50 * time.Second)
+	renewed, err := d.serveLease(leaseOp{id: lease.ID, req: LeaseRequest{TTL: "1m"}})
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute), renewed.Expires)
+	next, ok := d.nextRelease()
+	require.True(t, ok)
+	require.Equal(t, now.Add(time.Minute), next)
+
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	_, err = d.serveLease(leaseOp{id: lease.ID, release: true})
+	require.NoError(t, err)
+	require.Empty(t, d.holds)
+	require.Empty(t, d.leases)
+
+	// a client that died lets its lease expire
+	lease, err = d.serveLease(leaseOp{req: LeaseRequest{TTL: "1m", Devices: []string{"/dev/sdb", "/dev/sdb"}}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/dev/sdb"}, lease.Devices)
+	d.releaseExpired(now.Add(time.Minute))
+	require.Empty(t, d.holds)
+	_, err = d.serveLease(leaseOp{id: lease.ID, req: LeaseRequest{TTL: "1m"}})
+	require.ErrorIs(t, err, ErrLeaseNotFound)
+}
+
+func TestClient_Lease(t *testing.T) {
+	d := newDaemon(Config{
+		Devices:      []string{"/dev/sda"},
+		PollInterval: time.Hour,
+		Cron:         &config.CronExpr{Hour: 22, Min: 0},
+	}, hw.NewMockHDDControl(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.mainLoop(ctx, nil)
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := listenControl(socket)
+	require.NoError(t, err)
+	go func() {
+		// nolint:errcheck
+		d.serveControl(ctx, ln)
+	}()
+
+	client := NewClient(socket)
+	var lease *Lease
+	require.Eventually(t, func() bool {
+		var err error
+		lease, err = client.Acquire(nil, time.Minute, "test")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"/dev/sda"}, lease.Devices)
+
+	st, err := client.Status()
+	require.NoError(t, err)
+	require.Equal(t, "lease "+lease.ID+" (test)", st.Devices[0].Holds[0].Reason)
+
+	_, err = client.Renew(lease.ID, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, client.Release(lease.ID))
+	require.ErrorContains(t, client.Release(lease.ID), "404 Not Found")
+	_, err = client.Acquire([]string{"/dev/sdz"}, time.Minute, "")
+	require.ErrorContains(t, err, "400 Bad Request")
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"maps"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// minSuspend is the smallest gap between the wall and the monotonic clock
+// taken as a system suspend rather than a clock adjustment.
+const minSuspend = 30 * time.Second
+
+// settings are the power settings the daemon applied to a device. Drives
+// forget them on a link reset or a power loss during suspend.
+type settings struct {
+	// standby is the hdparm -S value, 0 once the timer is disabled
+	standby int
+	// epc are the EPC timers armed, in place of the standby timer
+	epc hw.EPCTimers
+}
+
+// suspended returns how long the system was suspended between two polls
+// given the time elapsed on the wall and the monotonic clock, or 0. The
+// monotonic clock stops during suspend while the wall clock does not.
+func suspended(wall, mono time.Duration) time.Duration {
+	if gap := wall - mono; gap >= minSuspend {
+		return gap
+	}
+	return 0
+}
+
+// requestReapply asks the main loop to reapply the settings of dev, or of
+// every device when dev is empty.
+func (d *Daemon) requestReapply(dev string) {
+	select {
+	case d.reapplyCh <- dev:
+	default:
+		logrus.Warnf("reapply queue full, dropping request for %q", dev)
+	}
+}
+
+// reapply refreshes the state of devs and restores the settings applied to
+// the spinning ones. Spun down devices are left alone, querying the timers
+// could wake them up: they get their settings back when they wake up.
+func (d *Daemon) reapply(devs []string) {
+	logrus.Infof("reapplying power settings of %v", devs)
+	before := maps.Clone(d.last)
+	d.scan(devs)
+	for _, dev := range devs {
+		prev, seen := before[dev]
+		if !seen || hw.IsSpunDown(prev) {
+			// first seen or woke up: scan already restored its settings
+			continue
+		}
+		if hw.IsSpunDown(d.last[dev]) {
+			continue
+		}
+		if s, ok := d.applied[dev]; ok {
+			switch {
+			case s.epc.Enabled():
+				if !d.armEPC(dev, s.epc) {
+					d.armStandby(dev, d.standbyValue(dev))
+				}
+			case s.standby > 0:
+				d.armStandby(dev, s.standby)
+			default:
+				d.disarm(dev)
+			}
+		}
+		d.reapplyAPM(dev)
+	}
+}
@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuspended(t *testing.T) {
+	require.Zero(t, suspended(10*time.Second, 10*time.Second))
+	// a small clock step is not a suspend
+	require.Zero(t, suspended(15*time.Second, 10*time.Second))
+	require.Zero(t, suspended(-time.Hour, 10*time.Second))
+	require.Equal(t, time.Hour, suspended(time.Hour+10*time.Second, 10*time.Second))
+}
+
+func TestParseUevent(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		wantDev string
+		wantOK  bool
+	}{
+		{
+			name:    "disk revalidated",
+			msg:     "change@/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sda\x00ACTION=change\x00DEVPATH=/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sda\x00SUBSYSTEM=block\x00DEVNAME=sda\x00DEVTYPE=disk\x00SEQNUM=4242\x00",
+			wantDev: "/dev/sda",
+			wantOK:  true,
+		},
+		{
+			name:    "disk added",
+			msg:     "add@/devices/virtual/block/sdb\x00ACTION=add\x00SUBSYSTEM=block\x00DEVNAME=sdb\x00DEVTYPE=disk\x00",
+			wantDev: "/dev/sdb",
+			wantOK:  true,
+		},
+		{
+			name: "partition",
+			msg:  "change@/block/sda/sda1\x00ACTION=change\x00SUBSYSTEM=block\x00DEVNAME=sda1\x00DEVTYPE=partition\x00",
+		},
+		{
+			name: "disk removed",
+			msg:  "remove@/block/sda\x00ACTION=remove\x00SUBSYSTEM=block\x00DEVNAME=sda\x00DEVTYPE=disk\x00",
+		},
+		{
+			name: "other subsystem",
+			msg:  "change@/devices/system/cpu/cpu0\x00ACTION=change\x00SUBSYSTEM=cpu\x00",
+		},
+		{
+			name: "udev rebroadcast",
+			msg:  "libudev\x00\xfe\xed\xca\xfe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, ok := parseUevent([]byte(tt.msg))
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantDev, dev)
+		})
+	}
+}
+
+func TestDaemon_reapply(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{StandbyValue: 120}, mockCtrl)
+	d.apmLevel = 127
+	d.last = map[string]string{
+		"/dev/sda": hw.DriveStateActive,
+		"/dev/sdb": hw.DriveStateActive,
+		"/dev/sdc": hw.DriveStateStandby,
+		"/dev/sdd": hw.DriveStateStandby,
+	}
+	d.applied["/dev/sda"] = settings{standby: 120}
+	d.applied["/dev/sdb"] = settings{}
+
+	// armed drive lost its timer and APM level
+	mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sda").Return(254, nil).Once()
+	mockCtrl.EXPECT().SetAPM("/dev/sda", 127).Return(nil).Once()
+	// disarmed drive
+	mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateIdleB, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdb").Return(127, nil).Once()
+	// spun down drive: left alone
+	mockCtrl.EXPECT().GetState("/dev/sdc").Return(hw.DriveStateStandby, nil).Once()
+	// resume spun it up: restored once by the wake transition
+	mockCtrl.EXPECT().GetState("/dev/sdd").Return(hw.DriveStateActive, nil).Once()
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdd", 0).Return(nil).Once()
+	mockCtrl.EXPECT().GetAPM("/dev/sdd").Return(127, nil).Once()
+
+	d.reapply([]string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"})
+}
+
+func TestDaemon_mainLoop_ReapplyRequest(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		mockCtrl := hw.NewMockHDDControl(t)
+		d := newDaemon(Config{
+			PollInterval: time.Hour,
+			Cron:         &config.CronExpr{Hour: 1, Min: 0},
+			StandbyValue: 120,
+		}, mockCtrl)
+		d.last["/dev/sda"] = hw.DriveStateActive
+		d.last["/dev/sdb"] = hw.DriveStateActive
+		d.applied["/dev/sda"] = settings{standby: 120}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			d.mainLoop(ctx, []string{"/dev/sda", "/dev/sdb"})
+			close(done)
+		}()
+		synctest.Wait()
+
+		// uevent for a monitored device
+		mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+		d.requestReapply("/dev/sda")
+		synctest.Wait()
+
+		// uevent for an unmonitored device is ignored
+		d.requestReapply("/dev/sdz")
+		synctest.Wait()
+
+		// resume notification
+		mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Once()
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+		mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Once()
+		d.requestReapply("")
+		synctest.Wait()
+
+		cancel()
+		<-done
+	})
+}
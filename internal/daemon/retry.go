@@ -0,0 +1,194 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the retry settings left unset.
+const (
+	defaultRetryDelay    = 30 * time.Second
+	defaultRetryMaxDelay = 30 * time.Minute
+	defaultRetryAttempts = 6
+)
+
+// retryBackoff returns the delay before the retry following the failures-th
+// failure of a command, jitter excluded.
+func retryBackoff(c config.Retry, failures int) time.Duration {
+	delay, maxDelay := c.Delay, c.MaxDelay
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+	if maxDelay == 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// retryAttempts returns the number of retries before a device is degraded.
+func retryAttempts(c config.Retry) int {
+	if c.Attempts == 0 {
+		return defaultRetryAttempts
+	}
+	return c.Attempts
+}
+
+// Kinds of retried commands. A command supersedes the retry of the same kind
+// pending on its device: the standby and EPC timers make up one power policy.
+const (
+	retryPolicy = "policy"
+	retryAPM    = "apm"
+)
+
+// retryKey is a kind of command on a device.
+type retryKey struct {
+	dev, kind string
+}
+
+// pendingRetry is a failed command waiting for its retry.
+type pendingRetry struct {
+	command string
+	run     func() error
+	// failures of the command so far, the first attempt included
+	failures int
+	due      time.Time
+	err      error
+}
+
+// RetryStatus is a failed command waiting for its retry.
+type RetryStatus struct {
+	Device  string `json:"device"`
+	Command string `json:"command"`
+	// Failures counts the attempts so far, the first one included
+	Failures int       `json:"failures"`
+	Due      time.Time `json:"due"`
+	Error    string    `json:"error"`
+}
+
+// command runs a command of kind changing the power settings of dev, and
+// retries it later with an exponential backoff if it fails.
+func (d *Daemon) command(dev, kind, command string, run func() error) {
+	d.settle(retryKey{dev: dev, kind: kind}, command, run, run())
+}
+
+// settle records the outcome err of a run of command. A device spun down
+// meanwhile is not retried: its settings are restored when it wakes up. A
+// setting the drive did not apply is not retried either, the drive would
+// ignore it again, and it does not degrade the device.
+func (d *Daemon) settle(key retryKey, command string, run func() error, err error) {
+	p := d.retries[key]
+	switch {
+	case err == nil:
+		delete(d.notApplied, key)
+		if p != nil {
+			logrus.Infof("%s on %s succeeded after %d failures", command, key.dev, p.failures)
+			delete(d.retries, key)
+		}
+		if reason, ok := d.degraded[key.dev]; ok {
+			logrus.Infof("device %s recovered, degraded by %s", key.dev, reason)
+			delete(d.degraded, key.dev)
+		}
+		return
+	case errors.Is(err, hw.ErrWouldWake):
+		commandFailed(command, key.dev, err)
+		delete(d.retries, key)
+		return
+	case errors.Is(err, hw.ErrNotApplied):
+		delete(d.retries, key)
+		if d.notApplied[key] {
+			logrus.Debugf("%s on %s not applied: %v", command, key.dev, err)
+			return
+		}
+		d.notApplied[key] = true
+		commandFailed(command, key.dev, err)
+		return
+	}
+
+	commandFailed(command, key.dev, err)
+	if _, ok := d.degraded[key.dev]; ok {
+		delete(d.retries, key)
+		return
+	}
+	if p == nil {
+		p = &pendingRetry{}
+		d.retries[key] = p
+	}
+	p.command, p.run, p.err = command, run, err
+	p.failures++
+	if p.failures > retryAttempts(d.cfg.Retry) {
+		delete(d.retries, key)
+		d.degraded[key.dev] = fmt.Sprintf("%s: %v", command, err)
+		logrus.Errorf("device %s degraded: %s failed %d times, no longer retried", key.dev, command, p.failures)
+		return
+	}
+	delay := retryBackoff(d.cfg.Retry, p.failures)
+	delay -= d.jitter(delay / 2)
+	p.due = d.now().Add(delay)
+	logrus.Warnf("retrying %s on %s in %s", command, key.dev, delay.Round(time.Second))
+}
+
+// nextRetry returns the due time of the earliest pending retry, false if
+// there is none.
+func (d *Daemon) nextRetry() (time.Time, bool) {
+	var next time.Time
+	for _, p := range d.retries {
+		if next.IsZero() || p.due.Before(next) {
+			next = p.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// runRetries runs the pending retries due at now.
+func (d *Daemon) runRetries(now time.Time) {
+	var due []retryKey
+	for key, p := range d.retries {
+		if !p.due.After(now) {
+			due = append(due, key)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].dev < due[j].dev || due[i].dev == due[j].dev && due[i].kind < due[j].kind
+	})
+	for _, key := range due {
+		p := d.retries[key]
+		logrus.Infof("retrying %s on %s, failed %d times", p.command, key.dev, p.failures)
+		d.settle(key, p.command, p.run, p.run())
+	}
+}
+
+// randomJitter returns a random duration in [0, limit).
+func randomJitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// retryStatus lists the pending retries, by device.
+func (d *Daemon) retryStatus() []RetryStatus {
+	var retries []RetryStatus
+	for key, p := range d.retries {
+		retries = append(retries, RetryStatus{
+			Device:   key.dev,
+			Command:  p.command,
+			Failures: p.failures,
+			Due:      p.due,
+			Error:    p.err.Error(),
+		})
+	}
+	sort.Slice(retries, func(i, j int) bool {
+		return retries[i].Device < retries[j].Device || retries[i].Device == retries[j].Device && retries[i].Command < retries[j].Command
+	})
+	return retries
+}
@@ -0,0 +1,160 @@
+package daemon
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/managers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry_backoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		retry    config.Retry
+		failures int
+		want     time.Duration
+	}{
+		{name: "defaults", failures: 1, want: 30 * time.Second},
+		{name: "doubled", failures: 3, want: 2 * time.Minute},
+		{name: "default cap", failures: 20, want: 30 * time.Minute},
+		{name: "configured", retry: config.Retry{Delay: 10 * time.Second, MaxDelay: time.Minute}, failures: 2, want: 20 * time.Second},
+		{name: "configured cap", retry: config.Retry{Delay: 10 * time.Second, MaxDelay: time.Minute}, failures: 4, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, retryBackoff(tt.retry, tt.failures))
+		})
+	}
+}
+
+func TestDaemon_retries(t *testing.T) {
+	ioErr := errors.New("HDIO_DRIVE_CMD(setidle) failed: Input/output error")
+	mockCtrl := hw.NewMockHDDControl(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{
+		Devices:      []string{"/dev/sda", "/dev/sdb"},
+		StandbyValue: 120,
+		Retry:        config.Retry{Delay: time.Minute, Attempts: 2},
+	}, mockCtrl)
+	d.now = func() time.Time { return now }
+	// half the delay taken off
+	d.jitter = func(limit time.Duration) time.Duration { return limit }
+
+	// the timer of sda is not disabled after a wake-up
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(ioErr).Once()
+	d.disarm("/dev/sda")
+	due, ok := d.nextRetry()
+	require.True(t, ok)
+	require.Equal(t, now.Add(30*time.Second), due)
+
+	// not due yet
+	d.runRetries(now.Add(10 * time.Second))
+
+	// fails again, backs off
+	now = due
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(ioErr).Once()
+	d.runRetries(now)
+	st := d.status()
+	require.Equal(t, []RetryStatus{
+		{Device: "/dev/sda", Command: "disable spindown", Failures: 2, Due: now.Add(time.Minute), Error: ioErr.Error()},
+	}, st.Retries)
+	metrics := new(strings.Builder)
+	writeMetrics(metrics, st)
+	require.Contains(t, metrics.String(), `hd_smart_idle_pending_retries{device="/dev/sda"} 1`)
+	require.Contains(t, metrics.String(), `hd_smart_idle_pending_retries{device="/dev/sdb"} 0`)
+
+	// out of attempts: degraded and no longer retried
+	now = now.Add(time.Minute)
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(ioErr).Once()
+	d.runRetries(now)
+	_, ok = d.nextRetry()
+	require.False(t, ok)
+	st = d.status()
+	require.Equal(t, "disable spindown: "+ioErr.Error(), st.Devices[0].Degraded)
+	metrics.Reset()
+	writeMetrics(metrics, st)
+	require.Contains(t, metrics.String(), `hd_smart_idle_degraded{device="/dev/sda"} 1`)
+
+	// a degraded device is not retried
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(ioErr).Once()
+	d.armStandby("/dev/sda", 120)
+	_, ok = d.nextRetry()
+	require.False(t, ok)
+
+	// until a command succeeds
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	d.armStandby("/dev/sda", 120)
+	require.Empty(t, d.status().Devices[0].Degraded)
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sda"])
+
+	// a later command supersedes the pending retry
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(ioErr).Once()
+	d.armStandby("/dev/sdb", 120)
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(nil).Once()
+	d.disarm("/dev/sdb")
+	_, ok = d.nextRetry()
+	require.False(t, ok)
+
+	// a device spun down is not retried, it is disarmed when it wakes up
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(ioErr).Once()
+	d.disarm("/dev/sdb")
+	now = now.Add(time.Hour)
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(hw.ErrWouldWake).Once()
+	d.runRetries(now)
+	_, ok = d.nextRetry()
+	require.False(t, ok)
+}
+
+func TestDaemon_retryAPM(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{Devices: []string{"/dev/sda"}}, mockCtrl)
+	d.now = func() time.Time { return now }
+	d.jitter = func(time.Duration) time.Duration { return 0 }
+	d.apmLevel = 254
+
+	ioErr := errors.New("HDIO_DRIVE_CMD(setapm) failed: Input/output error")
+	mockCtrl.EXPECT().SetAPM("/dev/sda", 254).Return(ioErr).Once()
+	d.setAPM("/dev/sda")
+	due, ok := d.nextRetry()
+	require.True(t, ok)
+	require.Equal(t, now.Add(defaultRetryDelay), due)
+
+	mockCtrl.EXPECT().SetAPM("/dev/sda", 254).Return(nil).Once()
+	d.runRetries(due)
+	_, ok = d.nextRetry()
+	require.False(t, ok)
+}
+
+func TestDaemon_apmNotApplied(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{Devices: []string{"/dev/sda"}, Retry: config.Retry{Attempts: 1}}, mockCtrl)
+	d.managers = func() []managers.Manager { return nil }
+	d.apmLevel = 127
+	d.last["/dev/sda"] = hw.DriveStateActive
+
+	// a drive rounding the level is neither retried nor degraded
+	for range 3 {
+		mockCtrl.EXPECT().SetAPM("/dev/sda", 127).Return(hw.ErrNotApplied).Once()
+		mockCtrl.EXPECT().GetAPM("/dev/sda").Return(128, nil).Once()
+		d.setAPM("/dev/sda")
+		_, ok := d.nextRetry()
+		require.False(t, ok)
+		require.Empty(t, d.status().Devices[0].Degraded)
+	}
+
+	// nor is the level it rounds to reported as drifted
+	mockCtrl.EXPECT().GetAPM("/dev/sda").Return(128, nil).Once()
+	d.verifySettings([]string{"/dev/sda"})
+	require.Empty(t, d.drifts)
+
+	// unlike another level
+	mockCtrl.EXPECT().GetAPM("/dev/sda").Return(254, nil).Once()
+	d.verifySettings([]string{"/dev/sda"})
+	require.Len(t, d.drifts, 1)
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// noSysfs is the sysfs of simulated drives: they have none.
+type noSysfs struct{}
+
+func (noSysfs) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Simulate runs the main loop of the daemon against controller on a virtual
+// clock from start until end: whenever the loop waits for its next timer,
+// the clock jumps to it. Before the daemon acts at a virtual instant, advance
+// is called with it so the caller can move its own clock and inject activity.
+// The controller is wrapped like the one of a running daemon.
+func Simulate(cfg Config, controller hw.HDDControl, start, end time.Time, advance func(now time.Time)) error {
+	if cfg.Cron == nil {
+		return fmt.Errorf("nil cron expression")
+	}
+	if cfg.PollInterval <= 0 {
+		return fmt.Errorf("invalid poll interval: %s", cfg.PollInterval)
+	}
+
+	now := start
+	wrapped, safety, verifier := wrapController(cfg, controller, noSysfs{})
+	d := newDaemon(cfg, wrapped)
+	d.safety = safety
+	d.verifier = verifier
+	d.now = func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.after = func(t time.Time) <-chan time.Time {
+		if t.After(end) {
+			cancel()
+			return nil
+		}
+		if t.After(now) {
+			now = t
+		}
+		advance(now)
+		fired := make(chan time.Time, 1)
+		fired <- now
+		return fired
+	}
+
+	devs := append([]string{}, cfg.Devices...)
+	if len(devs) == 0 {
+		disks, err := controller.List()
+		if err != nil {
+			return fmt.Errorf("failed to list devices: %w", err)
+		}
+		devs = hw.DiskPaths(disks)
+	}
+	sort.Strings(devs)
+
+	d.mainLoop(ctx, devs)
+	return nil
+}
@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// batchOp is an operation staggered over devices. It runs off the main loop,
+// where it may only issue commands through the controller, and returns the
+// bookkeeping of their outcome, run back on the main loop.
+type batchOp func(dev string) (done func())
+
+// staggering is a staggered operation running a batch or waiting for the
+// next one.
+type staggering struct {
+	name    string
+	batches [][]string
+	op      batchOp
+	// due is when the next batch starts, zero while a batch runs
+	due time.Time
+	// running counts the devices of the running batch not done yet
+	running int
+}
+
+// staggered runs op on each of devs, a batch of Config.Stagger.MaxConcurrent
+// devices at a time, the next batch starting Config.Stagger.Delay after the
+// previous one is done. The devices of a batch are handled concurrently, and
+// the main loop keeps polling and serving requests meanwhile.
+func (d *Daemon) staggered(name string, devs []string, op batchOp) {
+	batches := d.cfg.Stagger.Batches(devs)
+	if len(batches) == 0 {
+		return
+	}
+	s := &staggering{name: name, batches: batches, op: op}
+	d.staggerings = append(d.staggerings, s)
+	d.startBatch(s)
+}
+
+// startBatch runs the next batch of s.
+func (d *Daemon) startBatch(s *staggering) {
+	batch := s.batches[0]
+	s.batches = s.batches[1:]
+	s.due = time.Time{}
+	s.running = len(batch)
+	logrus.Debugf("%s: running batch %v", s.name, batch)
+	for _, dev := range batch {
+		d.async(func() func() {
+			done := s.op(dev)
+			return func() {
+				done()
+				d.batchDone(s)
+			}
+		})
+	}
+}
+
+// batchDone records a device of the running batch of s is done, and
+// schedules the next batch once all of them are.
+func (d *Daemon) batchDone(s *staggering) {
+	s.running--
+	if s.running > 0 {
+		return
+	}
+	if len(s.batches) == 0 {
+		d.staggerings = slices.DeleteFunc(d.staggerings, func(o *staggering) bool { return o == s })
+		return
+	}
+	s.due = d.now().Add(d.cfg.Stagger.Delay)
+}
+
+// nextBatch returns when the earliest waiting batch starts, false if there
+// is none.
+func (d *Daemon) nextBatch() (time.Time, bool) {
+	var next time.Time
+	for _, s := range d.staggerings {
+		if !s.due.IsZero() && (next.IsZero() || s.due.Before(next)) {
+			next = s.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// startBatches starts the batches due at now.
+func (d *Daemon) startBatches(now time.Time) {
+	for _, s := range slices.Clone(d.staggerings) {
+		if !s.due.IsZero() && !s.due.After(now) {
+			d.startBatch(s)
+		}
+	}
+}
+
+// runInline runs job and its bookkeeping right away, the main loop waiting
+// for it, as simulations and tests expect.
+func runInline(job func() (done func())) {
+	job()()
+}
+
+// runAsync runs job in its own goroutine and hands its bookkeeping over to
+// the main loop.
+func (d *Daemon) runAsync(job func() (done func())) {
+	go func() {
+		d.doneCh <- job()
+	}()
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_StaggeredSchedule(t *testing.T) {
+	var events []string
+	mockCtrl := hw.NewMockHDDControl(t)
+	for _, dev := range []string{"/dev/sda", "/dev/sdb", "/dev/sdc"} {
+		mockCtrl.EXPECT().SetStandbyTimeout(dev, 120).Run(func(dev string, _ int) {
+			events = append(events, dev)
+		}).Return(nil).Once()
+		mockCtrl.EXPECT().SetAPM(dev, 127).Run(func(dev string, _ int) {
+			events = append(events, "apm "+dev)
+		}).Return(nil).Once()
+	}
+
+	now := time.Date(2025, 1, 1, 22, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{StandbyValue: 120, Stagger: config.Stagger{Delay: 5 * time.Second, MaxConcurrent: 2}}, mockCtrl)
+	d.now = func() time.Time { return now }
+	d.last["/dev/sda"] = hw.DriveStateActive
+	d.last["/dev/sdb"] = hw.DriveStateActive
+	d.last["/dev/sdc"] = hw.DriveStateActive
+	d.last["/dev/sdd"] = hw.DriveStateStandby
+
+	// the second batches wait in the main loop
+	d.applySchedule()
+	d.applyAPMRule(127)
+	require.Equal(t, []string{"/dev/sda", "/dev/sdb", "apm /dev/sda", "apm /dev/sdb"}, events)
+	due, ok := d.nextBatch()
+	require.True(t, ok)
+	require.Equal(t, now.Add(5*time.Second), due)
+
+	d.startBatches(now.Add(time.Second))
+	require.Len(t, events, 4)
+	now = due
+	d.startBatches(now)
+	require.Equal(t, []string{"/dev/sda", "/dev/sdb", "apm /dev/sda", "apm /dev/sdb", "/dev/sdc", "apm /dev/sdc"}, events)
+	_, ok = d.nextBatch()
+	require.False(t, ok)
+	require.Empty(t, d.staggerings)
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sdc"])
+}
+
+func TestDaemon_StaggeredBatchConcurrent(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	started := make(chan string)
+	release := make(chan struct{})
+	for _, dev := range []string{"/dev/sda", "/dev/sdb"} {
+		mockCtrl.EXPECT().SetStandbyTimeout(dev, 120).Run(func(dev string, _ int) {
+			started <- dev
+			<-release
+		}).Return(nil).Once()
+	}
+
+	now := time.Date(2025, 1, 1, 22, 0, 0, 0, time.UTC)
+	d := newDaemon(Config{StandbyValue: 120, Stagger: config.Stagger{Delay: 5 * time.Second, MaxConcurrent: 2}}, mockCtrl)
+	d.async = d.runAsync
+	d.now = func() time.Time { return now }
+	d.last["/dev/sda"] = hw.DriveStateActive
+	d.last["/dev/sdb"] = hw.DriveStateActive
+	d.last["/dev/sdc"] = hw.DriveStateActive
+
+	// both commands of the batch run at once, without blocking the caller
+	d.applySchedule()
+	require.ElementsMatch(t, []string{"/dev/sda", "/dev/sdb"}, []string{<-started, <-started})
+	_, ok := d.nextBatch()
+	require.False(t, ok)
+
+	// the next batch waits for the bookkeeping of the whole batch
+	close(release)
+	(<-d.doneCh)()
+	_, ok = d.nextBatch()
+	require.False(t, ok)
+	(<-d.doneCh)()
+	due, ok := d.nextBatch()
+	require.True(t, ok)
+	require.Equal(t, now.Add(5*time.Second), due)
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sda"])
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sdb"])
+}
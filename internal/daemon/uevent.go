@@ -0,0 +1,35 @@
+package daemon
+
+import (
+	"bytes"
+	"path"
+)
+
+// parseUevent parses a kernel uevent, an "ACTION@DEVPATH" header followed by
+// NUL separated KEY=VALUE pairs, and returns the device node of a disk that
+// was added or changed, which happens when the kernel revalidates it after a
+// reset or on resume.
+func parseUevent(msg []byte) (string, bool) {
+	env := make(map[string]string)
+	for i, field := range bytes.Split(msg, []byte{0}) {
+		if i == 0 {
+			// header, or the "libudev" magic of udev rebroadcasts
+			if !bytes.Contains(field, []byte("@")) {
+				return "", false
+			}
+			continue
+		}
+		if k, v, ok := bytes.Cut(field, []byte("=")); ok {
+			env[string(k)] = string(v)
+		}
+	}
+	if env["SUBSYSTEM"] != "block" || env["DEVTYPE"] != "disk" || env["DEVNAME"] == "" {
+		return "", false
+	}
+	switch env["ACTION"] {
+	case "add", "change", "online":
+		return path.Join("/dev", env["DEVNAME"]), true
+	default:
+		return "", false
+	}
+}
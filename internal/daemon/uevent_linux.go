@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// watchUevents calls notify with the device node of every disk the kernel
+// adds or revalidates, until ctx is done.
+func watchUevents(ctx context.Context, notify func(dev string)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+	// kernel events only, udev rebroadcasts them on group 2
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		// nolint:errcheck
+		syscall.Close(fd)
+		return fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+	// a non blocking fd goes through the runtime poller, so Close unblocks Read
+	sock := os.NewFile(uintptr(fd), "uevent")
+	go func() {
+		<-ctx.Done()
+		// nolint:errcheck
+		sock.Close()
+	}()
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := sock.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read uevent: %w", err)
+		}
+		if dev, ok := parseUevent(buf[:n]); ok {
+			notify(dev)
+		}
+	}
+}
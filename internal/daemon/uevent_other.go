@@ -0,0 +1,13 @@
+//go:build !linux
+
+package daemon
+
+import (
+	"context"
+	"errors"
+)
+
+// watchUevents is only supported on Linux.
+func watchUevents(context.Context, func(string)) error {
+	return errors.New("uevents are only supported on linux")
+}
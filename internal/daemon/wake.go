@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/sirupsen/logrus"
+)
+
+// wakeReason names the holds of r.
+func wakeReason(r *config.WakeRule) string {
+	return fmt.Sprintf("wake rule %02d:%02d", r.At.Hour, r.At.Min)
+}
+
+// checkWakeDevices fails when a rule names a device not monitored: the daemon
+// would hold and wake it without ever polling it.
+func checkWakeDevices(rules []config.WakeRule, devs []string) error {
+	for _, r := range rules {
+		for _, dev := range r.Devices {
+			if !slices.Contains(devs, dev) {
+				return fmt.Errorf("wake rule at %s: device %s is not monitored", &r.At, dev)
+			}
+		}
+	}
+	return nil
+}
+
+// nextWakeRule returns the first rule spinning devices up after t and when,
+// or nil if there are no rules.
+func nextWakeRule(rules []config.WakeRule, t time.Time) (time.Time, *config.WakeRule) {
+	var next time.Time
+	var rule *config.WakeRule
+	for i := range rules {
+		at := rules[i].At.Next(t.Add(rules[i].Lead)).Add(-rules[i].Lead)
+		if rule == nil || at.Before(next) {
+			next, rule = at, &rules[i]
+		}
+	}
+	return next, rule
+}
+
+// applyWakeRule holds the devices of r until the end of its hold and spins
+// up the ones in standby, staggered.
+func (d *Daemon) applyWakeRule(r *config.WakeRule, devs []string) {
+	if len(r.Devices) > 0 {
+		devs = r.Devices
+	}
+	now := d.now()
+	until := now.Add(r.Lead + r.Hold)
+	var asleep []string
+	for _, dev := range devs {
+		d.hold(dev, wakeReason(r), until)
+		if state, ok := d.last[dev]; !ok || hw.IsSpunDown(state) {
+			asleep = append(asleep, dev)
+		}
+	}
+	logrus.Infof("%s: waking %v", wakeReason(r), asleep)
+	d.staggered(wakeReason(r), asleep, func(dev string) func() {
+		start := time.Now()
+		err := d.controller.Wake(dev)
+		took := time.Since(start)
+		return func() {
+			if err != nil {
+				commandFailed("wake", dev, err)
+				return
+			}
+			logrus.Infof("woke %s in %s", dev, took.Round(time.Millisecond))
+		}
+	})
+}
+
+// WakeStaggered spins devs up by batches, the devices of a batch at once,
+// pausing between batches. It returns the error of each device failing.
+func WakeStaggered(controller hw.HDDControl, stagger config.Stagger, devs []string, sleep func(time.Duration)) map[string]error {
+	errs := make(map[string]error)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for i, batch := range stagger.Batches(devs) {
+		if i > 0 && stagger.Delay > 0 {
+			sleep(stagger.Delay)
+		}
+		for _, dev := range batch {
+			wg.Go(func() {
+				start := time.Now()
+				if err := controller.Wake(dev); err != nil {
+					mu.Lock()
+					errs[dev] = err
+					mu.Unlock()
+					return
+				}
+				logrus.Infof("woke %s in %s", dev, time.Since(start).Round(time.Millisecond))
+			})
+		}
+		wg.Wait()
+	}
+	return errs
+}
@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextWakeRule(t *testing.T) {
+	rules := []config.WakeRule{
+		{At: config.CronExpr{Hour: 3, Min: 0}, Lead: 5 * time.Minute, Hold: time.Hour},
+		{At: config.CronExpr{Hour: 0, Min: 2}, Lead: 10 * time.Minute, Hold: time.Hour},
+	}
+	day := func(h, m int) time.Time { return time.Date(2025, 1, 1, h, m, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		now      time.Time
+		wantNext time.Time
+		wantRule int
+	}{
+		{name: "before lead", now: day(2, 0), wantNext: day(2, 55), wantRule: 0},
+		{name: "within lead", now: day(2, 56), wantNext: day(23, 52), wantRule: 1},
+		{name: "lead across midnight", now: day(23, 53), wantNext: day(26, 55), wantRule: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, rule := nextWakeRule(rules, tt.now)
+			require.Equal(t, tt.wantNext, next)
+			require.Same(t, &rules[tt.wantRule], rule)
+		})
+	}
+
+	_, rule := nextWakeRule(nil, day(0, 0))
+	require.Nil(t, rule)
+	require.Error(t, config.WakeRule{Hold: 0}.Validate())
+	require.Error(t, config.WakeRule{Lead: 24 * time.Hour, Hold: time.Hour}.Validate())
+	require.NoError(t, config.WakeRule{Lead: time.Minute, Hold: time.Hour}.Validate())
+}
+
+func TestCheckWakeDevices(t *testing.T) {
+	devs := []string{"/dev/sda", "/dev/sdb"}
+	tests := []struct {
+		name    string
+		rules   []config.WakeRule
+		wantErr string
+	}{
+		{name: "no rules"},
+		{name: "all devices", rules: []config.WakeRule{{At: config.CronExpr{Hour: 7}}}},
+		{name: "monitored", rules: []config.WakeRule{{At: config.CronExpr{Hour: 7}, Devices: []string{"/dev/sdb"}}}},
+		{
+			name:    "unknown",
+			rules:   []config.WakeRule{{At: config.CronExpr{Hour: 7}, Devices: []string{"/dev/sda", "/dev/sdc"}}},
+			wantErr: "wake rule at 7 0: device /dev/sdc is not monitored",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWakeDevices(tt.rules, devs)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestDaemon_Hold(t *testing.T) {
+	mockCtrl := hw.NewMockHDDControl(t)
+	d := newDaemon(Config{StandbyValue: 120}, mockCtrl)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.last["/dev/sda"] = hw.DriveStateActive
+	d.last["/dev/sdb"] = hw.DriveStateActive
+	d.applied["/dev/sda"] = settings{standby: 120}
+
+	// holding a spinning device disarms it, once
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+	d.hold("/dev/sda", "backup", now.Add(time.Hour))
+	d.hold("/dev/sda", "lease", time.Time{})
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(nil).Once()
+	d.hold("/dev/sdb", "backup", now.Add(30*time.Minute))
+	next, ok := d.nextRelease()
+	require.True(t, ok)
+	require.Equal(t, now.Add(30*time.Minute), next)
+
+	// the window skips held devices
+	d.applySchedule()
+	require.Equal(t, []Hold{{Reason: "backup", Until: now.Add(time.Hour)}, {Reason: "lease"}}, d.holdsOf("/dev/sda"))
+
+	// a window passed during the hold: armed once released
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Once()
+	d.releaseExpired(now.Add(30 * time.Minute))
+	require.False(t, d.held("/dev/sdb"))
+
+	// still held for the lease
+	d.releaseExpired(now.Add(time.Hour))
+	require.True(t, d.held("/dev/sda"))
+	_, ok = d.nextRelease()
+	require.False(t, ok)
+
+	mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	d.release("/dev/sda", "lease")
+	require.False(t, d.held("/dev/sda"))
+	require.Equal(t, settings{standby: 120}, d.applied["/dev/sda"])
+}
+
+func TestDaemon_mainLoop_WakeRule(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		mockCtrl := hw.NewMockHDDControl(t)
+		// the bubble starts at midnight UTC, whatever the local time zone
+		now := func() time.Time { return time.Now().In(time.UTC) }
+		start := now()
+		d := newDaemon(Config{
+			Devices:      []string{"/dev/sda", "/dev/sdb"},
+			PollInterval: time.Hour,
+			Cron:         &config.CronExpr{Hour: 22, Min: 0},
+			StandbyValue: 120,
+			Wake:         []config.WakeRule{{At: config.CronExpr{Hour: (start.Hour() + 3) % 24, Min: 0}, Lead: 5 * time.Minute, Hold: 30 * time.Minute}},
+		}, mockCtrl)
+		d.now = now
+		d.last["/dev/sda"] = hw.DriveStateActive
+		d.last["/dev/sdb"] = hw.DriveStateStandby
+		d.applied["/dev/sda"] = settings{standby: 120}
+		d.applied["/dev/sdb"] = settings{standby: 120}
+
+		mockCtrl.EXPECT().GetState("/dev/sda").Return(hw.DriveStateActive, nil).Times(3)
+		mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateStandby, nil).Twice()
+		// 02:55 the spinning device is held, the other one woken
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+		mockCtrl.EXPECT().Wake("/dev/sdb").Return(nil).Once()
+		// 03:00 the woken device is disarmed as it spun up
+		mockCtrl.EXPECT().GetState("/dev/sdb").Return(hw.DriveStateActive, nil).Once()
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 0).Return(nil).Once()
+		// 03:30 both get their policy back
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+		mockCtrl.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Once()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			d.mainLoop(ctx, []string{"/dev/sda", "/dev/sdb"})
+			close(done)
+		}()
+
+		// the state is read through the main loop, which owns it
+		status := func() Status {
+			reply := make(chan Status)
+			d.statusCh <- reply
+			return <-reply
+		}
+
+		time.Sleep(3*time.Hour - 4*time.Minute)
+		synctest.Wait()
+		st := status()
+		require.NotEmpty(t, st.Devices[0].Holds)
+		require.NotEmpty(t, st.Devices[1].Holds)
+
+		time.Sleep(40 * time.Minute)
+		synctest.Wait()
+		st = status()
+		require.Empty(t, st.Devices[0].Holds)
+		require.True(t, st.Devices[1].Armed)
+
+		cancel()
+		<-done
+	})
+}
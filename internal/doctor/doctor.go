@@ -0,0 +1,241 @@
+// Package doctor checks the host for the problems keeping hd-smart-idle from
+// managing its disks.
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/managers"
+)
+
+// Severity of a Finding.
+type Severity string
+
+const (
+	// OK is a passed check
+	OK Severity = "ok"
+	// Warn is a problem that degrades the daemon
+	Warn Severity = "warn"
+	// Fail is a problem that keeps the daemon from working
+	Fail Severity = "fail"
+)
+
+// Finding is the outcome of a check.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	// Hint tells how to fix a failed check
+	Hint string `json:"hint,omitempty"`
+}
+
+// capSysRawIO is the bit of CAP_SYS_RAWIO in the capability sets, required
+// to send ATA commands through SG_IO.
+const capSysRawIO = 17
+
+// Doctor runs the checks.
+type Doctor struct {
+	controller hw.HDDControl
+	fsys       fs.FS
+	euid       int
+	tools      []hw.Tool
+	version    func(hw.Tool) (string, error)
+	managers   func() []managers.Manager
+}
+
+// New returns a Doctor querying the disks through controller, which must
+// not wake them.
+func New(controller hw.HDDControl) *Doctor {
+	return &Doctor{
+		controller: controller,
+		fsys:       os.DirFS("/"),
+		euid:       os.Geteuid(),
+		tools:      hw.Tools(),
+		version:    hw.Tool.Version,
+		managers:   managers.Detect,
+	}
+}
+
+// Check runs every check on disks. EPC tells whether the EPC policy is
+// configured, which requires openSeaChest.
+func (d *Doctor) Check(disks []hw.Disk, epc bool) []Finding {
+	var findings []Finding
+	findings = append(findings, d.checkTools(disks, epc)...)
+	findings = append(findings, d.checkPrivileges())
+	findings = append(findings, d.checkDisks(disks)...)
+	findings = append(findings, d.checkManagers()...)
+	return findings
+}
+
+// Failed tells whether a finding is a failure.
+func Failed(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTools checks hdparm runs, and sdparm and openSeaChest when disks
+// need them.
+func (d *Doctor) checkTools(disks []hw.Disk, epc bool) []Finding {
+	scsi := false
+	for _, disk := range disks {
+		scsi = scsi || disk.Transport != hw.TransportATA || (disk.Quirk != nil && disk.Quirk.CommandSet == hw.CommandSetSCSI)
+	}
+	var findings []Finding
+	for _, tool := range d.tools {
+		var severity Severity
+		var needed string
+		switch {
+		case tool.Name == "hdparm":
+			severity, needed = Fail, "to query and set ATA disks"
+		case tool.Name == "sdparm" && scsi:
+			severity, needed = Fail, "to query and set the SCSI disks"
+		case tool.Name == "openSeaChest_PowerControl" && epc:
+			severity, needed = Warn, "to arm the EPC policy, disks fall back to the standby timer without it"
+		default:
+			continue
+		}
+		version, err := d.version(tool)
+		if err != nil {
+			findings = append(findings, Finding{
+				Check:    tool.Name,
+				Severity: severity,
+				Message:  fmt.Sprintf("cannot run %s: %v", tool.Path, err),
+				Hint:     fmt.Sprintf("install %s, needed %s, or point %s to it", tool.Name, needed, tool.Env),
+			})
+			continue
+		}
+		findings = append(findings, Finding{Check: tool.Name, Severity: OK, Message: fmt.Sprintf("%s (%s)", tool.Path, version)})
+	}
+	return findings
+}
+
+// checkPrivileges checks the process may send raw commands to the disks.
+func (d *Doctor) checkPrivileges() Finding {
+	f := Finding{Check: "privileges", Severity: OK}
+	if d.euid == 0 {
+		f.Message = "running as root"
+		return f
+	}
+	if caps, err := d.effectiveCaps(); err == nil && caps&(1<<capSysRawIO) != 0 {
+		f.Message = "CAP_SYS_RAWIO granted"
+		return f
+	}
+	f.Severity = Fail
+	f.Message = fmt.Sprintf("running as uid %d without CAP_SYS_RAWIO", d.euid)
+	f.Hint = "run as root, or grant CAP_SYS_RAWIO (AmbientCapabilities=CAP_SYS_RAWIO in the systemd unit) and read-write access to the disks"
+	return f
+}
+
+// effectiveCaps returns the effective capability set of the process.
+func (d *Doctor) effectiveCaps() (uint64, error) {
+	data, err := fs.ReadFile(d.fsys, "proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, errors.New("no CapEff in /proc/self/status")
+}
+
+// checkDisks checks the kernel exposes what the daemon reads of each disk
+// and that its power mode can be queried without waking it.
+func (d *Doctor) checkDisks(disks []hw.Disk) []Finding {
+	if len(disks) == 0 {
+		return []Finding{{
+			Check:    "disks",
+			Severity: Warn,
+			Message:  "no disk to manage",
+			Hint:     "check the output of `hd-smart-idle list` and the discovery filters, or pass --devices",
+		}}
+	}
+	var findings []Finding
+	for _, disk := range disks {
+		if f, ok := d.checkSysfs(disk); !ok {
+			findings = append(findings, f)
+		}
+		findings = append(findings, d.checkPowerMode(disk))
+	}
+	return findings
+}
+
+// checkSysfs checks the I/O statistics and rotational flag of disk exist,
+// it returns a finding and false otherwise.
+func (d *Doctor) checkSysfs(disk hw.Disk) (Finding, bool) {
+	dir := path.Join("sys/block", path.Base(disk.Path))
+	if _, err := fs.Stat(d.fsys, path.Join(dir, "stat")); err != nil {
+		return Finding{
+			Check:    disk.Path,
+			Severity: Fail,
+			Message:  "no I/O statistics in /" + dir,
+			Hint:     "pass a whole disk like /dev/sda, not a partition, and make sure /sys is mounted",
+		}, false
+	}
+	if _, err := fs.Stat(d.fsys, path.Join(dir, "queue/rotational")); err != nil {
+		return Finding{
+			Check:    disk.Path,
+			Severity: Warn,
+			Message:  "no rotational flag in /" + dir + "/queue, auto-discovery skips it",
+			Hint:     "list the disk with --devices or an include discovery filter",
+		}, false
+	}
+	return Finding{}, true
+}
+
+// checkPowerMode queries the power mode of disk with CHECK POWER MODE,
+// which answers without spinning it up.
+func (d *Doctor) checkPowerMode(disk hw.Disk) Finding {
+	f := Finding{Check: disk.Path, Severity: OK}
+	state, err := d.controller.GetState(disk.Path)
+	switch {
+	case errors.Is(err, hw.ErrStateUnavailable):
+		f.Severity = Warn
+		f.Message = "power mode not queried, the quirk of its USB bridge marks it unsafe; the daemon does not manage the disk"
+		f.Hint = "drop no_power_query and query_wakes from the quirk if the bridge answers CHECK POWER MODE without waking the disk"
+	case err != nil:
+		f.Severity = Fail
+		f.Message = fmt.Sprintf("cannot query the power mode: %v", err)
+		f.Hint = "check the device exists and the privileges above"
+		if disk.USBID != "" {
+			f.Hint = fmt.Sprintf("the USB bridge %s may not pass ATA commands through: add a quirk with command_set sat12 or scsi, or no_power_query (see USB bridge quirks in the README)", disk.USBID)
+		}
+	case state == hw.DriveStateUnknown:
+		f.Severity = Warn
+		f.Message = "the disk does not report its power mode, spin-downs cannot be observed"
+		f.Hint = "if it sits behind a USB bridge, try a quirk with command_set sat12"
+	default:
+		f.Message = "power mode " + state
+	}
+	return f
+}
+
+// checkManagers reports the other programs setting disk power management.
+func (d *Doctor) checkManagers() []Finding {
+	found := d.managers()
+	if len(found) == 0 {
+		return []Finding{{Check: "power managers", Severity: OK, Message: "no other power manager found"}}
+	}
+	var findings []Finding
+	for _, m := range found {
+		findings = append(findings, Finding{
+			Check:    "power managers",
+			Severity: Warn,
+			Message:  fmt.Sprintf("%s may reset the timers: %s", m.Name, m.Evidence),
+			Hint:     m.Hint,
+		})
+	}
+	return findings
+}
@@ -0,0 +1,139 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/chain710/hd-smart-idle/internal/managers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoctor_Check(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	tools := []hw.Tool{
+		{Name: "hdparm", Env: "HDPARM_PATH", Path: "/sbin/hdparm"},
+		{Name: "sdparm", Env: "SDPARM_PATH", Path: "/usr/bin/sdparm"},
+		{Name: "openSeaChest_PowerControl", Env: "SEACHEST_PATH", Path: "/usr/bin/openSeaChest_PowerControl"},
+	}
+	sda := hw.Disk{Path: "/dev/sda", Transport: hw.TransportATA}
+	sdb := hw.Disk{Path: "/dev/sdb", Transport: hw.TransportATA, USBID: "152d:0578"}
+	sdc := hw.Disk{Path: "/dev/sdc", Transport: hw.TransportSAS}
+	tests := []struct {
+		name      string
+		euid      int
+		disks     []hw.Disk
+		epc       bool
+		missing   []string
+		states    map[string]error
+		managers  []managers.Manager
+		expect    []string
+		expectErr bool
+	}{
+		{
+			name:   "healthy",
+			disks:  []hw.Disk{sda},
+			states: map[string]error{"/dev/sda": nil},
+			expect: []string{
+				"ok hdparm: /sbin/hdparm (v1)",
+				"ok privileges: running as root",
+				"ok /dev/sda: power mode standby",
+				"ok power managers: no other power manager found",
+			},
+		},
+		{
+			name:    "missing tools",
+			disks:   []hw.Disk{sda, sdc},
+			epc:     true,
+			missing: []string{"hdparm", "openSeaChest_PowerControl"},
+			states:  map[string]error{"/dev/sda": nil, "/dev/sdc": nil},
+			expect: []string{
+				"fail hdparm: cannot run /sbin/hdparm: not found",
+				"ok sdparm: /usr/bin/sdparm (v1)",
+				"warn openSeaChest_PowerControl: cannot run /usr/bin/openSeaChest_PowerControl: not found",
+				"ok privileges: running as root",
+				"ok /dev/sda: power mode standby",
+				"ok /dev/sdc: power mode standby",
+				"ok power managers: no other power manager found",
+			},
+			expectErr: true,
+		},
+		{
+			name:  "unprivileged with failing bridge and competitor",
+			euid:  1000,
+			disks: []hw.Disk{sda, sdb, {Path: "/dev/sdd", Transport: hw.TransportATA}},
+			states: map[string]error{
+				"/dev/sda": hw.ErrStateUnavailable,
+				"/dev/sdb": errors.New("SG_IO: bad/missing sense data"),
+				"/dev/sdd": nil,
+			},
+			managers: []managers.Manager{{Name: "hd-idle", Evidence: "hd-idle is running", Hint: "stop it"}},
+			expect: []string{
+				"ok hdparm: /sbin/hdparm (v1)",
+				"fail privileges: running as uid 1000 without CAP_SYS_RAWIO",
+				"warn /dev/sda: power mode not queried, the quirk of its USB bridge marks it unsafe; the daemon does not manage the disk",
+				"fail /dev/sdb: cannot query the power mode: SG_IO: bad/missing sense data",
+				"fail /dev/sdd: no I/O statistics in /sys/block/sdd",
+				"ok /dev/sdd: power mode standby",
+				"warn power managers: hd-idle may reset the timers: hd-idle is running",
+			},
+			expectErr: true,
+		},
+		{
+			name:   "no disk",
+			euid:   1000,
+			expect: []string{"ok hdparm: /sbin/hdparm (v1)", "ok privileges: CAP_SYS_RAWIO granted", "warn disks: no disk to manage", "ok power managers: no other power manager found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := hw.NewMockHDDControl(t)
+			for dev, err := range tt.states {
+				state := hw.DriveStateStandby
+				if err != nil {
+					state = ""
+				}
+				mockCtrl.EXPECT().GetState(dev).Return(state, err).Once()
+			}
+			capEff := "0000000000000000"
+			if len(tt.disks) == 0 {
+				capEff = "0000000000020000"
+			}
+			d := &Doctor{
+				controller: mockCtrl,
+				fsys: fstest.MapFS{
+					"proc/self/status":               file("Name:\thd-smart-idle\nCapEff:\t" + capEff + "\n"),
+					"sys/block/sda/stat":             file("1 0 0 0 1 0 0 0 0 0 0\n"),
+					"sys/block/sda/queue/rotational": file("1\n"),
+					"sys/block/sdb/stat":             file("1 0 0 0 1 0 0 0 0 0 0\n"),
+					"sys/block/sdb/queue/rotational": file("1\n"),
+					"sys/block/sdc/stat":             file("1 0 0 0 1 0 0 0 0 0 0\n"),
+					"sys/block/sdc/queue/rotational": file("1\n"),
+				},
+				euid:  tt.euid,
+				tools: tools,
+				version: func(tool hw.Tool) (string, error) {
+					for _, name := range tt.missing {
+						if tool.Name == name {
+							return "", errors.New("not found")
+						}
+					}
+					return "v1", nil
+				},
+				managers: func() []managers.Manager { return tt.managers },
+			}
+			findings := d.Check(tt.disks, tt.epc)
+			var got []string
+			for _, f := range findings {
+				got = append(got, fmt.Sprintf("%s %s: %s", f.Severity, f.Check, f.Message))
+				if f.Severity != OK {
+					require.NotEmpty(t, f.Hint, f.Message)
+				}
+			}
+			require.Equal(t, tt.expect, got)
+			require.Equal(t, tt.expectErr, Failed(findings))
+		})
+	}
+}
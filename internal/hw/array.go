@@ -0,0 +1,216 @@
+package hw
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Array kinds.
+const (
+	// ArrayMD is a Linux software RAID.
+	ArrayMD = "md"
+	// ArrayZFS is a ZFS pool.
+	ArrayZFS = "zfs"
+	// ArrayBtrfs is a multi-device btrfs filesystem.
+	ArrayBtrfs = "btrfs"
+)
+
+// Array is a set of disks holding a single volume: I/O on the volume wakes
+// all of them at once.
+type Array struct {
+	Kind string
+	Name string
+	// Members are the whole disks backing the array, e.g. /dev/sda, sorted
+	Members []string
+}
+
+func (a Array) String() string {
+	return a.Kind + ":" + a.Name
+}
+
+// DiscoverArrays returns the md RAIDs, ZFS pools and btrfs filesystems of
+// the system with their member disks. Partitions are mapped to the disk
+// holding them. A missing zpool binary means no ZFS pool.
+func DiscoverArrays() ([]Array, error) {
+	return discoverArrays(os.DirFS("/"), zpoolStatus)
+}
+
+func discoverArrays(fsys fs.FS, zpool func() ([]byte, error)) ([]Array, error) {
+	arrays := mdArrays(fsys)
+	arrays = append(arrays, btrfsArrays(fsys)...)
+
+	out, err := zpool()
+	for _, pool := range parseZpoolStatus(out) {
+		arrays = append(arrays, memberDisks(fsys, pool))
+	}
+	sort.Slice(arrays, func(i, j int) bool { return arrays[i].String() < arrays[j].String() })
+	if err != nil {
+		return arrays, fmt.Errorf("zpool status: %w", err)
+	}
+	return arrays, nil
+}
+
+// mdArrays reads md RAID members from the slaves of each md device in sysfs
+// and from /proc/mdstat, which also lists arrays being assembled.
+func mdArrays(fsys fs.FS) []Array {
+	members := make(map[string][]string)
+	if entries, err := fs.ReadDir(fsys, "sys/block"); err == nil {
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), "md") {
+				continue
+			}
+			slaves, err := fs.ReadDir(fsys, path.Join("sys/block", e.Name(), "slaves"))
+			if err != nil {
+				continue
+			}
+			for _, s := range slaves {
+				members[e.Name()] = append(members[e.Name()], s.Name())
+			}
+		}
+	}
+	if mdstat, err := fs.ReadFile(fsys, "proc/mdstat"); err == nil {
+		for name, devs := range parseMdstat(mdstat) {
+			members[name] = append(members[name], devs...)
+		}
+	}
+
+	var arrays []Array
+	for name, devs := range members {
+		arrays = append(arrays, memberDisks(fsys, Array{Kind: ArrayMD, Name: name, Members: devs}))
+	}
+	return arrays
+}
+
+// parseMdstat returns the member devices of each array in /proc/mdstat:
+//
+//	md0 : active raid1 sdb1[1] sda1[0](F)
+func parseMdstat(data []byte) map[string][]string {
+	arrays := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "md") || fields[1] != ":" {
+			continue
+		}
+		for _, f := range fields[2:] {
+			if i := strings.IndexByte(f, '['); i > 0 {
+				arrays[fields[0]] = append(arrays[fields[0]], f[:i])
+			}
+		}
+	}
+	return arrays
+}
+
+// btrfsArrays reads the devices of each mounted btrfs filesystem in sysfs,
+// named after its label or its UUID.
+func btrfsArrays(fsys fs.FS) []Array {
+	entries, err := fs.ReadDir(fsys, "sys/fs/btrfs")
+	if err != nil {
+		return nil
+	}
+	var arrays []Array
+	for _, e := range entries {
+		devs, err := fs.ReadDir(fsys, path.Join("sys/fs/btrfs", e.Name(), "devices"))
+		if err != nil {
+			// features and other non filesystem entries
+			continue
+		}
+		a := Array{Kind: ArrayBtrfs, Name: e.Name()}
+		if label, err := fs.ReadFile(fsys, path.Join("sys/fs/btrfs", e.Name(), "label")); err == nil && strings.TrimSpace(string(label)) != "" {
+			a.Name = strings.TrimSpace(string(label))
+		}
+		for _, dev := range devs {
+			a.Members = append(a.Members, dev.Name())
+		}
+		arrays = append(arrays, memberDisks(fsys, a))
+	}
+	return arrays
+}
+
+// parseZpoolStatus returns the vdev paths of each pool in the output of
+// `zpool status -P -L`.
+func parseZpoolStatus(data []byte) []Array {
+	var pools []Array
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "pool:":
+			pools = append(pools, Array{Kind: ArrayZFS, Name: fields[1]})
+		case len(fields) > 0 && len(pools) > 0 && strings.HasPrefix(fields[0], "/dev/"):
+			pool := &pools[len(pools)-1]
+			pool.Members = append(pool.Members, path.Base(fields[0]))
+		}
+	}
+	return pools
+}
+
+// memberDisks maps the kernel names of the members of a, whole disks or
+// partitions, to the paths of their disks, dropping duplicates and devices
+// that are not disks such as nested md or dm devices.
+func memberDisks(fsys fs.FS, a Array) Array {
+	seen := make(map[string]bool)
+	disks := make([]string, 0, len(a.Members))
+	for _, name := range a.Members {
+		disk := parentDisk(fsys, name)
+		if disk == "" || seen[disk] {
+			continue
+		}
+		seen[disk] = true
+		disks = append(disks, "/dev/"+disk)
+	}
+	sort.Strings(disks)
+	a.Members = disks
+	return a
+}
+
+// parentDisk returns the disk holding the block device name, itself when it
+// is a whole disk, or "" when it is not a rotational candidate.
+func parentDisk(fsys fs.FS, name string) string {
+	if strings.HasPrefix(name, "md") || strings.HasPrefix(name, "dm-") {
+		return ""
+	}
+	if _, err := fs.Stat(fsys, path.Join("sys/block", name)); err == nil {
+		return name
+	}
+	entries, err := fs.ReadDir(fsys, "sys/block")
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if _, err := fs.Stat(fsys, path.Join("sys/block", e.Name(), name, "partition")); err == nil {
+			return e.Name()
+		}
+	}
+	return ""
+}
+
+// zpoolStatus runs `zpool status` with full, resolved vdev paths.
+func zpoolStatus() ([]byte, error) {
+	out, err := exec.Command(zpoolPath(), "status", "-P", "-L").CombinedOutput()
+	if errors.Is(err, fs.ErrNotExist) {
+		// ZFS not installed
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// zpoolPath returns the path to the zpool binary. It checks the ZPOOL_PATH
+// environment variable and falls back to /usr/sbin/zpool when not set.
+func zpoolPath() string {
+	if p, ok := os.LookupEnv("ZPOOL_PATH"); ok && p != "" {
+		return p
+	}
+	return "/usr/sbin/zpool"
+}
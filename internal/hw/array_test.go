@@ -0,0 +1,73 @@
+package hw
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+const zpoolStatusOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub repaired 0B in 01:02:03 with 0 errors on Sun Jan 12 01:26:04 2025
+config:
+
+	NAME           STATE     READ WRITE CKSUM
+	tank           ONLINE       0     0     0
+	  mirror-0     ONLINE       0     0     0
+	    /dev/sdc1  ONLINE       0     0     0
+	    /dev/sdd1  ONLINE       0     0     0
+	cache
+	  /dev/nvme0n1p2  ONLINE    0     0     0
+
+errors: No known data errors
+`
+
+func TestDiscoverArrays(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sys/block/sda/queue/rotational":        &fstest.MapFile{Data: []byte("1")},
+		"sys/block/sda/sda1/partition":          &fstest.MapFile{Data: []byte("1")},
+		"sys/block/sdb/sdb1/partition":          &fstest.MapFile{Data: []byte("1")},
+		"sys/block/sdc/sdc1/partition":          &fstest.MapFile{Data: []byte("1")},
+		"sys/block/sdd/sdd1/partition":          &fstest.MapFile{Data: []byte("1")},
+		"sys/block/sde/queue/rotational":        &fstest.MapFile{Data: []byte("1")},
+		"sys/block/sdf/queue/rotational":        &fstest.MapFile{Data: []byte("1")},
+		"sys/block/nvme0n1/nvme0n1p2/partition": &fstest.MapFile{Data: []byte("2")},
+		"sys/block/md0/slaves/sda1":             &fstest.MapFile{},
+		"sys/block/md0/slaves/sdb1":             &fstest.MapFile{},
+		// assembling, only in mdstat
+		"proc/mdstat": &fstest.MapFile{Data: []byte(`Personalities : [raid1]
+md0 : active raid1 sdb1[1] sda1[0]
+      976630464 blocks super 1.2 [2/2] [UU]
+
+md1 : inactive sde[0](S) md0[1]
+unused devices: <none>
+`)},
+		"sys/fs/btrfs/features/raid1c34":                                &fstest.MapFile{},
+		"sys/fs/btrfs/0c1b4e06-0ab0-4b8e-a4a6-6d5c2e3e7f01/label":       &fstest.MapFile{Data: []byte("media\n")},
+		"sys/fs/btrfs/0c1b4e06-0ab0-4b8e-a4a6-6d5c2e3e7f01/devices/sde": &fstest.MapFile{},
+		"sys/fs/btrfs/0c1b4e06-0ab0-4b8e-a4a6-6d5c2e3e7f01/devices/sdf": &fstest.MapFile{},
+	}
+
+	arrays, err := discoverArrays(fsys, func() ([]byte, error) { return []byte(zpoolStatusOutput), nil })
+	require.NoError(t, err)
+	require.Equal(t, []Array{
+		{Kind: ArrayBtrfs, Name: "media", Members: []string{"/dev/sde", "/dev/sdf"}},
+		{Kind: ArrayMD, Name: "md0", Members: []string{"/dev/sda", "/dev/sdb"}},
+		{Kind: ArrayMD, Name: "md1", Members: []string{"/dev/sde"}},
+		{Kind: ArrayZFS, Name: "tank", Members: []string{"/dev/nvme0n1", "/dev/sdc", "/dev/sdd"}},
+	}, arrays)
+
+	// a failing zpool keeps the other arrays
+	arrays, err = discoverArrays(fsys, func() ([]byte, error) { return nil, errors.New("no ZFS module") })
+	require.Error(t, err)
+	require.Len(t, arrays, 3)
+}
+
+func TestParseZpoolStatus(t *testing.T) {
+	require.Empty(t, parseZpoolStatus([]byte("no pools available\n")))
+	require.Equal(t, []Array{
+		{Kind: ArrayZFS, Name: "tank", Members: []string{"sdc1", "sdd1", "nvme0n1p2"}},
+	}, parseZpoolStatus([]byte(zpoolStatusOutput)))
+}
@@ -0,0 +1,198 @@
+package hw
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEPCSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		cmdErr         error
+		expect         EPCTimers
+		expectErrorIs  error
+		expectErrorMsg string
+	}{
+		{
+			name: "enabled timers",
+			output: `/dev/sg2 - ST4000NM0035-1V4107 - ZC1234 - TN03 - ATA
+
+===EPC Settings===
+	* = timer is enabled
+	C column = Changeable
+	S column = Savable
+	All times are in 100 milliseconds
+
+Name       Current Timer Default Timer Saved Timer   Recovery Time C S
+Idle A    *20            *20           *20           1             Y Y
+Idle B    *1200          *6000         *6000         6             Y Y
+Idle C     6000           6000          6000         10            Y Y
+Standby Z *18000          9000          9000         80            Y Y
+`,
+			expect: EPCTimers{IdleA: 2 * time.Second, IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute},
+		},
+		{
+			name: "all disabled",
+			output: `Name       Current Timer Default Timer Saved Timer   Recovery Time C S
+Idle A     20             20            20           1             Y Y
+Standby Z  9000           9000          9000         80            Y Y
+`,
+			expect: EPCTimers{},
+		},
+		{
+			name:          "not supported",
+			output:        "/dev/sda - WDC WD40EFRX - ATA\nEPC Feature is not supported on this device.\n",
+			cmdErr:        errors.New("exit status 3"),
+			expectErrorIs: ErrEPCUnsupported,
+		},
+		{
+			name: "other condition not supported",
+			output: `Name       Current Timer Default Timer Saved Timer   Recovery Time C S
+Idle B    *1200          *6000         *6000         6             Y Y
+Note: Standby Y is not supported on this device.
+`,
+			expect: EPCTimers{IdleB: 2 * time.Minute},
+		},
+		{
+			name:           "command error",
+			output:         "/dev/sda - WDC WD40EFRX - ATA\nPass-through command not supported by the bridge.\n",
+			cmdErr:         errors.New("exit status 1"),
+			expectErrorMsg: "openSeaChest command error",
+		},
+		{
+			name:          "device not found",
+			output:        "Error opening /dev/sdz: No such file or directory\n",
+			cmdErr:        errors.New("exit status 2"),
+			expectErrorIs: os.ErrNotExist,
+		},
+		{
+			name:           "no table",
+			output:         "garbage\n",
+			expectErrorMsg: "malformed openSeaChest output",
+		},
+		{
+			name:           "bad timer",
+			output:         "Idle B    *abc  *6000  *6000  6  Y Y\n",
+			expectErrorMsg: "malformed idle_b timer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := defaultHDDControl{}.parseEPCSettings(tt.output, tt.cmdErr)
+			switch {
+			case tt.expectErrorIs != nil:
+				require.ErrorIs(t, err, tt.expectErrorIs)
+			case tt.expectErrorMsg != "":
+				require.ErrorContains(t, err, tt.expectErrorMsg)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestDefaultHDDControl_GetEPC(t *testing.T) {
+	fakeCommand(t, "SEACHEST_PATH", `Name       Current Timer Default Timer Saved Timer   Recovery Time C S
+Idle B    *1200          *6000         *6000         6             Y Y
+Standby Z *18000          9000          9000         80            Y Y
+`)
+	for _, tt := range []struct {
+		name    string
+		feature string
+		expect  EPCTimers
+	}{
+		{name: "EPC enabled", feature: "\t   *\tExtended Power Conditions feature set\n", expect: EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}},
+		{name: "EPC disabled keeps its timers", feature: "\t    \tExtended Power Conditions feature set\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCommand(t, "HDPARM_PATH", "/dev/sda:\n\tModel Number:       ST4000NM0035\nCommands/features:\n\tEnabled\tSupported:\n"+tt.feature)
+			got, err := defaultHDDControl{}.GetEPC("/dev/sda")
+			require.NoError(t, err)
+			require.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestEPCArgs(t *testing.T) {
+	timers := EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}
+
+	require.Equal(t, []string{"--EPCfeature", "disable"}, defaultHDDControl{}.epcArgs(EPCTimers{}))
+	require.Equal(t, []string{
+		"--EPCfeature", "enable",
+		"--idle_a", "disable",
+		"--idle_b", "120000",
+		"--idle_c", "disable",
+		"--standby_y", "disable",
+		"--standby_z", "1800000",
+	}, defaultHDDControl{}.epcArgs(timers))
+
+	require.Equal(t, []string{"--set=IDLE=0,IDLE_B=1,IBCT=1200,IDLE_C=0,STANDBY_Y=0,STANDBY=1,SCT=18000"}, scsiHDDControl{}.epcArgs(timers))
+	require.Equal(t, []string{"--set=IDLE=0,IDLE_B=0,IDLE_C=0,STANDBY_Y=0,STANDBY=0"}, scsiHDDControl{}.epcArgs(EPCTimers{}))
+}
+
+func TestParseModePage(t *testing.T) {
+	values, err := scsiHDDControl{}.parseModePage(`IDLE        0  [cha: y, def:  0, sav:  0]
+IACT       20  [cha: y, def: 20, sav: 20]
+IDLE_B      1  [cha: y, def:  1, sav:  1]
+IBCT     1200  [cha: y, def:6000, sav:6000]
+IDLE_C      0  [cha: y, def:  0, sav:  0]
+ICCT     6000  [cha: y, def:6000, sav:6000]
+STANDBY_Y   0  [cha: y, def:  0, sav:  0]
+SYCT     9000  [cha: y, def:9000, sav:9000]
+STANDBY     1  [cha: y, def:  0, sav:  0]
+SCT     18000  [cha: y, def:9000, sav:9000]
+`, nil)
+	require.NoError(t, err)
+	got, err := modePageTimers(values)
+	require.NoError(t, err)
+	require.Equal(t, EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}, got)
+
+	// legacy power condition page: idle and standby only
+	values, err = scsiHDDControl{}.parseModePage(`IDLE        0  [cha: y, def:  0, sav:  0]
+IACT       20  [cha: y, def: 20, sav: 20]
+STANDBY     1  [cha: y, def:  0, sav:  0]
+SCT     18000  [cha: y, def:9000, sav:9000]
+`, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint64{"IDLE": 0, "IACT": 20, "STANDBY": 1, "SCT": 18000}, values)
+	_, err = modePageTimers(values)
+	require.ErrorIs(t, err, ErrEPCUnsupported)
+}
+
+func TestParseEPCTimers(t *testing.T) {
+	tests := []struct {
+		input          string
+		expect         EPCTimers
+		expectErrorMsg string
+	}{
+		{input: "idle_b=2m,standby_z=30m", expect: EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}},
+		{input: " IDLE_A=100ms , standby_y=1h", expect: EPCTimers{IdleA: 100 * time.Millisecond, StandbyY: time.Hour}},
+		{input: "idle_b", expectErrorMsg: "expected <condition>=<duration>"},
+		{input: "idle_d=1m", expectErrorMsg: "unknown power condition"},
+		{input: "idle_b=soon", expectErrorMsg: "invalid idle_b timer"},
+		{input: "idle_b=150ms", expectErrorMsg: "multiple of 100ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseEPCTimers(tt.input)
+			if tt.expectErrorMsg != "" {
+				require.ErrorContains(t, err, tt.expectErrorMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expect, got)
+			require.True(t, got.Enabled())
+		})
+	}
+
+	require.Equal(t, "disabled", EPCTimers{}.String())
+	require.Equal(t, "idle_b=2m0s,standby_z=30m0s", EPCTimers{IdleB: 2 * time.Minute, StandbyZ: 30 * time.Minute}.String())
+}
@@ -0,0 +1,316 @@
+package hw
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Buses matched by Filter.Transport besides the Transport constants.
+const (
+	// TransportUSB is a disk behind a USB bridge, whatever the protocol the
+	// bridge speaks.
+	TransportUSB = "usb"
+	// TransportNVMe is an NVMe drive.
+	TransportNVMe = "nvme"
+)
+
+// Filter matches disks in auto-discovery. Every condition set must match.
+type Filter struct {
+	// Name is a shell pattern on the kernel name, e.g. "sd[a-c]"
+	Name string `yaml:"name"`
+	// ID is a shell pattern on the /dev/disk/by-id names, e.g. "ata-WDC_WD40*"
+	ID string `yaml:"id"`
+	// Model is a regular expression on the model reported by the disk
+	Model string `yaml:"model"`
+	// Transport is usb, ata (or sata), sas, scsi or nvme
+	Transport string `yaml:"transport"`
+	// MinSize and MaxSize bound the capacity, e.g. "500G" or "4TiB"
+	MinSize string `yaml:"min_size"`
+	MaxSize string `yaml:"max_size"`
+	// Root matches the disks storing the root filesystem
+	Root bool `yaml:"root"`
+}
+
+// Filters select the disks found by auto-discovery: rotational disks, plus
+// the included ones, minus the excluded ones.
+type Filters struct {
+	// Include adds disks, e.g. one misreporting itself as non-rotational
+	Include []Filter `yaml:"include"`
+	// Exclude removes disks, e.g. the boot disk. It wins over Include.
+	Exclude []Filter `yaml:"exclude"`
+}
+
+// Validate checks every filter.
+func (f Filters) Validate() error {
+	for _, filter := range append(slices.Clone(f.Include), f.Exclude...) {
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the filter sets a condition and each is well formed.
+func (f Filter) Validate() error {
+	if f == (Filter{}) {
+		return fmt.Errorf("empty disk filter")
+	}
+	for _, pattern := range []string{f.Name, f.ID} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid disk filter pattern %q: %w", pattern, err)
+		}
+	}
+	if _, err := regexp.Compile(f.Model); err != nil {
+		return fmt.Errorf("invalid disk filter model: %w", err)
+	}
+	switch f.Transport {
+	case "", TransportUSB, TransportATA, "sata", TransportSAS, TransportSCSI, TransportNVMe:
+	default:
+		return fmt.Errorf("invalid disk filter transport %q: expected usb|ata|sata|sas|scsi|nvme", f.Transport)
+	}
+	for _, size := range []string{f.MinSize, f.MaxSize} {
+		if _, err := ParseSize(size); size != "" && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f Filter) String() string {
+	var conds []string
+	add := func(key, op, value string) {
+		if value != "" {
+			conds = append(conds, key+op+value)
+		}
+	}
+	add("name", "=", f.Name)
+	add("id", "=", f.ID)
+	add("model", "=~", f.Model)
+	add("transport", "=", f.Transport)
+	add("size", ">=", f.MinSize)
+	add("size", "<=", f.MaxSize)
+	if f.Root {
+		conds = append(conds, "root")
+	}
+	return strings.Join(conds, " ")
+}
+
+// match tells whether c meets every condition of f.
+func (f Filter) match(c Candidate) bool {
+	if ok, _ := path.Match(f.Name, c.Name); f.Name != "" && !ok {
+		return false
+	}
+	if f.ID != "" && !slices.ContainsFunc(c.IDs, func(id string) bool {
+		ok, _ := path.Match(f.ID, id)
+		return ok
+	}) {
+		return false
+	}
+	if ok, _ := regexp.MatchString(f.Model, c.Model); f.Model != "" && !ok {
+		return false
+	}
+	if transport := f.Transport; transport != "" {
+		if transport == "sata" {
+			transport = TransportATA
+		}
+		if transport != c.Bus() {
+			return false
+		}
+	}
+	if lo, _ := ParseSize(f.MinSize); c.Size < lo {
+		return false
+	}
+	if hi, _ := ParseSize(f.MaxSize); f.MaxSize != "" && c.Size > hi {
+		return false
+	}
+	return !f.Root || c.Root
+}
+
+// Candidate is a disk found by Discover and whether it is selected.
+type Candidate struct {
+	Disk
+	// Name is the kernel name, e.g. sda
+	Name  string `json:"name"`
+	Model string `json:"model,omitempty"`
+	// Serial is the unit serial number cached by the kernel, if any
+	Serial string `json:"serial,omitempty"`
+	// IDs are the /dev/disk/by-id names of the disk
+	IDs []string `json:"ids,omitempty"`
+	// Size is the capacity in bytes
+	Size       uint64 `json:"size"`
+	Rotational bool   `json:"rotational"`
+	// Root tells the disk stores the root filesystem
+	Root     bool `json:"root"`
+	Selected bool `json:"selected"`
+	// Reason tells why the disk is selected or not
+	Reason string `json:"reason"`
+}
+
+// Bus is the attachment of the disk matched by Filter.Transport: usb, nvme
+// or its Transport.
+func (c Candidate) Bus() string {
+	switch {
+	case c.USBID != "":
+		return TransportUSB
+	case strings.HasPrefix(c.Name, "nvme"):
+		return TransportNVMe
+	default:
+		return c.Transport
+	}
+}
+
+// Discover lists every disk of the host, virtual devices aside, with
+// whether filters select it for the daemon and why. The given quirks take
+// precedence over DefaultQuirks.
+func Discover(filters Filters, quirks ...Quirk) ([]Candidate, error) {
+	return discover(os.DirFS("/"), filters, append(slices.Clone(quirks), DefaultQuirks...))
+}
+
+// Selected returns the disks of the selected candidates.
+func Selected(candidates []Candidate) []Disk {
+	var disks []Disk
+	for _, c := range candidates {
+		if c.Selected {
+			disks = append(disks, c.Disk)
+		}
+	}
+	return disks
+}
+
+func discover(fsys fs.FS, filters Filters, quirks []Quirk) ([]Candidate, error) {
+	entries, err := fs.ReadDir(fsys, "sys/block")
+	if err != nil {
+		return nil, err
+	}
+	ids := byIDNames(fsys)
+	var candidates []Candidate
+	for _, e := range entries {
+		name := e.Name()
+		if isVirtual(name) {
+			continue
+		}
+		dev := path.Join("/dev", name)
+		c := Candidate{
+			Disk:       Disk{Path: dev},
+			Name:       name,
+			Model:      readTrimmed(fsys, path.Join("sys/block", name, "device/model")),
+			Serial:     vpdSerial(fsys, name),
+			IDs:        ids[name],
+			Rotational: rotational(fsys, name),
+			Root:       slices.Contains(MountPoints(fsys, dev), "/"),
+		}
+		if sectors, err := strconv.ParseUint(readTrimmed(fsys, path.Join("sys/block", name, "size")), 10, 64); err == nil {
+			c.Size = sectors * 512
+		}
+		c.Transport = DetectTransport(fsys, dev)
+		c.USBID = USBID(fsys, dev)
+		c.Quirk = lookupQuirk(fsys, quirks, dev)
+		c.Selected, c.Reason = filters.selects(fsys, c)
+		candidates = append(candidates, c)
+	}
+	return candidates, nil
+}
+
+// selects tells whether c is managed and why.
+func (f Filters) selects(fsys fs.FS, c Candidate) (bool, string) {
+	if _, err := fs.Stat(fsys, strings.TrimPrefix(c.Path, "/")); err != nil {
+		return false, "no device node"
+	}
+	for _, filter := range f.Exclude {
+		if filter.match(c) {
+			return false, "excluded by " + filter.String()
+		}
+	}
+	for _, filter := range f.Include {
+		if filter.match(c) {
+			return true, "included by " + filter.String()
+		}
+	}
+	if !c.Rotational {
+		return false, "not rotational"
+	}
+	return true, "rotational"
+}
+
+// isVirtual tells whether the kernel name is a virtual or stacked device,
+// never a candidate.
+func isVirtual(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "zram", "dm-", "md", "nbd", "sr"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// byIDNames returns the /dev/disk/by-id names of each disk, partitions aside.
+func byIDNames(fsys fs.FS) map[string][]string {
+	ids := make(map[string][]string)
+	entries, err := fs.ReadDir(fsys, "dev/disk/by-id")
+	if err != nil {
+		return ids
+	}
+	for _, e := range entries {
+		target, err := fs.ReadLink(fsys, path.Join("dev/disk/by-id", e.Name()))
+		if err != nil {
+			continue
+		}
+		name := path.Base(target)
+		ids[name] = append(ids[name], e.Name())
+	}
+	return ids
+}
+
+func readTrimmed(fsys fs.FS, name string) string {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// sizeUnits are the multipliers of the size suffixes, decimal and binary.
+var sizeUnits = map[string]uint64{
+	"": 1, "B": 1,
+	"K": 1e3, "M": 1e6, "G": 1e9, "T": 1e12, "P": 1e15,
+	"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12, "PB": 1e15,
+	"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40, "PIB": 1 << 50,
+}
+
+// ParseSize parses a capacity like "500G", "1.5TB" or "4TiB" in bytes. An
+// empty string is zero.
+func ParseSize(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected e.g. 500G, 1.5TB or 4TiB", s)
+	}
+	return uint64(n * float64(unit)), nil
+}
+
+// FormatSize formats a capacity in bytes with a decimal unit, e.g. 4.0T.
+func FormatSize(size uint64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	v := float64(size)
+	i := 0
+	for ; v >= 1000 && i < len(units)-1; i++ {
+		v /= 1000
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", size, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
@@ -0,0 +1,190 @@
+package hw
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a boot SSD, two SATA drives, one misreporting as non-rotational, a SAS
+// drive, a USB enclosure and a loop device.
+func discoveryFS() fstest.MapFS {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	fsys := fstest.MapFS{
+		"sys/block/nvme0n1/queue/rotational":                     file("0\n"),
+		"sys/block/nvme0n1/size":                                 file("1000215216\n"),
+		"sys/block/nvme0n1/device/model":                         file("Samsung SSD 980 PRO 512GB\n"),
+		"sys/block/nvme0n1/dev":                                  file("259:0\n"),
+		"sys/block/nvme0n1/nvme0n1p2/dev":                        file("259:2\n"),
+		"sys/block/nvme0n1/nvme0n1p2/partition":                  file("2\n"),
+		"sys/block/sda/queue/rotational":                         file("1\n"),
+		"sys/block/sda/size":                                     file("7814037168\n"),
+		"sys/block/sda/device/model":                             file("WDC WD40EFRX-68N\n"),
+		"sys/block/sda/device/vpd_pg89":                          file(""),
+		"sys/block/sdb/queue/rotational":                         file("0\n"),
+		"sys/block/sdb/size":                                     file("15628053168\n"),
+		"sys/block/sdb/device/model":                             file("ST8000VN004-2M21\n"),
+		"sys/block/sdb/device/vpd_pg89":                          file(""),
+		"sys/block/sdc/queue/rotational":                         file("1\n"),
+		"sys/block/sdc/size":                                     file("23437770752\n"),
+		"sys/block/sdc/device/model":                             file("ST12000NM0027\n"),
+		"sys/block/sdc/device/sas_address":                       file("0x5000c500a1b2c3d4\n"),
+		"sys/block/sdc/device/vpd_pg80":                          file("\x00\x80\x00\x14ZHZ0ABCD0000C0123456"),
+		"sys/block/sdd":                                          link("../devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdd"),
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/idVendor":  file("152d\n"),
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/idProduct": file("0578\n"),
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdd/queue/rotational": file("1\n"),
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdd/size":             file("3907029168\n"),
+		"sys/block/loop0/queue/rotational": file("1\n"),
+		"dev/nvme0n1":                      &fstest.MapFile{},
+		"dev/sda":                          &fstest.MapFile{},
+		"dev/sdb":                          &fstest.MapFile{},
+		"dev/sdc":                          &fstest.MapFile{},
+		"dev/sdd":                          &fstest.MapFile{},
+		"dev/loop0":                        &fstest.MapFile{},
+		"dev/disk/by-id/ata-WDC_WD40EFRX-68N32N0_WD-WCC7K1234567": link("../../sda"),
+		"dev/disk/by-id/ata-ST8000VN004-2M2101_WSD12345":          link("../../sdb"),
+		"dev/disk/by-id/wwn-0x5000c500a1b2c3d4":                   link("../../sdc"),
+		"proc/self/mountinfo":                                     file("22 1 259:2 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p2 rw\n"),
+	}
+	return fsys
+}
+
+func TestDiscover(t *testing.T) {
+	type selection struct {
+		selected bool
+		reason   string
+	}
+	tests := []struct {
+		name    string
+		filters Filters
+		want    map[string]selection
+	}{
+		{
+			name: "rotational by default",
+			want: map[string]selection{
+				"nvme0n1": {false, "not rotational"},
+				"sda":     {true, "rotational"},
+				"sdb":     {false, "not rotational"},
+				"sdc":     {true, "rotational"},
+				"sdd":     {true, "rotational"},
+			},
+		},
+		{
+			name: "include and exclude",
+			filters: Filters{
+				Include: []Filter{{ID: "ata-ST8000*"}, {Root: true}},
+				Exclude: []Filter{{Transport: "usb"}, {Root: true}, {Model: "^ST12", MinSize: "10T"}},
+			},
+			want: map[string]selection{
+				"nvme0n1": {false, "excluded by root"},
+				"sda":     {true, "rotational"},
+				"sdb":     {true, "included by id=ata-ST8000*"},
+				"sdc":     {false, "excluded by model=~^ST12 size>=10T"},
+				"sdd":     {false, "excluded by transport=usb"},
+			},
+		},
+		{
+			name: "transport and size",
+			filters: Filters{
+				Exclude: []Filter{{Transport: "sata", MaxSize: "5T"}, {Name: "sd[c-z]", Transport: "sas"}},
+			},
+			want: map[string]selection{
+				"nvme0n1": {false, "not rotational"},
+				"sda":     {false, "excluded by transport=sata size<=5T"},
+				"sdb":     {false, "not rotational"},
+				"sdc":     {false, "excluded by name=sd[c-z] transport=sas"},
+				"sdd":     {true, "rotational"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.filters.Validate())
+			candidates, err := discover(discoveryFS(), tt.filters, DefaultQuirks)
+			require.NoError(t, err)
+			got := make(map[string]selection)
+			for _, c := range candidates {
+				got[c.Name] = selection{c.Selected, c.Reason}
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDiscover_Candidate(t *testing.T) {
+	candidates, err := discover(discoveryFS(), Filters{}, nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 5)
+	require.Equal(t, Candidate{
+		Disk:   Disk{Path: "/dev/nvme0n1", Transport: TransportATA},
+		Name:   "nvme0n1",
+		Model:  "Samsung SSD 980 PRO 512GB",
+		Size:   512110190592,
+		Root:   true,
+		Reason: "not rotational",
+	}, candidates[0])
+	require.Equal(t, []string{"ata-WDC_WD40EFRX-68N32N0_WD-WCC7K1234567"}, candidates[1].IDs)
+	require.Equal(t, "ZHZ0ABCD0000C0123456", candidates[3].Serial)
+	require.Empty(t, candidates[1].Serial)
+	require.Equal(t, "152d:0578", candidates[4].USBID)
+	require.Equal(t, uint64(2000398934016), candidates[4].Size)
+	require.Equal(t, []Disk{candidates[1].Disk, candidates[3].Disk, candidates[4].Disk}, Selected(candidates))
+}
+
+func TestFilter_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr string
+	}{
+		{name: "valid", filter: Filter{Name: "sd?", Model: "^WDC", Transport: "sata", MinSize: "1TiB"}},
+		{name: "empty", filter: Filter{}, wantErr: "empty disk filter"},
+		{name: "bad glob", filter: Filter{ID: "ata-[WDC"}, wantErr: "invalid disk filter pattern"},
+		{name: "bad regex", filter: Filter{Model: "WDC("}, wantErr: "invalid disk filter model"},
+		{name: "bad transport", filter: Filter{Transport: "firewire"}, wantErr: `invalid disk filter transport "firewire"`},
+		{name: "bad size", filter: Filter{MaxSize: "4 bananas"}, wantErr: `invalid size "4 bananas"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0},
+		{"512", 512},
+		{"500G", 500e9},
+		{"1.5TB", 1.5e12},
+		{"4TiB", 4 << 40},
+		{"10 t", 10e12},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.want, got, tt.in)
+	}
+	_, err := ParseSize("-1G")
+	require.Error(t, err)
+}
+
+func TestFormatSize(t *testing.T) {
+	require.Equal(t, "512B", FormatSize(512))
+	require.Equal(t, "4.0T", FormatSize(4000787030016))
+	require.Equal(t, "500.1G", FormatSize(500107862016))
+}
@@ -3,80 +3,186 @@ package hw
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// Drive state constants
+// Drive state constants, the ATA power modes reported by CHECK POWER MODE.
+// The idle_* and standby_* modes only exist on drives supporting Extended
+// Power Conditions (EPC).
 const (
-	DriveStateActive  = "active"
+	// DriveStateActive is "active/idle": spinning, ready to serve I/O
+	DriveStateActive = "active"
+	// DriveStateIdle is the legacy idle mode: spinning, electronics partly off
+	DriveStateIdle = "idle"
+	// DriveStateIdleA is the EPC idle_a mode, the lightest idle state
+	DriveStateIdleA = "idle_a"
+	// DriveStateIdleB is the EPC idle_b mode: heads unloaded
+	DriveStateIdleB = "idle_b"
+	// DriveStateIdleC is the EPC idle_c mode: heads unloaded, reduced RPM
+	DriveStateIdleC = "idle_c"
+	// DriveStateStandbyY is the EPC standby_y mode
+	DriveStateStandbyY = "standby_y"
+	// DriveStateStandbyZ is the EPC standby_z mode, same as legacy standby
+	DriveStateStandbyZ = "standby_z"
+	// DriveStateStandby is the legacy standby mode: spun down
 	DriveStateStandby = "standby"
+	// DriveStateSleeping is the sleep mode: the drive only responds to a
+	// reset, any other command fails until the kernel resets the link
+	DriveStateSleeping = "sleeping"
+	// DriveStateUnknown means the drive does not support CHECK POWER MODE
+	DriveStateUnknown = "unknown"
 )
 
+// IsSpunDown tells whether the spindle is stopped in state, i.e. the next I/O
+// costs a spin-up.
+func IsSpunDown(state string) bool {
+	switch state {
+	case DriveStateStandby, DriveStateStandbyY, DriveStateStandbyZ, DriveStateSleeping:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsEPCState tells whether state is only reported by drives supporting
+// Extended Power Conditions.
+func IsEPCState(state string) bool {
+	switch state {
+	case DriveStateIdleA, DriveStateIdleB, DriveStateIdleC, DriveStateStandbyY, DriveStateStandbyZ:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrStateUnavailable is returned by GetState for devices whose power mode
+// cannot be queried without failing or waking them (see Quirk).
+var ErrStateUnavailable = errors.New("power mode cannot be queried safely")
+
+// ErrAPMUnsupported is returned by GetAPM and SetAPM for drives without
+// Advanced Power Management.
+var ErrAPMUnsupported = errors.New("advanced power management not supported")
+
+// APMDisabled is the APM level turning Advanced Power Management off.
+const APMDisabled = 255
+
+// Disk is a rotational disk found by HDDControl.List.
+type Disk struct {
+	// Path is the device node, e.g. /dev/sda
+	Path string `json:"path"`
+	// Transport is one of the Transport* constants
+	Transport string `json:"transport"`
+	// USBID is the vendor:product of the USB bridge, empty if not on USB
+	USBID string `json:"usb_id,omitempty"`
+	// Quirk is the quirk applied to the device, nil if none
+	Quirk *Quirk `json:"quirk,omitempty"`
+}
+
+// DiskPaths returns the device paths of disks.
+func DiskPaths(disks []Disk) []string {
+	paths := make([]string, 0, len(disks))
+	for _, d := range disks {
+		paths = append(paths, d.Path)
+	}
+	return paths
+}
+
 // HDDControl defines an abstraction for HDD operations used by the daemon.
 // It allows swapping implementations for testing or platform-specific behavior.
 type HDDControl interface {
-	// List returns device paths like /dev/sda for rotational disks
-	List() ([]string, error)
-	// GetState queries device state (e.g. returns string containing "standby" or "active/idle")
+	// List returns rotational disks like /dev/sda with the quirk applied to each
+	List() ([]Disk, error)
+	// GetState queries the device power mode, one of the DriveState constants.
+	// Callers must not query a sleeping drive: it only answers to a reset.
 	GetState(dev string) (string, error)
 	// SetStandbyTimeout sets hdparm -S <value> for device. If value == 0, disables spindown timer.
 	SetStandbyTimeout(dev string, value int) error
+	// StandbyNow spins the drive down immediately (hdparm -y, or START STOP UNIT on SCSI).
+	StandbyNow(dev string) error
+	// Wake spins the drive up and returns once it is ready, by reading a sector
+	// (hdparm --read-sector, or START STOP UNIT on SCSI).
+	Wake(dev string) error
+	// IOCount returns the number of completed read and write requests of device,
+	// read from /sys/block/<dev>/stat. It never touches the drive itself.
+	IOCount(dev string) (uint64, error)
+	// GetEPC reads the Extended Power Conditions timers of device, a zero timer
+	// is a disabled condition and a drive with the EPC feature disabled has no
+	// timer. Returns ErrEPCUnsupported if the drive lacks EPC.
+	GetEPC(dev string) (EPCTimers, error)
+	// SetEPC enables the power conditions with a non-zero timer and disables the
+	// others. Zero timers disable EPC altogether.
+	SetEPC(dev string, timers EPCTimers) error
+	// GetAPM reads the Advanced Power Management level (hdparm -B), APMDisabled
+	// when APM is off. Returns ErrAPMUnsupported if the drive lacks APM.
+	GetAPM(dev string) (int, error)
+	// SetAPM sets the APM level: 1-127 permit spin-down, 128-254 do not and
+	// APMDisabled turns APM off.
+	SetAPM(dev string, level int) error
+	// Identify reads the identification and feature sets of the drive
+	// (hdparm -I). Drives behind some USB bridges spin up to answer it.
+	Identify(dev string) (Identity, error)
 }
 
-// DefaultHDDControl is the default implementation of HDDControl that
+// DefaultHDDControl is the ATA implementation of HDDControl that
 // uses the host filesystem and hdparm binary.
 type defaultHDDControl struct {
-	fsys fs.FS
+	fsys   fs.FS
+	quirks []Quirk
 }
 
 // NewHDDControl returns the default HDDControl implementation which uses the
-// host filesystem and hdparm binary.
-func NewHDDControl() HDDControl { return defaultHDDControl{fsys: os.DirFS("/")} }
+// host filesystem, and hdparm or sdparm depending on the transport of each device.
+// The given quirks take precedence over DefaultQuirks.
+func NewHDDControl(quirks ...Quirk) HDDControl {
+	fsys := os.DirFS("/")
+	quirks = append(append([]Quirk{}, quirks...), DefaultQuirks...)
+	return autoHDDControl{
+		fsys:   fsys,
+		quirks: quirks,
+		ata:    defaultHDDControl{fsys: fsys, quirks: quirks},
+		scsi:   scsiHDDControl{fsys: fsys},
+	}
+}
 
-func (d defaultHDDControl) List() ([]string, error) {
+func (d defaultHDDControl) List() ([]Disk, error) {
 	// operate on the configured fs.FS (allows testing with fstest.MapFS)
-	entries, err := fs.Glob(d.fsys, "sys/block/*")
+	candidates, err := discover(d.fsys, Filters{}, d.quirks)
 	if err != nil {
 		return nil, err
 	}
-	var disks []string
-	for _, e := range entries {
-		base := path.Base(e)
-		// ignore loop, ram, dm-* and nvme by rotational check
-		rotPath := path.Join(e, "queue/rotational")
-		data, err := fs.ReadFile(d.fsys, rotPath)
-		if err != nil {
-			// missing rotational file or unreadable -> skip this entry
-			continue
-		}
-		if strings.TrimSpace(string(data)) == "1" {
-			// build dev path as /dev/<base>
-			devPath := path.Join("/dev", base)
-			// Check existence within provided FS; strip leading / for fs.Stat
-			checkPath := path.Join("dev", base)
-			if _, err := fs.Stat(d.fsys, checkPath); err == nil {
-				disks = append(disks, devPath)
-			}
-		}
-	}
-	return disks, nil
+	return Selected(candidates), nil
 }
 
 func (d defaultHDDControl) GetState(dev string) (string, error) {
-	out, err := exec.Command(hdparmPath(), "-C", dev).CombinedOutput()
+	if q := lookupQuirk(d.fsys, d.quirks, dev); q != nil && !q.StateQueryable() {
+		return "", fmt.Errorf("%s behind %s (%s): %w", dev, q.Name, q.USBID, ErrStateUnavailable)
+	}
+	out, err := exec.Command(hdparmPath(), d.args(dev, "-C", dev)...).CombinedOutput()
 	return d.parseHDParmState(string(out), err)
 }
 
+// args prepends the pass-through options required by the quirk of dev to
+// the hdparm arguments.
+func (d defaultHDDControl) args(dev string, args ...string) []string {
+	if q := lookupQuirk(d.fsys, d.quirks, dev); q != nil && q.CommandSet == CommandSetSAT12 {
+		return append([]string{"--prefer-ata12"}, args...)
+	}
+	return args
+}
+
 // parseHDParmState parses the output of `hdparm -C` command and returns
-// a normalized state: "active" or "standby". Returns os.ErrNotExist if device
-// not found, or other error if parsing fails.
+// a normalized state, one of the DriveState constants. Returns os.ErrNotExist
+// if device not found, or other error if parsing fails.
 func (d defaultHDDControl) parseHDParmState(output string, cmdErr error) (string, error) {
 	output = strings.TrimSpace(output)
 	if cmdErr != nil {
@@ -101,16 +207,30 @@ func (d defaultHDDControl) parseHDParmState(output string, cmdErr error) (string
 					active/idle (normal operation),
 					standby (low power mode, drive has spun down),
 					or sleeping (lowest power mode, drive is completely shut down).
+					Drives with EPC or an NV cache may also report idle, idle_a/b/c,
+					standby_y/z and NVcache_spinup/spindown.
 				*/
 				switch stateLower {
 				case "unknown":
-					fallthrough
-				case "active/idle":
+					return DriveStateUnknown, nil
+				case "active/idle", "nvcache_spinup":
 					return DriveStateActive, nil
-				case "standby":
+				case "idle":
+					return DriveStateIdle, nil
+				case "idle_a":
+					return DriveStateIdleA, nil
+				case "idle_b":
+					return DriveStateIdleB, nil
+				case "idle_c":
+					return DriveStateIdleC, nil
+				case "standby", "nvcache_spindown":
 					return DriveStateStandby, nil
+				case "standby_y":
+					return DriveStateStandbyY, nil
+				case "standby_z":
+					return DriveStateStandbyZ, nil
 				case "sleeping":
-					return DriveStateStandby, nil
+					return DriveStateSleeping, nil
 				}
 			}
 		}
@@ -120,17 +240,139 @@ func (d defaultHDDControl) parseHDParmState(output string, cmdErr error) (string
 	return "", fmt.Errorf("malformed hdparm output: %v", output)
 }
 
+// IOCount implements HDDControl.IOCount by reading the kernel block layer
+// statistics, so polling it does not wake a drive in standby.
+func (d defaultHDDControl) IOCount(dev string) (uint64, error) {
+	data, err := fs.ReadFile(d.fsys, path.Join("sys/block", path.Base(dev), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	return parseIOCount(string(data))
+}
+
+// parseIOCount sums completed reads (field 1) and writes (field 5) of a
+// /sys/block/<dev>/stat line.
+func parseIOCount(stat string) (uint64, error) {
+	fields := strings.Fields(stat)
+	if len(fields) < 5 {
+		return 0, fmt.Errorf("malformed block stat: %q", stat)
+	}
+	reads, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed block stat reads: %w", err)
+	}
+	writes, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed block stat writes: %w", err)
+	}
+	return reads + writes, nil
+}
+
+// StandbyDuration converts a hdparm -S value into the idle time after which
+// the drive spins down. It returns 0 for a disabled timer and for values whose
+// meaning is vendor-specific.
+func StandbyDuration(value int) time.Duration {
+	switch {
+	case value >= 1 && value <= 240:
+		return time.Duration(value) * 5 * time.Second
+	case value >= 241 && value <= 251:
+		return time.Duration(value-240) * 30 * time.Minute
+	case value == 252:
+		return 21 * time.Minute
+	case value == 255:
+		return 21*time.Minute + 15*time.Second
+	default:
+		return 0
+	}
+}
+
 // SetStandbyTimeout implements HDDControl.SetStandbyTimeout for the default implementation.
 // It delegates to the package-level SetStandbyTimeout function to perform the actual hdparm call.
-func (defaultHDDControl) SetStandbyTimeout(dev string, value int) error {
+func (d defaultHDDControl) SetStandbyTimeout(dev string, value int) error {
 	logrus.Debugf("use hdparm to set standby timeout %d on %s", value, dev)
-	out, err := exec.Command(hdparmPath(), "-S", fmt.Sprintf("%d", value), dev).CombinedOutput()
+	out, err := exec.Command(hdparmPath(), d.args(dev, "-S", fmt.Sprintf("%d", value), dev)...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to set standby timeout on %s: %w\nOutput: %s", dev, err, string(out))
 	}
 	return nil
 }
 
+// StandbyNow implements HDDControl.StandbyNow with hdparm -y.
+func (d defaultHDDControl) StandbyNow(dev string) error {
+	logrus.Debugf("use hdparm to put %s in standby", dev)
+	out, err := exec.Command(hdparmPath(), d.args(dev, "-y", dev)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to put %s in standby: %w\nOutput: %s", dev, err, string(out))
+	}
+	return nil
+}
+
+// Wake implements HDDControl.Wake with hdparm --read-sector, which waits for
+// the drive to spin up.
+func (d defaultHDDControl) Wake(dev string) error {
+	logrus.Debugf("use hdparm to wake %s", dev)
+	out, err := exec.Command(hdparmPath(), d.args(dev, "--read-sector", "0", dev)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to wake %s: %w\nOutput: %s", dev, err, string(out))
+	}
+	return nil
+}
+
+// GetAPM implements HDDControl.GetAPM with hdparm -B.
+func (d defaultHDDControl) GetAPM(dev string) (int, error) {
+	out, err := exec.Command(hdparmPath(), d.args(dev, "-B", dev)...).CombinedOutput()
+	return d.parseAPM(string(out), err)
+}
+
+// parseAPM parses the output of `hdparm -B`:
+//
+//	/dev/sda:
+//	 APM_level	= 128
+//
+// where the level may also be "off" or "not supported".
+func (defaultHDDControl) parseAPM(output string, cmdErr error) (int, error) {
+	output = strings.TrimSpace(output)
+	if cmdErr != nil {
+		if strings.Contains(output, "No such file or directory") {
+			return 0, os.ErrNotExist
+		}
+		return 0, fmt.Errorf("hdparm command error(%w): %s", cmdErr, output)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || strings.TrimSpace(name) != "APM_level" {
+			continue
+		}
+		switch value = strings.TrimSpace(value); value {
+		case "off":
+			return APMDisabled, nil
+		case "not supported":
+			return 0, ErrAPMUnsupported
+		}
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("malformed APM level: %w", err)
+		}
+		return level, nil
+	}
+	return 0, fmt.Errorf("malformed hdparm output: %v", output)
+}
+
+// SetAPM implements HDDControl.SetAPM with hdparm -B <level>.
+func (d defaultHDDControl) SetAPM(dev string, level int) error {
+	logrus.Debugf("use hdparm to set APM level %d on %s", level, dev)
+	out, err := exec.Command(hdparmPath(), d.args(dev, "-B", strconv.Itoa(level), dev)...).CombinedOutput()
+	if strings.Contains(string(out), "not supported") {
+		return fmt.Errorf("%s: %w", dev, ErrAPMUnsupported)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set APM level on %s: %w\nOutput: %s", dev, err, string(out))
+	}
+	return nil
+}
+
 // hdparmPath returns the path to the hdparm binary. It checks the HDPARM_PATH
 // environment variable and falls back to /sbin/hdparm when not set.
 func hdparmPath() string {
@@ -150,9 +392,31 @@ type dryRunHDDControl struct {
 	inner HDDControl
 }
 
-func (d dryRunHDDControl) List() ([]string, error)             { return d.inner.List() }
-func (d dryRunHDDControl) GetState(dev string) (string, error) { return d.inner.GetState(dev) }
+func (d dryRunHDDControl) List() ([]Disk, error)                { return d.inner.List() }
+func (d dryRunHDDControl) GetState(dev string) (string, error)  { return d.inner.GetState(dev) }
+func (d dryRunHDDControl) IOCount(dev string) (uint64, error)   { return d.inner.IOCount(dev) }
+func (d dryRunHDDControl) GetEPC(dev string) (EPCTimers, error) { return d.inner.GetEPC(dev) }
+func (d dryRunHDDControl) GetAPM(dev string) (int, error)       { return d.inner.GetAPM(dev) }
+func (d dryRunHDDControl) Identify(dev string) (Identity, error) {
+	return d.inner.Identify(dev)
+}
+func (d dryRunHDDControl) SetAPM(dev string, level int) error {
+	logrus.Infof("dry-run: set APM level %d on %s", level, dev)
+	return nil
+}
+func (d dryRunHDDControl) SetEPC(dev string, timers EPCTimers) error {
+	logrus.Infof("dry-run: set epc %s on %s", timers, dev)
+	return nil
+}
 func (d dryRunHDDControl) SetStandbyTimeout(dev string, value int) error {
 	logrus.Infof("dry-run: set standby timeout %d on %s", value, dev)
 	return nil
 }
+func (d dryRunHDDControl) StandbyNow(dev string) error {
+	logrus.Infof("dry-run: put %s in standby", dev)
+	return nil
+}
+func (d dryRunHDDControl) Wake(dev string) error {
+	logrus.Infof("dry-run: wake %s", dev)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -62,7 +63,7 @@ func TestHDDController_List(t *testing.T) {
 			require.NoError(t, err)
 			require.Len(t, disks, tt.wantLen)
 			if tt.wantLen > 0 && tt.wantDisk != "" {
-				require.Equal(t, tt.wantDisk, disks[0])
+				require.Equal(t, tt.wantDisk, disks[0].Path)
 			}
 		})
 	}
@@ -101,7 +102,7 @@ func TestParseHDParmState(t *testing.T) {
  drive state is:  unknown
 `,
 			cmdErr:      nil,
-			expectState: DriveStateActive,
+			expectState: DriveStateUnknown,
 			expectError: false,
 		},
 		{
@@ -110,9 +111,44 @@ func TestParseHDParmState(t *testing.T) {
  drive state is:  sleeping
 `,
 			cmdErr:      nil,
-			expectState: DriveStateStandby,
+			expectState: DriveStateSleeping,
 			expectError: false,
 		},
+		{
+			name: "legacy idle state",
+			hdparmOutput: `/dev/sda:
+ drive state is:  idle
+`,
+			expectState: DriveStateIdle,
+		},
+		{
+			name: "epc idle_b state",
+			hdparmOutput: `/dev/sda:
+ drive state is:  idle_b
+`,
+			expectState: DriveStateIdleB,
+		},
+		{
+			name: "epc idle_c state",
+			hdparmOutput: `/dev/sda:
+ drive state is:  IDLE_C
+`,
+			expectState: DriveStateIdleC,
+		},
+		{
+			name: "epc standby_y state",
+			hdparmOutput: `/dev/sda:
+ drive state is:  standby_y
+`,
+			expectState: DriveStateStandbyY,
+		},
+		{
+			name: "nv cache spindown",
+			hdparmOutput: `/dev/sda:
+ drive state is:  NVcache_spindown
+`,
+			expectState: DriveStateStandby,
+		},
 		{
 			name:           "device not found error",
 			hdparmOutput:   `/dev/sdX: No such file or directory\n`,
@@ -176,3 +212,128 @@ func TestParseHDParmState(t *testing.T) {
 		})
 	}
 }
+
+func TestHDDController_IOCount(t *testing.T) {
+	tests := []struct {
+		name      string
+		fsys      fstest.MapFS
+		dev       string
+		want      uint64
+		expectErr bool
+	}{
+		{
+			name: "reads and writes summed",
+			fsys: fstest.MapFS{
+				"sys/block/sda/stat": &fstest.MapFile{Data: []byte("    1520      210   190322     3211      403      117    12488      902        0     3420     4113        0        0        0        0       31       37\n")},
+			},
+			dev:  "/dev/sda",
+			want: 1923,
+		},
+		{
+			name:      "missing stat file",
+			fsys:      fstest.MapFS{},
+			dev:       "/dev/sdb",
+			expectErr: true,
+		},
+		{
+			name: "truncated stat",
+			fsys: fstest.MapFS{
+				"sys/block/sdc/stat": &fstest.MapFile{Data: []byte("1 2 3\n")},
+			},
+			dev:       "/dev/sdc",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := defaultHDDControl{fsys: tt.fsys}
+			count, err := d.IOCount(tt.dev)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, count)
+		})
+	}
+}
+
+func TestStandbyDuration(t *testing.T) {
+	tests := []struct {
+		value int
+		want  time.Duration
+	}{
+		{value: 0, want: 0},
+		{value: 1, want: 5 * time.Second},
+		{value: 120, want: 10 * time.Minute},
+		{value: 240, want: 20 * time.Minute},
+		{value: 241, want: 30 * time.Minute},
+		{value: 251, want: 5*time.Hour + 30*time.Minute},
+		{value: 252, want: 21 * time.Minute},
+		{value: 253, want: 0},
+		{value: 255, want: 21*time.Minute + 15*time.Second},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, StandbyDuration(tt.value), "value %d", tt.value)
+	}
+}
+
+func TestDriveStateClasses(t *testing.T) {
+	tests := []struct {
+		state    string
+		spunDown bool
+		epc      bool
+	}{
+		{state: DriveStateActive},
+		{state: DriveStateIdle},
+		{state: DriveStateIdleA, epc: true},
+		{state: DriveStateIdleB, epc: true},
+		{state: DriveStateIdleC, epc: true},
+		{state: DriveStateStandbyY, spunDown: true, epc: true},
+		{state: DriveStateStandbyZ, spunDown: true, epc: true},
+		{state: DriveStateStandby, spunDown: true},
+		{state: DriveStateSleeping, spunDown: true},
+		{state: DriveStateUnknown},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.spunDown, IsSpunDown(tt.state), tt.state)
+		require.Equal(t, tt.epc, IsEPCState(tt.state), tt.state)
+	}
+}
+
+func TestParseAPM(t *testing.T) {
+	tests := []struct {
+		name          string
+		output        string
+		cmdErr        error
+		expectLevel   int
+		expectErrorIs error
+		expectError   bool
+	}{
+		{name: "level", output: "\n/dev/sda:\n APM_level\t= 128\n", expectLevel: 128},
+		{name: "off", output: "\n/dev/sda:\n APM_level\t= off\n", expectLevel: APMDisabled},
+		{name: "not supported", output: "\n/dev/sda:\n APM_level\t= not supported\n", expectErrorIs: ErrAPMUnsupported},
+		{name: "device not found", output: "/dev/sdz: No such file or directory", cmdErr: errors.New("exit status 2"), expectErrorIs: os.ErrNotExist},
+		{name: "command failed", output: "HDIO_DRIVE_CMD failed: Input/output error", cmdErr: errors.New("exit status 5"), expectError: true},
+		{name: "malformed level", output: "/dev/sda:\n APM_level\t= high\n", expectError: true},
+		{name: "missing level", output: "/dev/sda:\n", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := defaultHDDControl{}.parseAPM(tt.output, tt.cmdErr)
+			switch {
+			case tt.expectErrorIs != nil:
+				require.ErrorIs(t, err, tt.expectErrorIs)
+			case tt.expectError:
+				require.Error(t, err)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tt.expectLevel, level)
+			}
+		})
+	}
+}
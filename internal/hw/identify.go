@@ -0,0 +1,182 @@
+package hw
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Feature is the state of an optional feature set of a drive.
+type Feature struct {
+	Supported bool `json:"supported"`
+	Enabled   bool `json:"enabled"`
+}
+
+// String returns "enabled", "disabled" or "unsupported".
+func (f Feature) String() string {
+	switch {
+	case !f.Supported:
+		return "unsupported"
+	case f.Enabled:
+		return "enabled"
+	default:
+		return "disabled"
+	}
+}
+
+// Identity is the identification, the power related feature sets and the
+// current power settings of a drive, as reported by IDENTIFY DEVICE on ATA
+// drives. The standby timer is not part of it: drives do not report it.
+type Identity struct {
+	Model    string `json:"model"`
+	Serial   string `json:"serial"`
+	Firmware string `json:"firmware"`
+	// PM is the Power Management feature set, required by the standby timer
+	PM Feature `json:"pm"`
+	// APM is Advanced Power Management
+	APM Feature `json:"apm"`
+	// APMLevel is the current APM level, APMDisabled when APM is off and 0
+	// when it is not supported
+	APMLevel int `json:"apm_level,omitempty"`
+	// StandbyTimer tells how the drive interprets standby timer values,
+	// e.g. "spec'd by Standard, with device specific minimum"
+	StandbyTimer string `json:"standby_timer,omitempty"`
+	// EPC is Extended Power Conditions
+	EPC Feature `json:"epc"`
+	// SCT is the SMART Command Transport
+	SCT Feature `json:"sct"`
+}
+
+// identifyFeatures maps the feature set names listed by hdparm -I to the
+// feature of Identity they describe.
+var identifyFeatures = map[string]func(*Identity) *Feature{
+	"Power Management feature set":              func(id *Identity) *Feature { return &id.PM },
+	"Advanced Power Management feature set":     func(id *Identity) *Feature { return &id.APM },
+	"Extended Power Conditions feature set":     func(id *Identity) *Feature { return &id.EPC },
+	"SMART Command Transport (SCT) feature set": func(id *Identity) *Feature { return &id.SCT },
+}
+
+// Identify implements HDDControl.Identify with hdparm -I.
+func (d defaultHDDControl) Identify(dev string) (Identity, error) {
+	out, err := exec.Command(hdparmPath(), d.args(dev, "-I", dev)...).CombinedOutput()
+	return d.parseIdentify(string(out), err)
+}
+
+// parseIdentify parses the output of `hdparm -I`: the identification fields
+// followed by the feature sets, a leading * marking the enabled ones:
+//
+//	ATA device, with non-removable media
+//		Model Number:       WDC WD40EFRX-68N32N0
+//		Serial Number:      WD-WCC7K0123456
+//		Firmware Revision:  82.00A82
+//	...
+//	Capabilities:
+//		Standby timer values: spec'd by Standard, with device specific minimum
+//		Advanced power management level: 128
+//	Commands/features:
+//		Enabled	Supported:
+//		   *	SMART feature set
+//		   *	Advanced Power Management feature set
+//
+// where the APM level may also be "disabled".
+func (defaultHDDControl) parseIdentify(output string, cmdErr error) (Identity, error) {
+	var id Identity
+	if cmdErr != nil {
+		output = strings.TrimSpace(output)
+		if strings.Contains(output, "No such file or directory") {
+			return id, os.ErrNotExist
+		}
+		return id, fmt.Errorf("hdparm command error(%w): %s", cmdErr, output)
+	}
+
+	var err error
+	features := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "\t") {
+			features = strings.HasPrefix(line, "Commands/features:")
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if features {
+			name, enabled := strings.CutPrefix(line, "*")
+			if feature, ok := identifyFeatures[strings.TrimSpace(name)]; ok {
+				*feature(&id) = Feature{Supported: true, Enabled: enabled}
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch value = strings.TrimSpace(value); name {
+		case "Model Number":
+			id.Model = value
+		case "Serial Number":
+			id.Serial = value
+		case "Firmware Revision":
+			id.Firmware = value
+		case "Standby timer values":
+			id.StandbyTimer = value
+		case "Advanced power management level":
+			if value == "disabled" {
+				id.APMLevel = APMDisabled
+			} else if id.APMLevel, err = strconv.Atoi(value); err != nil {
+				return id, fmt.Errorf("malformed APM level: %w", err)
+			}
+		}
+	}
+	if id.Model == "" {
+		return id, fmt.Errorf("malformed hdparm output: %v", strings.TrimSpace(output))
+	}
+	return id, nil
+}
+
+// Identify reads the identification of a SCSI drive from sysfs, which the
+// kernel caches from INQUIRY, and probes the Power Condition mode page: its
+// standby condition timer is the standby timer, its idle_b and deeper
+// conditions are EPC. ATA only features are reported unsupported.
+func (s scsiHDDControl) Identify(dev string) (Identity, error) {
+	devDir := path.Join("sys/block", path.Base(dev), "device")
+	id := Identity{
+		Model:    strings.TrimSpace(readTrimmed(s.fsys, path.Join(devDir, "vendor")) + " " + readTrimmed(s.fsys, path.Join(devDir, "model"))),
+		Serial:   vpdSerial(s.fsys, path.Base(dev)),
+		Firmware: readTrimmed(s.fsys, path.Join(devDir, "rev")),
+	}
+	values, err := s.modePage(dev)
+	if err != nil {
+		return id, err
+	}
+	if _, ok := values["SCT"]; ok {
+		id.PM = Feature{Supported: true, Enabled: true}
+	}
+	timers, err := modePageTimers(values)
+	switch {
+	case errors.Is(err, ErrEPCUnsupported):
+	case err != nil:
+		return id, err
+	default:
+		id.EPC = Feature{Supported: true, Enabled: timers.Enabled()}
+	}
+	return id, nil
+}
+
+// vpdSerial returns the unit serial number of the disk name from its Unit
+// Serial Number VPD page (0x80), which the kernel reads once at probe time.
+// It is empty when the page is missing.
+func vpdSerial(fsys fs.FS, name string) string {
+	page, err := fs.ReadFile(fsys, path.Join("sys/block", name, "device/vpd_pg80"))
+	// 4 bytes header: peripheral, page code and page length
+	if err != nil || len(page) < 4 {
+		return ""
+	}
+	n := min(len(page), 4+(int(page[2])<<8|int(page[3])))
+	return strings.Trim(string(page[4:n]), " \x00")
+}
@@ -0,0 +1,190 @@
+package hw
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+const identifyOutput = `
+/dev/sda:
+
+ATA device, with non-removable media
+	Model Number:       WDC WD40EFRX-68N32N0
+	Serial Number:      WD-WCC7K1234567
+	Firmware Revision:  82.00A82
+	Transport:          Serial, SATA 1.0a, SATA II Extensions, SATA Rev 2.5, SATA Rev 2.6, SATA Rev 3.0
+Standards:
+	Supported: 9 8 7 6 5
+Capabilities:
+	LBA, IORDY(can be disabled)
+	Standby timer values: spec'd by Standard, with device specific minimum
+	Advanced power management level: 164
+Commands/features:
+	Enabled	Supported:
+	   *	SMART feature set
+	    	Security Mode feature set
+	   *	Power Management feature set
+	   *	Advanced Power Management feature set
+	    	Extended Power Conditions feature set
+	   *	SMART Command Transport (SCT) feature set
+	   *	SCT Write Same (AC2)
+Security:
+	Master password revision code = 65534
+`
+
+func TestParseIdentify(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		cmdErr         error
+		expect         Identity
+		expectErr      error
+		expectErrorMsg string
+	}{
+		{
+			name:   "features",
+			output: identifyOutput,
+			expect: Identity{
+				Model:        "WDC WD40EFRX-68N32N0",
+				Serial:       "WD-WCC7K1234567",
+				Firmware:     "82.00A82",
+				PM:           Feature{Supported: true, Enabled: true},
+				APM:          Feature{Supported: true, Enabled: true},
+				APMLevel:     164,
+				StandbyTimer: "spec'd by Standard, with device specific minimum",
+				EPC:          Feature{Supported: true},
+				SCT:          Feature{Supported: true, Enabled: true},
+			},
+		},
+		{
+			name: "APM disabled",
+			output: `/dev/sdb:
+
+ATA device, with non-removable media
+	Model Number:       ST8000VN004-2M2101
+Capabilities:
+	Advanced power management level: disabled
+Commands/features:
+	Enabled	Supported:
+	    	Advanced Power Management feature set
+`,
+			expect: Identity{
+				Model:    "ST8000VN004-2M2101",
+				APM:      Feature{Supported: true},
+				APMLevel: APMDisabled,
+			},
+		},
+		{
+			name: "malformed APM level",
+			output: `/dev/sdb:
+
+ATA device, with non-removable media
+	Model Number:       ST8000VN004-2M2101
+Capabilities:
+	Advanced power management level: unknown setting
+`,
+			expectErrorMsg: "malformed APM level",
+		},
+		{
+			name: "no optional feature",
+			output: `/dev/sdb:
+
+ATA device, with non-removable media
+	Model Number:       ST8000VN004-2M2101
+	Serial Number:      WSD12345
+	Firmware Revision:  SC60
+Commands/features:
+	Enabled	Supported:
+	   *	SMART feature set
+`,
+			expect: Identity{Model: "ST8000VN004-2M2101", Serial: "WSD12345", Firmware: "SC60"},
+		},
+		{
+			name:      "device not found",
+			output:    "/dev/sdz: No such file or directory",
+			cmdErr:    errors.New("exit status 2"),
+			expectErr: os.ErrNotExist,
+		},
+		{
+			name:           "malformed",
+			output:         "/dev/sda:\n SG_IO: bad/missing sense data",
+			expectErrorMsg: "malformed hdparm output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := defaultHDDControl{}.parseIdentify(tt.output, tt.cmdErr)
+			switch {
+			case tt.expectErr != nil:
+				require.ErrorIs(t, err, tt.expectErr)
+			case tt.expectErrorMsg != "":
+				require.ErrorContains(t, err, tt.expectErrorMsg)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tt.expect, id)
+			}
+		})
+	}
+}
+
+// fakeCommand points the env variable to a command printing output.
+func fakeCommand(t *testing.T, env, output string) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "output"), []byte(output), 0o600))
+	command := filepath.Join(dir, "command")
+	require.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\ncat "+filepath.Join(dir, "output")+"\n"), 0o700))
+	t.Setenv(env, command)
+}
+
+func TestSCSIHDDControl_Identify(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sys/block/sda/device/vendor": &fstest.MapFile{Data: []byte("SEAGATE \n")},
+		"sys/block/sda/device/model":  &fstest.MapFile{Data: []byte("ST4000NM0023    \n")},
+		"sys/block/sda/device/rev":    &fstest.MapFile{Data: []byte("0004\n")},
+	}
+	tests := []struct {
+		name     string
+		modePage string
+		pm       Feature
+		epc      Feature
+	}{
+		{
+			name: "legacy power condition page",
+			modePage: `IDLE        0  [cha: y, def:  0, sav:  0]
+IACT       20  [cha: y, def: 20, sav: 20]
+STANDBY     1  [cha: y, def:  0, sav:  0]
+SCT     18000  [cha: y, def:9000, sav:9000]
+`,
+			pm: Feature{Supported: true, Enabled: true},
+		},
+		{
+			name: "EPC power condition page",
+			modePage: `IDLE        0  [cha: y, def:  0, sav:  0]
+IACT       20  [cha: y, def: 20, sav: 20]
+IDLE_B      1  [cha: y, def:  1, sav:  1]
+IBCT     1200  [cha: y, def:1200, sav:1200]
+STANDBY     0  [cha: y, def:  0, sav:  0]
+SCT         0  [cha: y, def:  0, sav:  0]
+`,
+			pm:  Feature{Supported: true, Enabled: true},
+			epc: Feature{Supported: true, Enabled: true},
+		},
+		{
+			name:     "no power condition page",
+			modePage: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCommand(t, "SDPARM_PATH", tt.modePage)
+			id, err := scsiHDDControl{fsys: fsys}.Identify("/dev/sda")
+			require.NoError(t, err)
+			require.Equal(t, Identity{Model: "SEAGATE ST4000NM0023", Firmware: "0004", PM: tt.pm, EPC: tt.epc}, id)
+		})
+	}
+}
@@ -35,6 +35,126 @@ func (_m *MockHDDControl) EXPECT() *MockHDDControl_Expecter {
 	return &MockHDDControl_Expecter{mock: &_m.Mock}
 }
 
+// GetAPM provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) GetAPM(dev string) (int, error) {
+	ret := _mock.Called(dev)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetAPM")
+	}
+
+	var r0 int
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string) (int, error)); ok {
+		return returnFunc(dev)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string) int); ok {
+		r0 = returnFunc(dev)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+	if returnFunc, ok := ret.Get(1).(func(string) error); ok {
+		r1 = returnFunc(dev)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockHDDControl_GetAPM_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetAPM'
+type MockHDDControl_GetAPM_Call struct {
+	*mock.Call
+}
+
+// GetAPM is a helper method to define mock.On call
+//   - dev string
+func (_e *MockHDDControl_Expecter) GetAPM(dev interface{}) *MockHDDControl_GetAPM_Call {
+	return &MockHDDControl_GetAPM_Call{Call: _e.mock.On("GetAPM", dev)}
+}
+
+func (_c *MockHDDControl_GetAPM_Call) Run(run func(dev string)) *MockHDDControl_GetAPM_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_GetAPM_Call) Return(level int, err error) *MockHDDControl_GetAPM_Call {
+	_c.Call.Return(level, err)
+	return _c
+}
+
+func (_c *MockHDDControl_GetAPM_Call) RunAndReturn(run func(dev string) (int, error)) *MockHDDControl_GetAPM_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetEPC provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) GetEPC(dev string) (EPCTimers, error) {
+	ret := _mock.Called(dev)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetEPC")
+	}
+
+	var r0 EPCTimers
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string) (EPCTimers, error)); ok {
+		return returnFunc(dev)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string) EPCTimers); ok {
+		r0 = returnFunc(dev)
+	} else {
+		r0 = ret.Get(0).(EPCTimers)
+	}
+	if returnFunc, ok := ret.Get(1).(func(string) error); ok {
+		r1 = returnFunc(dev)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockHDDControl_GetEPC_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetEPC'
+type MockHDDControl_GetEPC_Call struct {
+	*mock.Call
+}
+
+// GetEPC is a helper method to define mock.On call
+//   - dev string
+func (_e *MockHDDControl_Expecter) GetEPC(dev interface{}) *MockHDDControl_GetEPC_Call {
+	return &MockHDDControl_GetEPC_Call{Call: _e.mock.On("GetEPC", dev)}
+}
+
+func (_c *MockHDDControl_GetEPC_Call) Run(run func(dev string)) *MockHDDControl_GetEPC_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_GetEPC_Call) Return(timers EPCTimers, err error) *MockHDDControl_GetEPC_Call {
+	_c.Call.Return(timers, err)
+	return _c
+}
+
+func (_c *MockHDDControl_GetEPC_Call) RunAndReturn(run func(dev string) (EPCTimers, error)) *MockHDDControl_GetEPC_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetState provides a mock function for the type MockHDDControl
 func (_mock *MockHDDControl) GetState(dev string) (string, error) {
 	ret := _mock.Called(dev)
@@ -95,24 +215,144 @@ func (_c *MockHDDControl_GetState_Call) RunAndReturn(run func(dev string) (strin
 	return _c
 }
 
+// IOCount provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) IOCount(dev string) (uint64, error) {
+	ret := _mock.Called(dev)
+
+	if len(ret) == 0 {
+		panic("no return value specified for IOCount")
+	}
+
+	var r0 uint64
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string) (uint64, error)); ok {
+		return returnFunc(dev)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string) uint64); ok {
+		r0 = returnFunc(dev)
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+	if returnFunc, ok := ret.Get(1).(func(string) error); ok {
+		r1 = returnFunc(dev)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockHDDControl_IOCount_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'IOCount'
+type MockHDDControl_IOCount_Call struct {
+	*mock.Call
+}
+
+// IOCount is a helper method to define mock.On call
+//   - dev string
+func (_e *MockHDDControl_Expecter) IOCount(dev interface{}) *MockHDDControl_IOCount_Call {
+	return &MockHDDControl_IOCount_Call{Call: _e.mock.On("IOCount", dev)}
+}
+
+func (_c *MockHDDControl_IOCount_Call) Run(run func(dev string)) *MockHDDControl_IOCount_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_IOCount_Call) Return(v uint64, err error) *MockHDDControl_IOCount_Call {
+	_c.Call.Return(v, err)
+	return _c
+}
+
+func (_c *MockHDDControl_IOCount_Call) RunAndReturn(run func(dev string) (uint64, error)) *MockHDDControl_IOCount_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Identify provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) Identify(dev string) (Identity, error) {
+	ret := _mock.Called(dev)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Identify")
+	}
+
+	var r0 Identity
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string) (Identity, error)); ok {
+		return returnFunc(dev)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string) Identity); ok {
+		r0 = returnFunc(dev)
+	} else {
+		r0 = ret.Get(0).(Identity)
+	}
+	if returnFunc, ok := ret.Get(1).(func(string) error); ok {
+		r1 = returnFunc(dev)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockHDDControl_Identify_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Identify'
+type MockHDDControl_Identify_Call struct {
+	*mock.Call
+}
+
+// Identify is a helper method to define mock.On call
+//   - dev string
+func (_e *MockHDDControl_Expecter) Identify(dev interface{}) *MockHDDControl_Identify_Call {
+	return &MockHDDControl_Identify_Call{Call: _e.mock.On("Identify", dev)}
+}
+
+func (_c *MockHDDControl_Identify_Call) Run(run func(dev string)) *MockHDDControl_Identify_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_Identify_Call) Return(id Identity, err error) *MockHDDControl_Identify_Call {
+	_c.Call.Return(id, err)
+	return _c
+}
+
+func (_c *MockHDDControl_Identify_Call) RunAndReturn(run func(dev string) (Identity, error)) *MockHDDControl_Identify_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // List provides a mock function for the type MockHDDControl
-func (_mock *MockHDDControl) List() ([]string, error) {
+func (_mock *MockHDDControl) List() ([]Disk, error) {
 	ret := _mock.Called()
 
 	if len(ret) == 0 {
 		panic("no return value specified for List")
 	}
 
-	var r0 []string
+	var r0 []Disk
 	var r1 error
-	if returnFunc, ok := ret.Get(0).(func() ([]string, error)); ok {
+	if returnFunc, ok := ret.Get(0).(func() ([]Disk, error)); ok {
 		return returnFunc()
 	}
-	if returnFunc, ok := ret.Get(0).(func() []string); ok {
+	if returnFunc, ok := ret.Get(0).(func() []Disk); ok {
 		r0 = returnFunc()
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]string)
+			r0 = ret.Get(0).([]Disk)
 		}
 	}
 	if returnFunc, ok := ret.Get(1).(func() error); ok {
@@ -140,12 +380,126 @@ func (_c *MockHDDControl_List_Call) Run(run func()) *MockHDDControl_List_Call {
 	return _c
 }
 
-func (_c *MockHDDControl_List_Call) Return(strings []string, err error) *MockHDDControl_List_Call {
-	_c.Call.Return(strings, err)
+func (_c *MockHDDControl_List_Call) Return(disks []Disk, err error) *MockHDDControl_List_Call {
+	_c.Call.Return(disks, err)
+	return _c
+}
+
+func (_c *MockHDDControl_List_Call) RunAndReturn(run func() ([]Disk, error)) *MockHDDControl_List_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SetAPM provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) SetAPM(dev string, level int) error {
+	ret := _mock.Called(dev, level)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetAPM")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = returnFunc(dev, level)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockHDDControl_SetAPM_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SetAPM'
+type MockHDDControl_SetAPM_Call struct {
+	*mock.Call
+}
+
+// SetAPM is a helper method to define mock.On call
+//   - dev string
+//   - level int
+func (_e *MockHDDControl_Expecter) SetAPM(dev interface{}, level interface{}) *MockHDDControl_SetAPM_Call {
+	return &MockHDDControl_SetAPM_Call{Call: _e.mock.On("SetAPM", dev, level)}
+}
+
+func (_c *MockHDDControl_SetAPM_Call) Run(run func(dev string, level int)) *MockHDDControl_SetAPM_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		var arg1 int
+		if args[1] != nil {
+			arg1 = args[1].(int)
+		}
+		run(
+			arg0,
+			arg1,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_SetAPM_Call) Return(err error) *MockHDDControl_SetAPM_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockHDDControl_SetAPM_Call) RunAndReturn(run func(dev string, level int) error) *MockHDDControl_SetAPM_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SetEPC provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) SetEPC(dev string, timers EPCTimers) error {
+	ret := _mock.Called(dev, timers)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetEPC")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string, EPCTimers) error); ok {
+		r0 = returnFunc(dev, timers)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockHDDControl_SetEPC_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SetEPC'
+type MockHDDControl_SetEPC_Call struct {
+	*mock.Call
+}
+
+// SetEPC is a helper method to define mock.On call
+//   - dev string
+//   - timers EPCTimers
+func (_e *MockHDDControl_Expecter) SetEPC(dev interface{}, timers interface{}) *MockHDDControl_SetEPC_Call {
+	return &MockHDDControl_SetEPC_Call{Call: _e.mock.On("SetEPC", dev, timers)}
+}
+
+func (_c *MockHDDControl_SetEPC_Call) Run(run func(dev string, timers EPCTimers)) *MockHDDControl_SetEPC_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		var arg1 EPCTimers
+		if args[1] != nil {
+			arg1 = args[1].(EPCTimers)
+		}
+		run(
+			arg0,
+			arg1,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_SetEPC_Call) Return(err error) *MockHDDControl_SetEPC_Call {
+	_c.Call.Return(err)
 	return _c
 }
 
-func (_c *MockHDDControl_List_Call) RunAndReturn(run func() ([]string, error)) *MockHDDControl_List_Call {
+func (_c *MockHDDControl_SetEPC_Call) RunAndReturn(run func(dev string, timers EPCTimers) error) *MockHDDControl_SetEPC_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -206,3 +560,105 @@ func (_c *MockHDDControl_SetStandbyTimeout_Call) RunAndReturn(run func(dev strin
 	_c.Call.Return(run)
 	return _c
 }
+
+// StandbyNow provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) StandbyNow(dev string) error {
+	ret := _mock.Called(dev)
+
+	if len(ret) == 0 {
+		panic("no return value specified for StandbyNow")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string) error); ok {
+		r0 = returnFunc(dev)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockHDDControl_StandbyNow_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'StandbyNow'
+type MockHDDControl_StandbyNow_Call struct {
+	*mock.Call
+}
+
+// StandbyNow is a helper method to define mock.On call
+//   - dev string
+func (_e *MockHDDControl_Expecter) StandbyNow(dev interface{}) *MockHDDControl_StandbyNow_Call {
+	return &MockHDDControl_StandbyNow_Call{Call: _e.mock.On("StandbyNow", dev)}
+}
+
+func (_c *MockHDDControl_StandbyNow_Call) Run(run func(dev string)) *MockHDDControl_StandbyNow_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_StandbyNow_Call) Return(err error) *MockHDDControl_StandbyNow_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockHDDControl_StandbyNow_Call) RunAndReturn(run func(dev string) error) *MockHDDControl_StandbyNow_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Wake provides a mock function for the type MockHDDControl
+func (_mock *MockHDDControl) Wake(dev string) error {
+	ret := _mock.Called(dev)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Wake")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string) error); ok {
+		r0 = returnFunc(dev)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockHDDControl_Wake_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Wake'
+type MockHDDControl_Wake_Call struct {
+	*mock.Call
+}
+
+// Wake is a helper method to define mock.On call
+//   - dev string
+func (_e *MockHDDControl_Expecter) Wake(dev interface{}) *MockHDDControl_Wake_Call {
+	return &MockHDDControl_Wake_Call{Call: _e.mock.On("Wake", dev)}
+}
+
+func (_c *MockHDDControl_Wake_Call) Run(run func(dev string)) *MockHDDControl_Wake_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockHDDControl_Wake_Call) Return(err error) *MockHDDControl_Wake_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockHDDControl_Wake_Call) RunAndReturn(run func(dev string) error) *MockHDDControl_Wake_Call {
+	_c.Call.Return(run)
+	return _c
+}
@@ -0,0 +1,108 @@
+package hw
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Command sets a USB bridge may need.
+const (
+	// CommandSetSAT16 is the default 16-byte SCSI/ATA translation pass-through.
+	CommandSetSAT16 = "sat16"
+	// CommandSetSAT12 forces the 12-byte pass-through (hdparm --prefer-ata12).
+	CommandSetSAT12 = "sat12"
+	// CommandSetSCSI skips ATA pass-through and uses native SCSI commands.
+	CommandSetSCSI = "scsi"
+)
+
+// Quirk describes how to talk to disks behind a USB bridge.
+type Quirk struct {
+	// USBID is the bridge vendor:product in lowercase hex, e.g. "152d:2338".
+	USBID string `yaml:"usb"`
+	Name  string `yaml:"name"`
+	// CommandSet is one of the CommandSet* constants; sat16 when empty.
+	CommandSet string `yaml:"command_set"`
+	// NoPowerQuery marks bridges refusing CHECK POWER MODE. Disks behind them
+	// are not managed: no command is sent to a disk not known to be spinning.
+	NoPowerQuery bool `yaml:"no_power_query"`
+	// QueryWakes marks bridges that spin the disk up when its state is
+	// queried, their disks are not managed either.
+	QueryWakes bool `yaml:"query_wakes"`
+}
+
+// DefaultQuirks are the built-in quirks. User supplied entries take precedence.
+var DefaultQuirks = []Quirk{
+	{USBID: "152d:2338", Name: "JMicron JM20337", CommandSet: CommandSetSAT12},
+	{USBID: "152d:2339", Name: "JMicron JM20339", CommandSet: CommandSetSAT12},
+	{USBID: "13fd:1340", Name: "Initio INIC-1610", CommandSet: CommandSetSAT12},
+	{USBID: "067b:2773", Name: "Prolific PL2773", CommandSet: CommandSetSAT12},
+	{USBID: "04fc:0c25", Name: "Sunplus SPIF225A", CommandSet: CommandSetSCSI},
+	{USBID: "0bc2:2300", Name: "Seagate Portable", QueryWakes: true},
+}
+
+var usbIDPattern = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{4}$`)
+
+// Validate checks the quirk is well formed.
+func (q Quirk) Validate() error {
+	if !usbIDPattern.MatchString(q.USBID) {
+		return fmt.Errorf("invalid usb id %q: expected vendor:product in lowercase hex", q.USBID)
+	}
+	switch q.CommandSet {
+	case "", CommandSetSAT16, CommandSetSAT12, CommandSetSCSI:
+		return nil
+	default:
+		return fmt.Errorf("invalid command set %q for %s: expected %s|%s|%s", q.CommandSet, q.USBID, CommandSetSAT16, CommandSetSAT12, CommandSetSCSI)
+	}
+}
+
+// StateQueryable tells whether the power mode can be queried without
+// failing or waking the disk.
+func (q Quirk) StateQueryable() bool {
+	return !q.NoPowerQuery && !q.QueryWakes
+}
+
+// USBID returns the vendor:product of the USB device dev is attached to, read
+// from the sysfs ancestry of /sys/block/<dev>, or "" if dev is not on USB.
+func USBID(fsys fs.FS, dev string) string {
+	block := path.Join("sys/block", path.Base(dev))
+	link, err := fs.ReadLink(fsys, block)
+	if err != nil {
+		return ""
+	}
+	dir := path.Join(path.Dir(block), link)
+	if path.IsAbs(link) {
+		dir = strings.TrimPrefix(path.Clean(link), "/")
+	}
+	for ; strings.HasPrefix(dir, "sys/devices/"); dir = path.Dir(dir) {
+		vendor, err := fs.ReadFile(fsys, path.Join(dir, "idVendor"))
+		if err != nil {
+			continue
+		}
+		product, err := fs.ReadFile(fsys, path.Join(dir, "idProduct"))
+		if err != nil {
+			continue
+		}
+		return strings.ToLower(strings.TrimSpace(string(vendor)) + ":" + strings.TrimSpace(string(product)))
+	}
+	return ""
+}
+
+// lookupQuirk returns the first quirk matching the USB bridge of dev.
+func lookupQuirk(fsys fs.FS, quirks []Quirk, dev string) *Quirk {
+	if len(quirks) == 0 {
+		return nil
+	}
+	id := USBID(fsys, dev)
+	if id == "" {
+		return nil
+	}
+	for i := range quirks {
+		if quirks[i].USBID == id {
+			return &quirks[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package hw
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// usbFS lays out sda behind a JMicron JM20337 bridge, sdb behind a bridge
+// that wakes the disk on queries and sdc on a SATA port.
+func usbFS() fstest.MapFS {
+	symlink := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Mode: fs.ModeSymlink, Data: []byte(target)}
+	}
+	return fstest.MapFS{
+		"sys/block/sda": symlink("../devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sda"),
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/idVendor":                                                     &fstest.MapFile{Data: []byte("152d\n")},
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/idProduct":                                                    &fstest.MapFile{Data: []byte("2338\n")},
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/idVendor":                                                         &fstest.MapFile{Data: []byte("1d6b\n")},
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/idProduct":                                                        &fstest.MapFile{Data: []byte("0003\n")},
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sda/queue/rotational": &fstest.MapFile{Data: []byte("1\n")},
+		"sys/block/sdb": symlink("../devices/pci0000:00/0000:00:14.0/usb2/2-2/2-2:1.0/host7/target7:0:0/7:0:0:0/block/sdb"),
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-2/idVendor":                                                     &fstest.MapFile{Data: []byte("0BC2\n")},
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-2/idProduct":                                                    &fstest.MapFile{Data: []byte("ab38\n")},
+		"sys/devices/pci0000:00/0000:00:14.0/usb2/2-2/2-2:1.0/host7/target7:0:0/7:0:0:0/block/sdb/queue/rotational": &fstest.MapFile{Data: []byte("1\n")},
+		"sys/block/sdc": symlink("../devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sdc"),
+		"sys/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sdc/queue/rotational": &fstest.MapFile{Data: []byte("1\n")},
+		"dev/sda": &fstest.MapFile{},
+		"dev/sdb": &fstest.MapFile{},
+		"dev/sdc": &fstest.MapFile{},
+	}
+}
+
+func TestUSBID(t *testing.T) {
+	fsys := usbFS()
+	require.Equal(t, "152d:2338", USBID(fsys, "/dev/sda"))
+	require.Equal(t, "0bc2:ab38", USBID(fsys, "/dev/sdb"))
+	require.Equal(t, "", USBID(fsys, "/dev/sdc"))
+	require.Equal(t, "", USBID(fsys, "/dev/sdd"))
+}
+
+func TestQuirk_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		quirk   Quirk
+		wantErr string
+	}{
+		{name: "valid", quirk: Quirk{USBID: "152d:2338", CommandSet: CommandSetSAT12}},
+		{name: "default command set", quirk: Quirk{USBID: "0bc2:ab38", QueryWakes: true}},
+		{name: "uppercase id", quirk: Quirk{USBID: "0BC2:AB38"}, wantErr: "invalid usb id"},
+		{name: "missing product", quirk: Quirk{USBID: "0bc2"}, wantErr: "invalid usb id"},
+		{name: "unknown command set", quirk: Quirk{USBID: "0bc2:ab38", CommandSet: "sat32"}, wantErr: "invalid command set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quirk.Validate()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	for _, q := range DefaultQuirks {
+		require.NoError(t, q.Validate(), q.Name)
+	}
+}
+
+func TestHDDController_ListQuirks(t *testing.T) {
+	user := Quirk{USBID: "0bc2:ab38", Name: "Seagate Backup Plus", QueryWakes: true}
+	d := defaultHDDControl{fsys: usbFS(), quirks: append([]Quirk{user}, DefaultQuirks...)}
+
+	disks, err := d.List()
+	require.NoError(t, err)
+	require.Len(t, disks, 3)
+
+	require.Equal(t, "/dev/sda", disks[0].Path)
+	require.Equal(t, "152d:2338", disks[0].USBID)
+	require.NotNil(t, disks[0].Quirk)
+	require.Equal(t, "JMicron JM20337", disks[0].Quirk.Name)
+
+	require.Equal(t, "/dev/sdb", disks[1].Path)
+	require.Equal(t, &user, disks[1].Quirk)
+
+	require.Equal(t, "/dev/sdc", disks[2].Path)
+	require.Equal(t, "", disks[2].USBID)
+	require.Nil(t, disks[2].Quirk)
+}
+
+func TestHDDController_QuirkCommands(t *testing.T) {
+	d := defaultHDDControl{fsys: usbFS(), quirks: append([]Quirk{{USBID: "0bc2:ab38", Name: "Seagate Backup Plus", QueryWakes: true}}, DefaultQuirks...)}
+
+	require.Equal(t, []string{"--prefer-ata12", "-C", "/dev/sda"}, d.args("/dev/sda", "-C", "/dev/sda"))
+	require.Equal(t, []string{"-S", "120", "/dev/sdc"}, d.args("/dev/sdc", "-S", "120", "/dev/sdc"))
+
+	// never queried, hdparm is not even looked up
+	_, err := d.GetState("/dev/sdb")
+	require.True(t, errors.Is(err, ErrStateUnavailable))
+}
+
+func TestAutoHDDControl_QuirkSelectsSCSI(t *testing.T) {
+	ata := NewMockHDDControl(t)
+	scsi := NewMockHDDControl(t)
+	auto := autoHDDControl{fsys: usbFS(), quirks: []Quirk{{USBID: "152d:2338", CommandSet: CommandSetSCSI}}, ata: ata, scsi: scsi}
+
+	scsi.EXPECT().StandbyNow("/dev/sda").Return(nil).Once()
+	ata.EXPECT().StandbyNow("/dev/sdc").Return(nil).Once()
+	require.NoError(t, auto.StandbyNow("/dev/sda"))
+	require.NoError(t, auto.StandbyNow("/dev/sdc"))
+}
@@ -0,0 +1,284 @@
+package hw
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tagLinks are the udev symlink directories of the filesystem and partition
+// tags accepted in place of a device.
+var tagLinks = map[string]string{
+	"UUID":      "dev/disk/by-uuid",
+	"LABEL":     "dev/disk/by-label",
+	"PARTUUID":  "dev/disk/by-partuuid",
+	"PARTLABEL": "dev/disk/by-partlabel",
+}
+
+// ResolveDevices maps each spec to the disks it lives on, in order and
+// without duplicates. A spec is one of:
+//
+//   - a disk like /dev/sda, kept as is;
+//   - a partition, md array, LVM volume or dm-crypt mapping like /dev/sda1,
+//     /dev/md0 or /dev/mapper/vg-data;
+//   - a filesystem or partition tag like UUID=..., LABEL=..., PARTUUID=...
+//     or PARTLABEL=...;
+//   - a mount point or any path below one, like /srv/media.
+//
+// All but disks resolve to the rotational disks below the partition, md,
+// LVM and dm-crypt layers. Device paths unknown to sysfs are kept as is.
+func ResolveDevices(specs []string) ([]string, error) {
+	return resolveDevices(os.DirFS("/"), specs)
+}
+
+func resolveDevices(fsys fs.FS, specs []string) ([]string, error) {
+	var devs []string
+	for _, spec := range specs {
+		disks, err := resolveDevice(fsys, spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		for _, disk := range disks {
+			if !slices.Contains(devs, disk) {
+				devs = append(devs, disk)
+			}
+		}
+	}
+	return devs, nil
+}
+
+func resolveDevice(fsys fs.FS, spec string) ([]string, error) {
+	name, err := blockName(fsys, spec)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return []string{spec}, nil
+	}
+	if strings.HasPrefix(spec, "/dev/") && parentDisk(fsys, name) == name {
+		return []string{"/dev/" + name}, nil
+	}
+	var disks []string
+	for _, disk := range lowerDisks(fsys, name) {
+		if rotational(fsys, disk) && !slices.Contains(disks, "/dev/"+disk) {
+			disks = append(disks, "/dev/"+disk)
+		}
+	}
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("%s is not stored on a rotational disk", name)
+	}
+	slices.Sort(disks)
+	return disks, nil
+}
+
+// blockName returns the kernel name of the block device of spec, e.g. sda1
+// or dm-3, or "" for a device path unknown to sysfs.
+func blockName(fsys fs.FS, spec string) (string, error) {
+	if tag, value, ok := strings.Cut(spec, "="); ok && tagLinks[tag] != "" {
+		// udev escapes spaces and slashes in its symlink names
+		value = strings.NewReplacer(" ", `\x20`, "/", `\x2f`).Replace(value)
+		target, err := fs.ReadLink(fsys, path.Join(tagLinks[tag], value))
+		if err != nil {
+			return "", fmt.Errorf("no device with %s %q", tag, value)
+		}
+		return path.Base(target), nil
+	}
+	if !path.IsAbs(spec) {
+		return "", fmt.Errorf("expected a device, a tag like UUID=... or an absolute path")
+	}
+	if strings.HasPrefix(spec, "/dev/") {
+		name := path.Base(spec)
+		// /dev/mapper and /dev/disk entries link to the kernel name
+		if target, err := fs.ReadLink(fsys, strings.TrimPrefix(spec, "/")); err == nil {
+			name = path.Base(target)
+		}
+		if !isBlock(fsys, name) {
+			return "", nil
+		}
+		return name, nil
+	}
+	return mountBlockName(fsys, spec)
+}
+
+// mountBlockName returns the block device of the filesystem holding p.
+func mountBlockName(fsys fs.FS, p string) (string, error) {
+	data, err := fs.ReadFile(fsys, "proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	p = path.Clean(p)
+	var mount, devNum, source string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// 36 35 8:1 / /mnt/data rw,relatime shared:1 - ext4 /dev/sda1 rw
+		fields := strings.Fields(scanner.Text())
+		sep := slices.Index(fields, "-")
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		m := unescapeMount(fields[4])
+		// the last of overlapping mounts is the visible one
+		if Under(p, m) && len(m) >= len(mount) {
+			mount, devNum, source = m, fields[2], unescapeMount(fields[sep+2])
+		}
+	}
+	if mount == "" {
+		return "", fmt.Errorf("not mounted")
+	}
+	if name, ok := blockNames(fsys)[devNum]; ok {
+		return name, nil
+	}
+	// btrfs reports an anonymous device number, its source is the device
+	if strings.HasPrefix(source, "/dev/") {
+		if name, err := blockName(fsys, source); err == nil && name != "" {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("mount %s of %s is not on a block device", mount, source)
+}
+
+// blockNames returns the kernel name of the disks, partitions and stacked
+// devices by major:minor.
+func blockNames(fsys fs.FS) map[string]string {
+	names := make(map[string]string)
+	for _, pattern := range []string{"sys/block/*/dev", "sys/block/*/*/dev"} {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if num, err := fs.ReadFile(fsys, m); err == nil {
+				names[strings.TrimSpace(string(num))] = path.Base(path.Dir(m))
+			}
+		}
+	}
+	return names
+}
+
+// isBlock tells whether name is a disk, partition or stacked device.
+func isBlock(fsys fs.FS, name string) bool {
+	if _, err := fs.Stat(fsys, path.Join("sys/block", name)); err == nil {
+		return true
+	}
+	return parentDisk(fsys, name) != ""
+}
+
+// lowerDisks returns the disks below the block device name, following the
+// slaves of md and device-mapper devices down to partitions and disks.
+func lowerDisks(fsys fs.FS, name string) []string {
+	slaves, err := fs.ReadDir(fsys, path.Join("sys/block", name, "slaves"))
+	if err == nil && len(slaves) > 0 {
+		var disks []string
+		for _, s := range slaves {
+			disks = append(disks, lowerDisks(fsys, s.Name())...)
+		}
+		return disks
+	}
+	if disk := parentDisk(fsys, name); disk != "" {
+		return []string{disk}
+	}
+	return nil
+}
+
+// rotational tells whether the disk name spins.
+func rotational(fsys fs.FS, name string) bool {
+	data, err := fs.ReadFile(fsys, path.Join("sys/block", name, "queue/rotational"))
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
+
+// MountPoints returns where the filesystems stored on dev are mounted: the
+// ones of the disk, its partitions, and the md and device-mapper devices
+// stacked on them. ZFS datasets report no block device and are not found.
+func MountPoints(fsys fs.FS, dev string) []string {
+	upper := make(map[string]string)
+	upperDevs(fsys, path.Base(dev), path.Join("sys/block", path.Base(dev)), upper)
+	if len(upper) == 0 {
+		return nil
+	}
+	devNums := make(map[string]bool, len(upper))
+	for _, num := range upper {
+		devNums[num] = true
+	}
+
+	data, err := fs.ReadFile(fsys, "proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	var mounts []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		match := devNums[fields[2]]
+		// btrfs reports an anonymous device number, its source is the device
+		if sep := slices.Index(fields, "-"); !match && sep > 0 && sep+2 < len(fields) && strings.HasPrefix(fields[sep+2], "/dev/") {
+			if name, err := blockName(fsys, unescapeMount(fields[sep+2])); err == nil {
+				_, match = upper[name]
+			}
+		}
+		if match {
+			mounts = append(mounts, unescapeMount(fields[4]))
+		}
+	}
+	return mounts
+}
+
+// upperDevs adds the block device name at dir in sysfs, its partitions and
+// the devices holding them to upper, by name with their major:minor.
+func upperDevs(fsys fs.FS, name, dir string, upper map[string]string) {
+	num, err := fs.ReadFile(fsys, path.Join(dir, "dev"))
+	if err != nil {
+		return
+	}
+	upper[name] = strings.TrimSpace(string(num))
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if _, err := fs.Stat(fsys, path.Join(dir, e.Name(), "partition")); err == nil {
+			upperDevs(fsys, e.Name(), path.Join(dir, e.Name()), upper)
+		}
+	}
+	holders, err := fs.ReadDir(fsys, path.Join(dir, "holders"))
+	if err != nil {
+		return
+	}
+	for _, h := range holders {
+		upperDevs(fsys, h.Name(), path.Join("sys/block", h.Name()), upper)
+	}
+}
+
+// unescapeMount decodes the octal escapes of spaces, tabs, newlines and
+// backslashes in a mountinfo path.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Under tells whether p is mount or below it.
+func Under(p, mount string) bool {
+	return p == mount || mount == "/" || strings.HasPrefix(p, strings.TrimSuffix(mount, "/")+"/")
+}
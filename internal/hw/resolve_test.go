@@ -0,0 +1,109 @@
+package hw
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a root filesystem on an SSD, an ext4 partition on sda, dm-crypt over LVM
+// over an md mirror of sda2 and sdb1, a btrfs disk and a ZFS dataset.
+func layeredFS() fstest.MapFS {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	return fstest.MapFS{
+		"sys/block/sda/dev":                     file("8:0\n"),
+		"sys/block/sda/queue/rotational":        file("1\n"),
+		"sys/block/sda/sda1/dev":                file("8:1\n"),
+		"sys/block/sda/sda1/partition":          file("1\n"),
+		"sys/block/sda/sda2/dev":                file("8:2\n"),
+		"sys/block/sda/sda2/partition":          file("2\n"),
+		"sys/block/sda/sda2/holders/md0":        &fstest.MapFile{},
+		"sys/block/sdb/dev":                     file("8:16\n"),
+		"sys/block/sdb/queue/rotational":        file("1\n"),
+		"sys/block/sdb/sdb1/dev":                file("8:17\n"),
+		"sys/block/sdb/sdb1/partition":          file("1\n"),
+		"sys/block/sdb/sdb1/holders/md0":        &fstest.MapFile{},
+		"sys/block/sdc/dev":                     file("8:32\n"),
+		"sys/block/sdc/queue/rotational":        file("1\n"),
+		"sys/block/md0/dev":                     file("9:0\n"),
+		"sys/block/md0/slaves/sda2":             &fstest.MapFile{},
+		"sys/block/md0/slaves/sdb1":             &fstest.MapFile{},
+		"sys/block/md0/holders/dm-0":            &fstest.MapFile{},
+		"sys/block/dm-0/dev":                    file("253:0\n"),
+		"sys/block/dm-0/slaves/md0":             &fstest.MapFile{},
+		"sys/block/dm-0/holders/dm-1":           &fstest.MapFile{},
+		"sys/block/dm-1/dev":                    file("253:1\n"),
+		"sys/block/dm-1/slaves/dm-0":            &fstest.MapFile{},
+		"sys/block/nvme0n1/dev":                 file("259:0\n"),
+		"sys/block/nvme0n1/queue/rotational":    file("0\n"),
+		"sys/block/nvme0n1/nvme0n1p1/dev":       file("259:1\n"),
+		"sys/block/nvme0n1/nvme0n1p1/partition": file("1\n"),
+		"dev/mapper/vg-data":                    link("../dm-0"),
+		"dev/mapper/secret":                     link("../dm-1"),
+		"dev/disk/by-uuid/5c1f-0a2b":            link("../../sda1"),
+		`dev/disk/by-label/media\x20files`:      link("../../dm-1"),
+		"proc/self/mountinfo": file(`22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw
+36 22 8:1 / /mnt/a rw,relatime shared:2 - ext4 /dev/sda1 rw
+37 22 253:1 / /srv/media rw,relatime shared:3 - xfs /dev/mapper/secret rw
+38 22 0:45 / /mnt/btrfs rw,relatime shared:4 - btrfs /dev/sdc rw,space_cache=v2
+39 22 0:50 / /tank/data rw,xattr shared:5 - zfs tank/data rw
+`),
+	}
+}
+
+func TestResolveDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []string
+		wantErr string
+	}{
+		{name: "disk", specs: []string{"/dev/sda"}, want: []string{"/dev/sda"}},
+		{name: "non rotational disk", specs: []string{"/dev/nvme0n1"}, want: []string{"/dev/nvme0n1"}},
+		{name: "unknown to sysfs", specs: []string{"/dev/fakea"}, want: []string{"/dev/fakea"}},
+		{name: "partition", specs: []string{"/dev/sda1"}, want: []string{"/dev/sda"}},
+		{name: "md array", specs: []string{"/dev/md0"}, want: []string{"/dev/sda", "/dev/sdb"}},
+		{name: "lvm over md", specs: []string{"/dev/mapper/vg-data"}, want: []string{"/dev/sda", "/dev/sdb"}},
+		{name: "uuid", specs: []string{"UUID=5c1f-0a2b"}, want: []string{"/dev/sda"}},
+		{name: "label", specs: []string{"LABEL=media files"}, want: []string{"/dev/sda", "/dev/sdb"}},
+		{name: "mount point", specs: []string{"/srv/media"}, want: []string{"/dev/sda", "/dev/sdb"}},
+		{name: "below a mount point", specs: []string{"/mnt/a/photos/2024"}, want: []string{"/dev/sda"}},
+		{name: "btrfs", specs: []string{"/mnt/btrfs"}, want: []string{"/dev/sdc"}},
+		{name: "deduplicated", specs: []string{"/dev/sdb", "/srv/media", "/dev/sdb1"}, want: []string{"/dev/sdb", "/dev/sda"}},
+		{name: "on an ssd", specs: []string{"/home"}, wantErr: "/home: nvme0n1p1 is not stored on a rotational disk"},
+		{name: "zfs", specs: []string{"/tank/data"}, wantErr: "mount /tank/data of tank/data is not on a block device"},
+		{name: "unknown uuid", specs: []string{"UUID=0000"}, wantErr: `no device with UUID "0000"`},
+		{name: "relative", specs: []string{"sda"}, wantErr: "expected a device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDevices(layeredFS(), tt.specs)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMountPoints(t *testing.T) {
+	fsys := layeredFS()
+	require.Equal(t, []string{"/mnt/a", "/srv/media"}, MountPoints(fsys, "/dev/sda"))
+	require.Equal(t, []string{"/srv/media"}, MountPoints(fsys, "/dev/sdb"))
+	require.Equal(t, []string{"/"}, MountPoints(fsys, "/dev/nvme0n1"))
+	require.Equal(t, []string{"/mnt/btrfs"}, MountPoints(fsys, "/dev/sdc"))
+	require.Empty(t, MountPoints(fsys, "/dev/fakea"))
+}
+
+func TestUnescapeMount(t *testing.T) {
+	require.Equal(t, "/mnt/my data", unescapeMount(`/mnt/my\040data`))
+	require.Equal(t, `/mnt/a\b`, unescapeMount(`/mnt/a\134b`))
+	require.Equal(t, `/mnt/x\0`, unescapeMount(`/mnt/x\0`))
+}
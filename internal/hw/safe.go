@@ -0,0 +1,208 @@
+package hw
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrWouldWake is returned by a SafeHDDControl refusing a command that could
+// spin up a disk in standby or asleep, or whose state cannot be verified.
+var ErrWouldWake = errors.New("command would wake the disk")
+
+// SafeHDDControl wraps an HDDControl and re-verifies the power mode of a disk
+// before every command that could spin it up, refusing the command unless
+// forced. The state is verified through paths that never wake the disk: the
+// runtime PM status in sysfs, the I/O count of a disk known to be asleep, and
+// CHECK POWER MODE unless the quirk of the disk marks it unsafe.
+//
+// It is the only record of the sleeping disks: callers polling GetState, like
+// the daemon, rely on it not to query them.
+type SafeHDDControl struct {
+	inner HDDControl
+	fsys  fs.FS
+	force bool
+
+	mu sync.Mutex
+	// device -> I/O count when it was seen sleeping
+	sleeping map[string]uint64
+	// device -> number of refused commands
+	wouldWake map[string]int
+}
+
+// NewSafeHDDControl returns a SafeHDDControl wrapping inner. When force is
+// set, commands are issued even if they may wake the disk.
+func NewSafeHDDControl(inner HDDControl, force bool) *SafeHDDControl {
+	return NewSafeHDDControlFS(inner, os.DirFS("/"), force)
+}
+
+// NewSafeHDDControlFS is NewSafeHDDControl reading sysfs from fsys.
+func NewSafeHDDControlFS(inner HDDControl, fsys fs.FS, force bool) *SafeHDDControl {
+	return &SafeHDDControl{
+		inner:     inner,
+		fsys:      fsys,
+		force:     force,
+		sleeping:  make(map[string]uint64),
+		wouldWake: make(map[string]int),
+	}
+}
+
+func (s *SafeHDDControl) List() ([]Disk, error)              { return s.inner.List() }
+func (s *SafeHDDControl) IOCount(dev string) (uint64, error) { return s.inner.IOCount(dev) }
+
+// StandbyNow is always allowed: it does not spin up a disk in standby.
+func (s *SafeHDDControl) StandbyNow(dev string) error { return s.inner.StandbyNow(dev) }
+
+// Wake is always allowed: waking the disk is what it is asked for.
+func (s *SafeHDDControl) Wake(dev string) error {
+	s.mu.Lock()
+	delete(s.sleeping, dev)
+	s.mu.Unlock()
+	return s.inner.Wake(dev)
+}
+
+// GetState queries the power mode, except for a disk known to be asleep
+// that has not served I/O since: any query would reset it. The disk is then
+// reported sleeping without counting a refused command, polling it is not
+// an error.
+func (s *SafeHDDControl) GetState(dev string) (string, error) {
+	if s.asleep(dev) {
+		logrus.Debugf("device %s sleeping, not polled until it serves I/O", dev)
+		return DriveStateSleeping, nil
+	}
+	return s.query(dev)
+}
+
+func (s *SafeHDDControl) SetStandbyTimeout(dev string, value int) error {
+	if err := s.check(dev, "set standby timeout"); err != nil {
+		return err
+	}
+	return s.inner.SetStandbyTimeout(dev, value)
+}
+
+func (s *SafeHDDControl) GetAPM(dev string) (int, error) {
+	if err := s.check(dev, "read APM level"); err != nil {
+		return 0, err
+	}
+	return s.inner.GetAPM(dev)
+}
+
+func (s *SafeHDDControl) GetEPC(dev string) (EPCTimers, error) {
+	if err := s.check(dev, "read epc timers"); err != nil {
+		return EPCTimers{}, err
+	}
+	return s.inner.GetEPC(dev)
+}
+
+func (s *SafeHDDControl) SetEPC(dev string, timers EPCTimers) error {
+	if err := s.check(dev, "set epc timers"); err != nil {
+		return err
+	}
+	return s.inner.SetEPC(dev, timers)
+}
+
+func (s *SafeHDDControl) SetAPM(dev string, level int) error {
+	if err := s.check(dev, "set APM level"); err != nil {
+		return err
+	}
+	return s.inner.SetAPM(dev, level)
+}
+
+func (s *SafeHDDControl) Identify(dev string) (Identity, error) {
+	if err := s.check(dev, "identify"); err != nil {
+		return Identity{}, err
+	}
+	return s.inner.Identify(dev)
+}
+
+// WouldWake returns the number of commands refused per device.
+func (s *SafeHDDControl) WouldWake() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.wouldWake)
+}
+
+// check verifies dev is spinning before command is issued on it.
+func (s *SafeHDDControl) check(dev, command string) error {
+	state, err := s.verify(dev)
+	switch {
+	case errors.Is(err, ErrStateUnavailable):
+		state = "unverifiable"
+	case err != nil:
+		return err
+	case !IsSpunDown(state):
+		return nil
+	}
+	if s.force {
+		logrus.Warnf("forcing %s on %s (state=%s), it may spin up", command, dev, state)
+		return nil
+	}
+	s.refused(dev, command, state)
+	return fmt.Errorf("%s on %s (state=%s): %w", command, dev, state, ErrWouldWake)
+}
+
+// verify returns the current power mode of dev without waking it.
+func (s *SafeHDDControl) verify(dev string) (string, error) {
+	status, err := fs.ReadFile(s.fsys, path.Join("sys/block", path.Base(dev), "device/power/runtime_status"))
+	if err == nil && strings.TrimSpace(string(status)) == "suspended" {
+		// runtime PM stopped the disk, any command resumes it
+		return DriveStateStandby, nil
+	}
+	if s.asleep(dev) {
+		return DriveStateSleeping, nil
+	}
+	return s.query(dev)
+}
+
+// query issues CHECK POWER MODE and records the I/O count of a disk falling
+// asleep, it must not be queried again until it has served I/O.
+func (s *SafeHDDControl) query(dev string) (string, error) {
+	state, err := s.inner.GetState(dev)
+	if err != nil || state != DriveStateSleeping {
+		return state, err
+	}
+	count, err := s.inner.IOCount(dev)
+	if err != nil {
+		logrus.Debugf("get device io count(%s) error: %v", dev, err)
+		return state, nil
+	}
+	s.mu.Lock()
+	s.sleeping[dev] = count
+	s.mu.Unlock()
+	return state, nil
+}
+
+// asleep tells whether dev was seen sleeping and has not served I/O since,
+// which would imply the kernel reset and woke it.
+func (s *SafeHDDControl) asleep(dev string) bool {
+	s.mu.Lock()
+	asleep, ok := s.sleeping[dev]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	count, err := s.inner.IOCount(dev)
+	if err == nil && count == asleep {
+		return true
+	}
+	s.mu.Lock()
+	delete(s.sleeping, dev)
+	s.mu.Unlock()
+	return false
+}
+
+// refused counts a command that would have woken dev.
+func (s *SafeHDDControl) refused(dev, command, state string) {
+	s.mu.Lock()
+	s.wouldWake[dev]++
+	n := s.wouldWake[dev]
+	s.mu.Unlock()
+	logrus.Infof("refused %s on %s (state=%s): would have woken it (%d so far)", command, dev, state, n)
+}
@@ -0,0 +1,174 @@
+package hw
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeHDDControl_check(t *testing.T) {
+	tests := []struct {
+		name          string
+		fsys          fstest.MapFS
+		force         bool
+		setup         func(*MockHDDControl)
+		expectErrorIs error
+		expectCalled  bool
+		expectWoken   int
+	}{
+		{
+			name: "active disk",
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(DriveStateIdleB, nil).Once()
+			},
+			expectCalled: true,
+		},
+		{
+			name: "standby disk is refused",
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(DriveStateStandbyZ, nil).Once()
+			},
+			expectErrorIs: ErrWouldWake,
+			expectWoken:   1,
+		},
+		{
+			name: "forced on standby disk",
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return(DriveStateStandby, nil).Once()
+			},
+			force:        true,
+			expectCalled: true,
+		},
+		{
+			name: "runtime suspended disk is not queried",
+			fsys: fstest.MapFS{
+				"sys/block/sda/device/power/runtime_status": {Data: []byte("suspended\n")},
+			},
+			expectErrorIs: ErrWouldWake,
+			expectWoken:   1,
+		},
+		{
+			name: "unverifiable state is refused",
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return("", ErrStateUnavailable).Once()
+			},
+			expectErrorIs: ErrWouldWake,
+			expectWoken:   1,
+		},
+		{
+			name: "query error is returned",
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().GetState("/dev/sda").Return("", os.ErrNotExist).Once()
+			},
+			expectErrorIs: os.ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := NewMockHDDControl(t)
+			if tt.setup != nil {
+				tt.setup(inner)
+			}
+			if tt.expectCalled {
+				inner.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+			}
+
+			s := NewSafeHDDControlFS(inner, tt.fsys, tt.force)
+			err := s.SetStandbyTimeout("/dev/sda", 120)
+			if tt.expectErrorIs != nil {
+				require.ErrorIs(t, err, tt.expectErrorIs)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.expectWoken, s.WouldWake()["/dev/sda"])
+		})
+	}
+}
+
+func TestSafeHDDControl_Identify(t *testing.T) {
+	identity := Identity{Serial: "WD-123", EPC: Feature{Supported: true}}
+	tests := []struct {
+		name          string
+		state         string
+		stateErr      error
+		identifyErr   error
+		expectErrorIs error
+		expectCalled  bool
+	}{
+		{name: "spinning disk is identified", state: DriveStateActive, expectCalled: true},
+		{name: "identify error is returned", state: DriveStateIdle, identifyErr: os.ErrPermission, expectCalled: true, expectErrorIs: os.ErrPermission},
+		{name: "standby disk is not identified", state: DriveStateStandby, expectErrorIs: ErrWouldWake},
+		{name: "unverifiable disk is not identified", stateErr: ErrStateUnavailable, expectErrorIs: ErrWouldWake},
+		{name: "query error is returned", stateErr: os.ErrNotExist, expectErrorIs: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := NewMockHDDControl(t)
+			inner.EXPECT().GetState("/dev/sda").Return(tt.state, tt.stateErr).Once()
+			if tt.expectCalled {
+				inner.EXPECT().Identify("/dev/sda").Return(identity, tt.identifyErr).Once()
+			}
+
+			s := NewSafeHDDControlFS(inner, fstest.MapFS{}, false)
+			id, err := s.Identify("/dev/sda")
+			if tt.expectErrorIs != nil {
+				require.ErrorIs(t, err, tt.expectErrorIs)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, identity, id)
+		})
+	}
+}
+
+func TestSafeHDDControl_Sleeping(t *testing.T) {
+	inner := NewMockHDDControl(t)
+	s := NewSafeHDDControlFS(inner, fstest.MapFS{}, false)
+
+	inner.EXPECT().GetState("/dev/sda").Return(DriveStateSleeping, nil).Once()
+	inner.EXPECT().IOCount("/dev/sda").Return(7, nil).Once()
+	state, err := s.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateSleeping, state)
+
+	// no I/O since: neither queried nor commanded
+	inner.EXPECT().IOCount("/dev/sda").Return(7, nil).Times(4)
+	state, err = s.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateSleeping, state)
+	require.ErrorIs(t, s.SetAPM("/dev/sda", 127), ErrWouldWake)
+	_, err = s.GetAPM("/dev/sda")
+	require.ErrorIs(t, err, ErrWouldWake)
+	_, err = s.GetEPC("/dev/sda")
+	require.ErrorIs(t, err, ErrWouldWake)
+	// polling it is not a refused command
+	require.Equal(t, map[string]int{"/dev/sda": 3}, s.WouldWake())
+
+	// the kernel served I/O, so it reset the disk
+	inner.EXPECT().IOCount("/dev/sda").Return(9, nil).Once()
+	inner.EXPECT().GetState("/dev/sda").Return(DriveStateActive, nil).Once()
+	inner.EXPECT().SetAPM("/dev/sda", 127).Return(nil).Once()
+	require.NoError(t, s.SetAPM("/dev/sda", 127))
+
+	// commands not waking disks go through
+	inner.EXPECT().StandbyNow("/dev/sda").Return(errors.New("boom")).Once()
+	require.EqualError(t, s.StandbyNow("/dev/sda"), "boom")
+
+	// waking a sleeping disk is not refused, and it is queried again since
+	// the command issued no I/O
+	inner.EXPECT().GetState("/dev/sda").Return(DriveStateSleeping, nil).Once()
+	inner.EXPECT().IOCount("/dev/sda").Return(9, nil).Once()
+	_, err = s.GetState("/dev/sda")
+	require.NoError(t, err)
+	inner.EXPECT().Wake("/dev/sda").Return(nil).Once()
+	require.NoError(t, s.Wake("/dev/sda"))
+	inner.EXPECT().GetState("/dev/sda").Return(DriveStateActive, nil).Once()
+	state, err = s.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+	require.Equal(t, map[string]int{"/dev/sda": 3}, s.WouldWake())
+}
@@ -0,0 +1,206 @@
+package hw
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Transport constants identify how a block device is attached.
+const (
+	// TransportATA covers drives reached through libata or a SCSI/ATA
+	// translation layer, which understand hdparm commands.
+	TransportATA = "ata"
+	// TransportSAS is a native SCSI drive on a SAS fabric.
+	TransportSAS = "sas"
+	// TransportSCSI is any other native SCSI drive.
+	TransportSCSI = "scsi"
+)
+
+// scsiHDDControl implements HDDControl for native SCSI drives with sdparm:
+// REQUEST SENSE for the power condition, the Power Condition mode page for
+// the standby timer and START STOP UNIT to spin down. The idle timers of the
+// mode page are only written by SetEPC.
+type scsiHDDControl struct {
+	fsys fs.FS
+}
+
+func (s scsiHDDControl) List() ([]Disk, error) { return defaultHDDControl{fsys: s.fsys}.List() }
+func (s scsiHDDControl) IOCount(dev string) (uint64, error) {
+	return defaultHDDControl{fsys: s.fsys}.IOCount(dev)
+}
+
+func (s scsiHDDControl) GetState(dev string) (string, error) {
+	out, err := exec.Command(sdparmPath(), "--command=sense", dev).CombinedOutput()
+	return s.parseSenseState(string(out), err)
+}
+
+// parseSenseState parses the output of `sdparm --command=sense`, which decodes
+// the sense data returned by REQUEST SENSE. A low power condition is reported
+// as an additional sense like "Standby condition activated by timer"; no such
+// condition means the drive is active.
+func (scsiHDDControl) parseSenseState(output string, cmdErr error) (string, error) {
+	output = strings.TrimSpace(output)
+	if cmdErr != nil {
+		if strings.Contains(output, "No such file or directory") {
+			return "", os.ErrNotExist
+		}
+		return "", fmt.Errorf("sdparm command error(%w): %s", cmdErr, output)
+	}
+	if output == "" {
+		return "", fmt.Errorf("malformed sdparm output: %v", output)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader([]byte(output)))
+	for scanner.Scan() {
+		line := strings.ToLower(scanner.Text())
+		switch {
+		case strings.Contains(line, "low power condition on"):
+			return DriveStateIdle, nil
+		case !strings.Contains(line, "condition activated by"):
+			continue
+		case strings.Contains(line, "standby_y"):
+			return DriveStateStandbyY, nil
+		case strings.Contains(line, "standby"):
+			return DriveStateStandby, nil
+		case strings.Contains(line, "idle_b"):
+			return DriveStateIdleB, nil
+		case strings.Contains(line, "idle_c"):
+			return DriveStateIdleC, nil
+		case strings.Contains(line, "idle"):
+			return DriveStateIdleA, nil
+		}
+	}
+	return DriveStateActive, nil
+}
+
+// SetStandbyTimeout sets the standby_z condition timer of the Power Condition
+// mode page, and only it: like hdparm -S it leaves the idle and standby_y
+// conditions alone, and the change is not saved across power cycles.
+func (s scsiHDDControl) SetStandbyTimeout(dev string, value int) error {
+	args := s.standbyArgs(value)
+	logrus.Debugf("use sdparm to set standby timeout %d on %s: %v", value, dev, args)
+	out, err := exec.Command(sdparmPath(), append(args, dev)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set standby timeout on %s: %w\nOutput: %s", dev, err, string(out))
+	}
+	return nil
+}
+
+// standbyArgs converts a hdparm -S value into sdparm arguments. The standby
+// condition timer (SCT) counts in 100 milliseconds.
+func (scsiHDDControl) standbyArgs(value int) []string {
+	timeout := StandbyDuration(value)
+	if timeout <= 0 {
+		return []string{"--set=STANDBY=0"}
+	}
+	return []string{fmt.Sprintf("--set=STANDBY=1,SCT=%d", timeout/(100*time.Millisecond))}
+}
+
+// StandbyNow spins the drive down with START STOP UNIT.
+func (scsiHDDControl) StandbyNow(dev string) error {
+	logrus.Debugf("use sdparm to stop %s", dev)
+	out, err := exec.Command(sdparmPath(), "--command=stop", dev).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to stop %s: %w\nOutput: %s", dev, err, string(out))
+	}
+	return nil
+}
+
+// Wake spins the drive up with START STOP UNIT.
+func (scsiHDDControl) Wake(dev string) error {
+	logrus.Debugf("use sdparm to start %s", dev)
+	out, err := exec.Command(sdparmPath(), "--command=start", dev).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w\nOutput: %s", dev, err, string(out))
+	}
+	return nil
+}
+
+// GetAPM fails: APM is an ATA feature, SCSI drives expose their power
+// conditions through the mode page instead.
+func (scsiHDDControl) GetAPM(dev string) (int, error) {
+	return 0, fmt.Errorf("%s: %w", dev, ErrAPMUnsupported)
+}
+
+// SetAPM fails like GetAPM.
+func (scsiHDDControl) SetAPM(dev string, _ int) error {
+	return fmt.Errorf("%s: %w", dev, ErrAPMUnsupported)
+}
+
+// sdparmPath returns the path to the sdparm binary. It checks the SDPARM_PATH
+// environment variable and falls back to /usr/bin/sdparm when not set.
+func sdparmPath() string {
+	if p, ok := os.LookupEnv("SDPARM_PATH"); ok && p != "" {
+		return p
+	}
+	return "/usr/bin/sdparm"
+}
+
+// DetectTransport tells how dev is attached by looking at its SCSI device in
+// sysfs: libata and SAT bridges expose the ATA Information VPD page or report
+// the "ATA" vendor, SAS drives have a SAS address.
+func DetectTransport(fsys fs.FS, dev string) string {
+	devDir := path.Join("sys/block", path.Base(dev), "device")
+	if _, err := fs.Stat(fsys, path.Join(devDir, "vpd_pg89")); err == nil {
+		return TransportATA
+	}
+	if vendor, err := fs.ReadFile(fsys, path.Join(devDir, "vendor")); err == nil && strings.TrimSpace(string(vendor)) == "ATA" {
+		return TransportATA
+	}
+	if _, err := fs.Stat(fsys, path.Join(devDir, "sas_address")); err == nil {
+		return TransportSAS
+	}
+	if _, err := fs.Stat(fsys, path.Join(devDir, "scsi_level")); err == nil {
+		return TransportSCSI
+	}
+	// not a SCSI device or unknown layout: keep the historical hdparm behavior
+	return TransportATA
+}
+
+// autoHDDControl dispatches every command to the ATA or SCSI backend
+// according to the transport of the device and the quirk of its USB bridge.
+type autoHDDControl struct {
+	fsys   fs.FS
+	quirks []Quirk
+	ata    HDDControl
+	scsi   HDDControl
+}
+
+func (a autoHDDControl) backend(dev string) HDDControl {
+	if q := lookupQuirk(a.fsys, a.quirks, dev); q != nil && q.CommandSet == CommandSetSCSI {
+		return a.scsi
+	}
+	if DetectTransport(a.fsys, dev) == TransportATA {
+		return a.ata
+	}
+	return a.scsi
+}
+
+func (a autoHDDControl) List() ([]Disk, error)                { return a.ata.List() }
+func (a autoHDDControl) GetState(dev string) (string, error)  { return a.backend(dev).GetState(dev) }
+func (a autoHDDControl) IOCount(dev string) (uint64, error)   { return a.backend(dev).IOCount(dev) }
+func (a autoHDDControl) StandbyNow(dev string) error          { return a.backend(dev).StandbyNow(dev) }
+func (a autoHDDControl) Wake(dev string) error                { return a.backend(dev).Wake(dev) }
+func (a autoHDDControl) GetEPC(dev string) (EPCTimers, error) { return a.backend(dev).GetEPC(dev) }
+func (a autoHDDControl) GetAPM(dev string) (int, error)       { return a.backend(dev).GetAPM(dev) }
+func (a autoHDDControl) Identify(dev string) (Identity, error) {
+	return a.backend(dev).Identify(dev)
+}
+func (a autoHDDControl) SetAPM(dev string, level int) error {
+	return a.backend(dev).SetAPM(dev, level)
+}
+func (a autoHDDControl) SetEPC(dev string, timers EPCTimers) error {
+	return a.backend(dev).SetEPC(dev, timers)
+}
+func (a autoHDDControl) SetStandbyTimeout(dev string, value int) error {
+	return a.backend(dev).SetStandbyTimeout(dev, value)
+}
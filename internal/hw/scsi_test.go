@@ -0,0 +1,161 @@
+package hw
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSenseState(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		cmdErr         error
+		expectState    string
+		expectErrorMsg string
+	}{
+		{
+			name:        "no sense is active",
+			output:      "    /dev/sdb: SEAGATE   ST4000NM0023      0004\n",
+			expectState: DriveStateActive,
+		},
+		{
+			name: "no additional sense information",
+			output: `    /dev/sdb: SEAGATE   ST4000NM0023      0004
+Additional sense: No additional sense information
+`,
+			expectState: DriveStateActive,
+		},
+		{
+			name: "standby by timer",
+			output: `    /dev/sdb: SEAGATE   ST4000NM0023      0004
+Additional sense: Standby condition activated by timer
+`,
+			expectState: DriveStateStandby,
+		},
+		{
+			name: "standby_y by command",
+			output: `    /dev/sdb: HGST      HUS726040AL5210   A7J0
+Additional sense: Standby_y condition activated by command
+`,
+			expectState: DriveStateStandbyY,
+		},
+		{
+			name: "idle_b by timer",
+			output: `    /dev/sdb: SEAGATE   ST4000NM0023      0004
+Additional sense: Idle_b condition activated by timer
+`,
+			expectState: DriveStateIdleB,
+		},
+		{
+			name: "idle by command",
+			output: `    /dev/sdb: SEAGATE   ST4000NM0023      0004
+Additional sense: Idle condition activated by command
+`,
+			expectState: DriveStateIdleA,
+		},
+		{
+			name:           "missing device",
+			output:         "open error: /dev/sdz [read only]: No such file or directory\n",
+			cmdErr:         errors.New("exit status 15"),
+			expectErrorMsg: os.ErrNotExist.Error(),
+		},
+		{
+			name:           "command failure",
+			output:         "request sense command failed\n",
+			cmdErr:         errors.New("exit status 5"),
+			expectErrorMsg: "sdparm command error",
+		},
+		{
+			name:           "empty output",
+			output:         "",
+			expectErrorMsg: "malformed sdparm output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := scsiHDDControl{}.parseSenseState(tt.output, tt.cmdErr)
+			if tt.expectErrorMsg != "" {
+				require.ErrorContains(t, err, tt.expectErrorMsg)
+				require.Equal(t, "", state)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectState, state)
+		})
+	}
+}
+
+func TestSCSIStandbyArgs(t *testing.T) {
+	tests := []struct {
+		value int
+		want  []string
+	}{
+		{value: 0, want: []string{"--set=STANDBY=0"}},
+		{value: 120, want: []string{"--set=STANDBY=1,SCT=6000"}},
+		{value: 242, want: []string{"--set=STANDBY=1,SCT=36000"}},
+		{value: 253, want: []string{"--set=STANDBY=0"}},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, scsiHDDControl{}.standbyArgs(tt.value), "value %d", tt.value)
+	}
+}
+
+func TestDetectTransport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sys/block/sda/device/vpd_pg89":    &fstest.MapFile{Data: []byte{}},
+		"sys/block/sda/device/scsi_level":  &fstest.MapFile{Data: []byte("8\n")},
+		"sys/block/sdb/device/vendor":      &fstest.MapFile{Data: []byte("ATA     \n")},
+		"sys/block/sdb/device/scsi_level":  &fstest.MapFile{Data: []byte("6\n")},
+		"sys/block/sdc/device/vendor":      &fstest.MapFile{Data: []byte("SEAGATE \n")},
+		"sys/block/sdc/device/sas_address": &fstest.MapFile{Data: []byte("0x5000c500a1b2c3d4\n")},
+		"sys/block/sdc/device/scsi_level":  &fstest.MapFile{Data: []byte("7\n")},
+		"sys/block/sdd/device/vendor":      &fstest.MapFile{Data: []byte("IBM     \n")},
+		"sys/block/sdd/device/scsi_level":  &fstest.MapFile{Data: []byte("4\n")},
+	}
+
+	tests := []struct {
+		dev  string
+		want string
+	}{
+		{dev: "/dev/sda", want: TransportATA},
+		{dev: "/dev/sdb", want: TransportATA},
+		{dev: "/dev/sdc", want: TransportSAS},
+		{dev: "/dev/sdd", want: TransportSCSI},
+		{dev: "/dev/sde", want: TransportATA},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, DetectTransport(fsys, tt.dev), tt.dev)
+	}
+}
+
+func TestAutoHDDControl_Dispatch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sys/block/sda/device/vpd_pg89":    &fstest.MapFile{Data: []byte{}},
+		"sys/block/sdb/device/sas_address": &fstest.MapFile{Data: []byte("0x5000c500a1b2c3d4\n")},
+	}
+	ata := NewMockHDDControl(t)
+	scsi := NewMockHDDControl(t)
+	auto := autoHDDControl{fsys: fsys, ata: ata, scsi: scsi}
+
+	ata.EXPECT().GetState("/dev/sda").Return(DriveStateActive, nil).Once()
+	ata.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+	scsi.EXPECT().GetState("/dev/sdb").Return(DriveStateStandby, nil).Once()
+	scsi.EXPECT().StandbyNow("/dev/sdb").Return(nil).Once()
+
+	state, err := auto.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+	require.NoError(t, auto.SetStandbyTimeout("/dev/sda", 120))
+
+	state, err = auto.GetState("/dev/sdb")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateStandby, state)
+	require.NoError(t, auto.StandbyNow("/dev/sdb"))
+}
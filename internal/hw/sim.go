@@ -0,0 +1,340 @@
+package hw
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SimFault is an error injected into simulated drive commands.
+type SimFault string
+
+const (
+	// SimFaultTimeout fails commands as if hdparm timed out.
+	SimFaultTimeout SimFault = "timeout"
+	// SimFaultNotExist fails commands as if the device node was missing.
+	SimFaultNotExist SimFault = "enoent"
+	// SimFaultGarbage makes GetState see unparsable hdparm output.
+	SimFaultGarbage SimFault = "garbage"
+)
+
+// SimStats accumulates the simulated power history of a drive.
+type SimStats struct {
+	SpinUps int
+	Active  time.Duration
+	Standby time.Duration
+}
+
+// SimHDDControl is a stateful HDDControl that models drives in memory: the
+// standby timer counts down on the supplied clock and simulated I/O wakes the
+// drive. It is safe for concurrent use.
+type SimHDDControl struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	drives map[string]*simDrive
+}
+
+type simDrive struct {
+	state string
+	// firmware standby timer, 0 when disabled
+	timer time.Duration
+	// last time the drive was accessed or its timer restarted
+	lastActivity time.Time
+	// time up to which stats have been accumulated
+	accounted time.Time
+	ioCount   uint64
+	stats     SimStats
+	removed   bool
+	fault     SimFault
+	// remaining faulty commands, negative for unlimited
+	faultLeft int
+}
+
+// NewSimHDDControl returns a SimHDDControl with the given devices, all active
+// with a disabled standby timer.
+func NewSimHDDControl(now func() time.Time, devs ...string) *SimHDDControl {
+	s := &SimHDDControl{now: now, drives: make(map[string]*simDrive)}
+	t := now()
+	for _, dev := range devs {
+		s.drives[dev] = &simDrive{state: DriveStateActive, lastActivity: t, accounted: t}
+	}
+	return s
+}
+
+func (s *SimHDDControl) List() ([]Disk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devs := make([]Disk, 0, len(s.drives))
+	for dev, d := range s.drives {
+		if !d.removed {
+			devs = append(devs, Disk{Path: dev, Transport: TransportATA})
+		}
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].Path < devs[j].Path })
+	return devs, nil
+}
+
+func (s *SimHDDControl) GetState(dev string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return "", err
+	}
+	if err := d.injected(dev, true); err != nil {
+		return "", err
+	}
+	return d.state, nil
+}
+
+// SetStandbyTimeout arms the drive timer. Like the real command it does not
+// spin up a drive in standby, but restarts the idle countdown of an active one.
+func (s *SimHDDControl) SetStandbyTimeout(dev string, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return err
+	}
+	if err := d.injected(dev, false); err != nil {
+		return err
+	}
+	d.timer = StandbyDuration(value)
+	if d.state == DriveStateActive {
+		d.lastActivity = s.now()
+	}
+	return nil
+}
+
+// StandbyNow spins an active drive down immediately.
+func (s *SimHDDControl) StandbyNow(dev string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return err
+	}
+	if err := d.injected(dev, false); err != nil {
+		return err
+	}
+	d.state = DriveStateStandby
+	return nil
+}
+
+// Wake spins a drive in standby up. Unlike Access it is not an I/O: the idle
+// countdown and the I/O count are left alone.
+func (s *SimHDDControl) Wake(dev string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return err
+	}
+	if err := d.injected(dev, false); err != nil {
+		return err
+	}
+	if d.state != DriveStateActive {
+		d.state = DriveStateActive
+		d.stats.SpinUps++
+		d.lastActivity = s.now()
+	}
+	return nil
+}
+
+func (s *SimHDDControl) IOCount(dev string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return 0, err
+	}
+	return d.ioCount, nil
+}
+
+// GetEPC reports simulated drives as lacking EPC, they only model the legacy
+// standby timer.
+func (s *SimHDDControl) GetEPC(dev string) (EPCTimers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.drive(dev); err != nil {
+		return EPCTimers{}, err
+	}
+	return EPCTimers{}, fmt.Errorf("%s: %w", dev, ErrEPCUnsupported)
+}
+
+// SetEPC fails like GetEPC.
+func (s *SimHDDControl) SetEPC(dev string, _ EPCTimers) error {
+	_, err := s.GetEPC(dev)
+	return err
+}
+
+// GetAPM reports simulated drives as lacking APM.
+func (s *SimHDDControl) GetAPM(dev string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.drive(dev); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s: %w", dev, ErrAPMUnsupported)
+}
+
+// SetAPM fails like GetAPM.
+func (s *SimHDDControl) SetAPM(dev string, _ int) error {
+	_, err := s.GetAPM(dev)
+	return err
+}
+
+// Identify reports simulated drives with their device name as serial and
+// only the Power Management feature set their standby timer requires.
+func (s *SimHDDControl) Identify(dev string) (Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.drive(dev); err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Model:    "Simulated HDD",
+		Serial:   path.Base(dev),
+		Firmware: "sim",
+		PM:       Feature{Supported: true, Enabled: true},
+	}, nil
+}
+
+// Access simulates an I/O request on dev at the current clock time, spinning
+// the drive up if it is in standby.
+func (s *SimHDDControl) Access(dev string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	if d.state != DriveStateActive {
+		d.state = DriveStateActive
+		d.stats.SpinUps++
+	}
+	d.lastActivity = now
+	d.ioCount++
+	return nil
+}
+
+// Remove unplugs dev: it disappears from List and every command fails with
+// os.ErrNotExist until it is added back.
+func (s *SimHDDControl) Remove(dev string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.drives[dev]; ok {
+		d.advance(s.now())
+		d.removed = true
+	}
+}
+
+// Add plugs dev in, active with a disabled standby timer as after a power
+// cycle. Stats of a previously removed drive are kept.
+func (s *SimHDDControl) Add(dev string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	d, ok := s.drives[dev]
+	if !ok {
+		s.drives[dev] = &simDrive{state: DriveStateActive, lastActivity: now, accounted: now}
+		return
+	}
+	// time spent unplugged is neither active nor standby
+	if !d.removed {
+		d.advance(now)
+	}
+	if d.removed || d.state != DriveStateActive {
+		d.stats.SpinUps++
+	}
+	*d = simDrive{state: DriveStateActive, lastActivity: now, accounted: now, ioCount: d.ioCount, stats: d.stats}
+}
+
+// InjectFault makes the next times commands on dev fail with fault; times <= 0
+// keeps failing until ClearFault. SimFaultGarbage only affects GetState.
+func (s *SimHDDControl) InjectFault(dev string, fault SimFault, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.drives[dev]; ok {
+		d.fault = fault
+		d.faultLeft = times
+		if times <= 0 {
+			d.faultLeft = -1
+		}
+	}
+}
+
+// ClearFault stops injecting errors into commands on dev.
+func (s *SimHDDControl) ClearFault(dev string) {
+	s.InjectFault(dev, "", 0)
+}
+
+// Stats returns the accumulated power history of dev up to the current clock time.
+func (s *SimHDDControl) Stats(dev string) (SimStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.drive(dev)
+	if err != nil {
+		return SimStats{}, err
+	}
+	return d.stats, nil
+}
+
+// drive returns dev brought up to date with the current clock time.
+// Callers must hold s.mu.
+func (s *SimHDDControl) drive(dev string) (*simDrive, error) {
+	d, ok := s.drives[dev]
+	if !ok || d.removed {
+		return nil, fmt.Errorf("simulated drive %s: %w", dev, os.ErrNotExist)
+	}
+	d.advance(s.now())
+	return d, nil
+}
+
+// injected consumes a pending fault of the drive and returns its error.
+// queryState tells whether the command reads hdparm output.
+func (d *simDrive) injected(dev string, queryState bool) error {
+	if d.fault == "" || d.faultLeft == 0 || (d.fault == SimFaultGarbage && !queryState) {
+		return nil
+	}
+	if d.faultLeft > 0 {
+		d.faultLeft--
+	}
+	switch d.fault {
+	case SimFaultTimeout:
+		return fmt.Errorf("simulated command on %s: %w", dev, os.ErrDeadlineExceeded)
+	case SimFaultNotExist:
+		return os.ErrNotExist
+	case SimFaultGarbage:
+		_, err := defaultHDDControl{}.parseHDParmState("\x00\x13garbage", nil)
+		return err
+	default:
+		return fmt.Errorf("simulated fault %q on %s", d.fault, dev)
+	}
+}
+
+// advance runs the standby timer up to now and accumulates time in state.
+func (d *simDrive) advance(now time.Time) {
+	if !now.After(d.accounted) {
+		return
+	}
+	if d.state == DriveStateActive && d.timer > 0 {
+		if spinDown := d.lastActivity.Add(d.timer); !spinDown.After(now) {
+			if spinDown.After(d.accounted) {
+				d.stats.Active += spinDown.Sub(d.accounted)
+				d.accounted = spinDown
+			}
+			d.state = DriveStateStandby
+		}
+	}
+	if d.state == DriveStateActive {
+		d.stats.Active += now.Sub(d.accounted)
+	} else {
+		d.stats.Standby += now.Sub(d.accounted)
+	}
+	d.accounted = now
+}
@@ -0,0 +1,166 @@
+package hw
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimHDDControl_StandbyTimer(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := NewSimHDDControl(func() time.Time { return now }, "/dev/sda")
+
+	// timer disabled: stays active
+	now = now.Add(time.Hour)
+	state, err := sim.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+
+	// armed timer counts down from the command
+	require.NoError(t, sim.SetStandbyTimeout("/dev/sda", 120))
+	now = now.Add(9 * time.Minute)
+	state, err = sim.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+
+	now = now.Add(5 * time.Minute)
+	state, err = sim.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateStandby, state)
+
+	// I/O wakes the drive
+	require.NoError(t, sim.Access("/dev/sda"))
+	state, err = sim.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+	count, err := sim.IOCount("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), count)
+
+	stats, err := sim.Stats("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, SimStats{SpinUps: 1, Active: time.Hour + 10*time.Minute, Standby: 4 * time.Minute}, stats)
+}
+
+func TestSimHDDControl_Wake(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := NewSimHDDControl(func() time.Time { return now }, "/dev/sda")
+
+	require.NoError(t, sim.StandbyNow("/dev/sda"))
+	now = now.Add(time.Hour)
+	require.NoError(t, sim.Wake("/dev/sda"))
+	// already spinning: not another spin-up
+	require.NoError(t, sim.Wake("/dev/sda"))
+	state, err := sim.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+
+	// not an I/O
+	count, err := sim.IOCount("/dev/sda")
+	require.NoError(t, err)
+	require.Zero(t, count)
+	stats, err := sim.Stats("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, SimStats{SpinUps: 1, Standby: time.Hour}, stats)
+}
+
+func TestSimHDDControl_UnknownDevice(t *testing.T) {
+	sim := NewSimHDDControl(time.Now, "/dev/sda")
+	_, err := sim.GetState("/dev/sdz")
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	devs, err := sim.List()
+	require.NoError(t, err)
+	require.Equal(t, []Disk{{Path: "/dev/sda", Transport: TransportATA}}, devs)
+}
+
+func TestSimHDDControl_InjectFault(t *testing.T) {
+	tests := []struct {
+		name       string
+		fault      SimFault
+		times      int
+		wantGetErr func(error) bool
+		wantSetErr func(error) bool
+	}{
+		{
+			name:       "timeout",
+			fault:      SimFaultTimeout,
+			times:      1,
+			wantGetErr: func(err error) bool { return errors.Is(err, os.ErrDeadlineExceeded) },
+		},
+		{
+			name:       "enoent",
+			fault:      SimFaultNotExist,
+			times:      1,
+			wantGetErr: func(err error) bool { return errors.Is(err, os.ErrNotExist) },
+		},
+		{
+			name:  "garbage output only hits state queries",
+			fault: SimFaultGarbage,
+			times: 1,
+			wantGetErr: func(err error) bool {
+				return err != nil && strings.Contains(err.Error(), "malformed hdparm output")
+			},
+		},
+		{
+			name:       "unlimited until cleared",
+			fault:      SimFaultTimeout,
+			times:      0,
+			wantGetErr: func(err error) bool { return errors.Is(err, os.ErrDeadlineExceeded) },
+			wantSetErr: func(err error) bool { return errors.Is(err, os.ErrDeadlineExceeded) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := NewSimHDDControl(time.Now, "/dev/sda")
+			sim.InjectFault("/dev/sda", tt.fault, tt.times)
+
+			_, err := sim.GetState("/dev/sda")
+			require.True(t, tt.wantGetErr(err), "unexpected error: %v", err)
+
+			err = sim.SetStandbyTimeout("/dev/sda", 120)
+			if tt.wantSetErr != nil {
+				require.True(t, tt.wantSetErr(err), "unexpected error: %v", err)
+				sim.ClearFault("/dev/sda")
+			} else {
+				require.NoError(t, err)
+			}
+
+			state, err := sim.GetState("/dev/sda")
+			require.NoError(t, err)
+			require.Equal(t, DriveStateActive, state)
+		})
+	}
+}
+
+func TestSimHDDControl_RemoveAndAdd(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := NewSimHDDControl(func() time.Time { return now }, "/dev/sda", "/dev/sdb")
+	require.NoError(t, sim.SetStandbyTimeout("/dev/sda", 12))
+
+	now = now.Add(time.Minute)
+	sim.Remove("/dev/sda")
+	devs, err := sim.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"/dev/sdb"}, DiskPaths(devs))
+	_, err = sim.GetState("/dev/sda")
+	require.True(t, errors.Is(err, os.ErrNotExist))
+	require.True(t, errors.Is(sim.SetStandbyTimeout("/dev/sda", 0), os.ErrNotExist))
+
+	// re-plugged drive spins up with its timer reset
+	now = now.Add(time.Hour)
+	sim.Add("/dev/sda")
+	now = now.Add(time.Hour)
+	state, err := sim.GetState("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, DriveStateActive, state)
+
+	stats, err := sim.Stats("/dev/sda")
+	require.NoError(t, err)
+	require.Equal(t, SimStats{SpinUps: 1, Active: time.Minute + time.Hour}, stats)
+}
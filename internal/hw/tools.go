@@ -0,0 +1,54 @@
+package hw
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// Tool is an external program the backends drive the disks with.
+type Tool struct {
+	Name string
+	// Env is the environment variable overriding Path
+	Env  string
+	Path string
+	// VersionArgs make the tool print its version
+	VersionArgs []string
+}
+
+// Tools returns the programs used by the backends at the paths they are run
+// from: hdparm for ATA drives, sdparm for SCSI drives and openSeaChest for
+// the EPC timers of ATA drives.
+func Tools() []Tool {
+	return []Tool{
+		{Name: "hdparm", Env: "HDPARM_PATH", Path: hdparmPath(), VersionArgs: []string{"-V"}},
+		{Name: "sdparm", Env: "SDPARM_PATH", Path: sdparmPath(), VersionArgs: []string{"--version"}},
+		{Name: "openSeaChest_PowerControl", Env: "SEACHEST_PATH", Path: seaChestPath(), VersionArgs: []string{"--version"}},
+	}
+}
+
+// Version runs the tool and returns the version it prints.
+func (t Tool) Version() (string, error) {
+	out, err := exec.Command(t.Path, t.VersionArgs...).CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return parseVersion(string(out)), nil
+}
+
+// parseVersion returns the first line of output mentioning a version, like
+// "openSeaChest_PowerControl Version: 3.5.1" in a banner, or its first line
+// like "hdparm v9.65".
+func parseVersion(output string) string {
+	var first string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.Contains(strings.ToLower(line), "version"):
+			return line
+		case first == "":
+			first = line
+		}
+	}
+	return first
+}
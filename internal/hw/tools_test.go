@@ -0,0 +1,34 @@
+package hw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		expect string
+	}{
+		{name: "hdparm", output: "hdparm v9.65\n", expect: "hdparm v9.65"},
+		{name: "sdparm", output: "version: 1.12 20210421\n", expect: "version: 1.12 20210421"},
+		{
+			name: "openSeaChest banner",
+			output: `==========================================================================================
+ openSeaChest_PowerControl - openSeaChest drive utilities - NVMe Enabled
+ Copyright (c) 2014-2023 Seagate Technology LLC and/or its Affiliates, All Rights Reserved
+ openSeaChest_PowerControl Version: 3.5.1-6_2_0 X86_64
+==========================================================================================
+`,
+			expect: "openSeaChest_PowerControl Version: 3.5.1-6_2_0 X86_64",
+		},
+		{name: "empty", output: "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expect, parseVersion(tt.output))
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package hw
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotApplied is returned by a VerifiedHDDControl when a drive accepted a
+// command but its IDENTIFY DEVICE data shows the setting did not take effect.
+var ErrNotApplied = errors.New("setting not applied by the drive")
+
+// VerifiedHDDControl wraps an HDDControl and reads back the IDENTIFY DEVICE
+// data of a drive after the commands changing its APM level and EPC timers,
+// turning a silently ignored setting into an ErrNotApplied error.
+//
+// The standby timer value is never read back: drives do not report it. Only
+// the support of the Power Management feature set it requires is checked,
+// once per drive since it does not change.
+//
+// A read back failing, for instance behind a USB bridge without IDENTIFY
+// passthrough, leaves the setting unverified and the command successful. It
+// is logged once, and the settings of the drive are not read back again.
+type VerifiedHDDControl struct {
+	inner HDDControl
+
+	mu sync.Mutex
+	// device -> number of settings not applied
+	mismatches map[string]int
+	// devices known to support the Power Management feature set
+	pm map[string]bool
+	// devices whose read back failed
+	unverifiable map[string]bool
+}
+
+// NewVerifiedHDDControl returns a VerifiedHDDControl wrapping inner.
+func NewVerifiedHDDControl(inner HDDControl) *VerifiedHDDControl {
+	return &VerifiedHDDControl{
+		inner:        inner,
+		mismatches:   make(map[string]int),
+		pm:           make(map[string]bool),
+		unverifiable: make(map[string]bool),
+	}
+}
+
+func (v *VerifiedHDDControl) List() ([]Disk, error)                { return v.inner.List() }
+func (v *VerifiedHDDControl) GetState(dev string) (string, error)  { return v.inner.GetState(dev) }
+func (v *VerifiedHDDControl) IOCount(dev string) (uint64, error)   { return v.inner.IOCount(dev) }
+func (v *VerifiedHDDControl) StandbyNow(dev string) error          { return v.inner.StandbyNow(dev) }
+func (v *VerifiedHDDControl) Wake(dev string) error                { return v.inner.Wake(dev) }
+func (v *VerifiedHDDControl) GetEPC(dev string) (EPCTimers, error) { return v.inner.GetEPC(dev) }
+func (v *VerifiedHDDControl) GetAPM(dev string) (int, error)       { return v.inner.GetAPM(dev) }
+func (v *VerifiedHDDControl) Identify(dev string) (Identity, error) {
+	return v.inner.Identify(dev)
+}
+
+// SetStandbyTimeout verifies the drive supports the Power Management feature
+// set, without which it ignores the timer. The value set is not verified.
+func (v *VerifiedHDDControl) SetStandbyTimeout(dev string, value int) error {
+	if err := v.inner.SetStandbyTimeout(dev, value); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	known := v.pm[dev]
+	v.mu.Unlock()
+	if known {
+		return nil
+	}
+	return v.verify(dev, "standby timeout", func(id Identity) (string, bool) {
+		if id.PM.Supported {
+			v.mu.Lock()
+			v.pm[dev] = true
+			v.mu.Unlock()
+		}
+		return "power management " + id.PM.String(), id.PM.Supported
+	})
+}
+
+// SetAPM verifies the drive reports the APM level set.
+func (v *VerifiedHDDControl) SetAPM(dev string, level int) error {
+	if err := v.inner.SetAPM(dev, level); err != nil {
+		return err
+	}
+	return v.verify(dev, "APM level "+strconv.Itoa(level), func(id Identity) (string, bool) {
+		if !id.APM.Supported {
+			return "APM unsupported", false
+		}
+		return "APM level " + strconv.Itoa(id.APMLevel), id.APMLevel == level
+	})
+}
+
+// SetEPC verifies the drive reports EPC enabled when any timer is set and
+// disabled otherwise.
+func (v *VerifiedHDDControl) SetEPC(dev string, timers EPCTimers) error {
+	if err := v.inner.SetEPC(dev, timers); err != nil {
+		return err
+	}
+	want := Feature{Supported: true, Enabled: timers.Enabled()}
+	return v.verify(dev, "epc "+want.String(), func(id Identity) (string, bool) {
+		return "epc " + id.EPC.String(), id.EPC == want
+	})
+}
+
+// Mismatches returns the number of settings not applied per device.
+func (v *VerifiedHDDControl) Mismatches() map[string]int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return maps.Clone(v.mismatches)
+}
+
+// verify reads back the identity of dev and checks applied, which describes
+// the setting found, holds for it. It does nothing on unverifiable devices.
+func (v *VerifiedHDDControl) verify(dev, setting string, applied func(Identity) (string, bool)) error {
+	v.mu.Lock()
+	unverifiable := v.unverifiable[dev]
+	v.mu.Unlock()
+	if unverifiable {
+		return nil
+	}
+	id, err := v.inner.Identify(dev)
+	if err != nil {
+		logrus.Warnf("could not verify %s on %s, its settings are no longer read back: %v", setting, dev, err)
+		v.mu.Lock()
+		v.unverifiable[dev] = true
+		v.mu.Unlock()
+		return nil
+	}
+	found, ok := applied(id)
+	if ok {
+		logrus.Debugf("verified %s on %s: %s", setting, dev, found)
+		return nil
+	}
+	v.mu.Lock()
+	v.mismatches[dev]++
+	v.mu.Unlock()
+	return fmt.Errorf("%s on %s, drive reports %s: %w", setting, dev, found, ErrNotApplied)
+}
@@ -0,0 +1,150 @@
+package hw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifiedHDDControl(t *testing.T) {
+	identity := Identity{
+		Model:    "WDC WD40EFRX-68N32N0",
+		PM:       Feature{Supported: true, Enabled: true},
+		APM:      Feature{Supported: true, Enabled: true},
+		APMLevel: 128,
+		EPC:      Feature{Supported: true, Enabled: true},
+	}
+	tests := []struct {
+		name             string
+		set              func(*VerifiedHDDControl) error
+		setup            func(*MockHDDControl)
+		expectErrorIs    error
+		expectMismatches map[string]int
+	}{
+		{
+			name: "standby timeout applied",
+			set:  func(v *VerifiedHDDControl) error { return v.SetStandbyTimeout("/dev/sda", 120) },
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(identity, nil).Once()
+			},
+			expectMismatches: map[string]int{},
+		},
+		{
+			name: "standby timeout without power management",
+			set:  func(v *VerifiedHDDControl) error { return v.SetStandbyTimeout("/dev/sda", 120) },
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(Identity{Model: "bridge"}, nil).Once()
+			},
+			expectErrorIs:    ErrNotApplied,
+			expectMismatches: map[string]int{"/dev/sda": 1},
+		},
+		{
+			name: "APM level applied",
+			set:  func(v *VerifiedHDDControl) error { return v.SetAPM("/dev/sda", 128) },
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().SetAPM("/dev/sda", 128).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(identity, nil).Once()
+			},
+			expectMismatches: map[string]int{},
+		},
+		{
+			name: "APM level rounded by the drive",
+			set:  func(v *VerifiedHDDControl) error { return v.SetAPM("/dev/sda", 127) },
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().SetAPM("/dev/sda", 127).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(identity, nil).Once()
+			},
+			expectErrorIs:    ErrNotApplied,
+			expectMismatches: map[string]int{"/dev/sda": 1},
+		},
+		{
+			name: "EPC disabled applied",
+			set:  func(v *VerifiedHDDControl) error { return v.SetEPC("/dev/sda", EPCTimers{}) },
+			setup: func(m *MockHDDControl) {
+				id := identity
+				id.EPC.Enabled = false
+				m.EXPECT().SetEPC("/dev/sda", EPCTimers{}).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(id, nil).Once()
+			},
+			expectMismatches: map[string]int{},
+		},
+		{
+			name: "EPC still disabled",
+			set: func(v *VerifiedHDDControl) error {
+				return v.SetEPC("/dev/sda", EPCTimers{StandbyZ: time.Hour})
+			},
+			setup: func(m *MockHDDControl) {
+				id := identity
+				id.EPC.Enabled = false
+				m.EXPECT().SetEPC("/dev/sda", EPCTimers{StandbyZ: time.Hour}).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(id, nil).Once()
+			},
+			expectErrorIs:    ErrNotApplied,
+			expectMismatches: map[string]int{"/dev/sda": 1},
+		},
+		{
+			name: "failed command is not verified",
+			set:  func(v *VerifiedHDDControl) error { return v.SetAPM("/dev/sda", 128) },
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().SetAPM("/dev/sda", 128).Return(ErrAPMUnsupported).Once()
+			},
+			expectErrorIs:    ErrAPMUnsupported,
+			expectMismatches: map[string]int{},
+		},
+		{
+			name: "failed read back leaves the setting unverified",
+			set:  func(v *VerifiedHDDControl) error { return v.SetStandbyTimeout("/dev/sda", 0) },
+			setup: func(m *MockHDDControl) {
+				m.EXPECT().SetStandbyTimeout("/dev/sda", 0).Return(nil).Once()
+				m.EXPECT().Identify("/dev/sda").Return(Identity{}, errors.New("SG_IO: bad/missing sense data")).Once()
+			},
+			expectMismatches: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := NewMockHDDControl(t)
+			tt.setup(inner)
+			v := NewVerifiedHDDControl(inner)
+			err := tt.set(v)
+			if tt.expectErrorIs != nil {
+				require.ErrorIs(t, err, tt.expectErrorIs)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.expectMismatches, v.Mismatches())
+		})
+	}
+}
+
+func TestVerifiedHDDControl_ReadBackOnce(t *testing.T) {
+	inner := NewMockHDDControl(t)
+	v := NewVerifiedHDDControl(inner)
+	identity := Identity{PM: Feature{Supported: true, Enabled: true}, APM: Feature{Supported: true, Enabled: true}, APMLevel: 128}
+
+	// the Power Management support of a drive is checked once
+	inner.EXPECT().SetStandbyTimeout("/dev/sda", 120).Return(nil).Twice()
+	inner.EXPECT().Identify("/dev/sda").Return(identity, nil).Once()
+	require.NoError(t, v.SetStandbyTimeout("/dev/sda", 120))
+	require.NoError(t, v.SetStandbyTimeout("/dev/sda", 120))
+
+	// the APM level is read back every time
+	inner.EXPECT().SetAPM("/dev/sda", 128).Return(nil).Twice()
+	inner.EXPECT().Identify("/dev/sda").Return(identity, nil).Twice()
+	require.NoError(t, v.SetAPM("/dev/sda", 128))
+	require.NoError(t, v.SetAPM("/dev/sda", 128))
+
+	// a drive that cannot be read back is not read back again
+	inner.EXPECT().SetStandbyTimeout("/dev/sdb", 120).Return(nil).Twice()
+	inner.EXPECT().SetAPM("/dev/sdb", 128).Return(nil).Once()
+	inner.EXPECT().Identify("/dev/sdb").Return(Identity{}, errors.New("SG_IO: bad/missing sense data")).Once()
+	require.NoError(t, v.SetStandbyTimeout("/dev/sdb", 120))
+	require.NoError(t, v.SetStandbyTimeout("/dev/sdb", 120))
+	require.NoError(t, v.SetAPM("/dev/sdb", 128))
+	require.Empty(t, v.Mismatches())
+}
@@ -0,0 +1,175 @@
+// Package managers detects the other programs setting the power management
+// of disks, whose timers compete with the ones armed by the daemon.
+package managers
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Manager is another program setting the standby timer or APM level of
+// disks.
+type Manager struct {
+	Name string `json:"name"`
+	// Evidence tells how it was found
+	Evidence string `json:"evidence"`
+	// Hint tells how to stop it from competing
+	Hint string `json:"hint"`
+}
+
+// Detect returns the power managers found on the host.
+func Detect() []Manager {
+	return detect(os.DirFS("/"))
+}
+
+func detect(fsys fs.FS) []Manager {
+	var found []Manager
+	if running(fsys, "hd-idle") {
+		found = append(found, Manager{
+			Name:     "hd-idle",
+			Evidence: "hd-idle is running",
+			Hint:     "stop and disable hd-idle, or exclude the disks managed by hd-smart-idle from it",
+		})
+	}
+
+	udisksd := running(fsys, "udisksd")
+	for _, file := range configs(fsys, "etc/udisks2/*.conf") {
+		if path.Base(file) == "udisks2.conf" {
+			// the daemon settings, drive settings are in <drive id>.conf
+			continue
+		}
+		keys := settings(fsys, file, "StandbyTimeout", "APMLevel")
+		if len(keys) == 0 {
+			continue
+		}
+		evidence := strings.Join(keys, ", ") + " in /" + file
+		if udisksd {
+			evidence += ", udisksd is running"
+		}
+		found = append(found, Manager{
+			Name:     "udisks",
+			Evidence: evidence,
+			Hint:     "remove the standby timeout and APM level of the drive from /" + file + " (Drive Settings in GNOME Disks)",
+		})
+	}
+
+	for _, file := range tlpDiskConfigs(fsys) {
+		keys := settings(fsys, file, "DISK_SPINDOWN_TIMEOUT_ON_AC", "DISK_SPINDOWN_TIMEOUT_ON_BAT", "DISK_APM_LEVEL_ON_AC", "DISK_APM_LEVEL_ON_BAT")
+		if len(keys) == 0 {
+			continue
+		}
+		found = append(found, Manager{
+			Name:     "tlp",
+			Evidence: strings.Join(keys, ", ") + " in /" + file,
+			Hint:     "set DISK_DEVICES=\"\" in /etc/tlp.conf so that tlp leaves the disks alone",
+		})
+	}
+
+	if keys := settings(fsys, "etc/hdparm.conf", "spindown_time", "apm", "apm_battery"); len(keys) > 0 {
+		found = append(found, Manager{
+			Name:     "hdparm.conf",
+			Evidence: strings.Join(keys, ", ") + " in /etc/hdparm.conf",
+			Hint:     "remove them from /etc/hdparm.conf, applied by udev whenever a disk appears",
+		})
+	}
+	return found
+}
+
+// tlpDiskConfigs returns the user configuration files of tlp, unless tlp is
+// disabled or manages no disk: TLP_ENABLE=0 or DISK_DEVICES="". The files
+// of /etc/tlp.d are read first, /etc/tlp.conf overrides them. The disk
+// settings of the packaged defaults are not evidence, tlp ships them.
+func tlpDiskConfigs(fsys fs.FS) []string {
+	files := configs(fsys, "etc/tlp.d/*.conf", "etc/tlp.conf")
+	enabled, disks := true, true
+	for _, file := range files {
+		for _, a := range assignments(fsys, file) {
+			value := strings.Trim(a.value, `"'`)
+			switch a.key {
+			case "TLP_ENABLE":
+				enabled = value != "0"
+			case "DISK_DEVICES":
+				disks = strings.TrimSpace(value) != ""
+			}
+		}
+	}
+	if !enabled || !disks {
+		return nil
+	}
+	return files
+}
+
+// running tells whether a process named comm runs.
+func running(fsys fs.FS, comm string) bool {
+	entries, err := fs.ReadDir(fsys, "proc")
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join("proc", e.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(data)) == comm {
+			return true
+		}
+	}
+	return false
+}
+
+// configs returns the files matching patterns, in order.
+func configs(fsys fs.FS, patterns ...string) []string {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			continue
+		}
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// settings returns the keys set by a "key = value" line of file, comments
+// aside, in the order they are first set.
+func settings(fsys fs.FS, file string, keys ...string) []string {
+	var set []string
+	for _, a := range assignments(fsys, file) {
+		if slices.Contains(keys, a.key) && !slices.Contains(set, a.key) {
+			set = append(set, a.key)
+		}
+	}
+	return set
+}
+
+// assignment is a "key = value" line of a configuration file.
+type assignment struct {
+	key   string
+	value string
+}
+
+// assignments returns the "key = value" lines of file, comments aside, in
+// order.
+func assignments(fsys fs.FS, file string) []assignment {
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil
+	}
+	var found []assignment
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			found = append(found, assignment{key: strings.TrimSpace(key), value: strings.TrimSpace(value)})
+		}
+	}
+	return found
+}
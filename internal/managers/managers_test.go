@@ -0,0 +1,72 @@
+package managers
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	tests := []struct {
+		name   string
+		fsys   fstest.MapFS
+		expect []string
+	}{
+		{
+			name: "none",
+			fsys: fstest.MapFS{
+				"proc/1/comm":                       file("systemd\n"),
+				"proc/812/comm":                     file("udisksd\n"),
+				"etc/udisks2/udisks2.conf":          file("[udisks2]\nmodules=*\n"),
+				"etc/tlp.conf":                      file("#DISK_SPINDOWN_TIMEOUT_ON_AC=\"0 0\"\nTLP_ENABLE=1\n"),
+				"usr/share/tlp/defaults.conf":       file("DISK_APM_LEVEL_ON_AC=\"254 254\"\n"),
+				"etc/hdparm.conf":                   file("quiet\n#/dev/sda {\n#\tspindown_time = 24\n#}\n"),
+				"etc/udisks2/WDC-WD40EFRX-123.conf": file("[ATA]\n"),
+			},
+		},
+		{
+			name: "all",
+			fsys: fstest.MapFS{
+				"proc/1/comm":                       file("systemd\n"),
+				"proc/77/comm":                      file("hd-idle\n"),
+				"proc/812/comm":                     file("udisksd\n"),
+				"etc/udisks2/WDC-WD40EFRX-123.conf": file("[ATA]\nStandbyTimeout=50\nAPMLevel=127\n"),
+				"etc/tlp.d/10-disks.conf":           file("DISK_DEVICES=\"\"\nDISK_SPINDOWN_TIMEOUT_ON_BAT=\"12 12\"\n"),
+				"etc/tlp.conf":                      file("DISK_DEVICES=\"sda sdb\"\nDISK_APM_LEVEL_ON_AC=\"254 254\"\n"),
+				"etc/hdparm.conf":                   file("/dev/sda {\n\tspindown_time = 24\n\tapm = 127\n}\n"),
+			},
+			expect: []string{
+				"hd-idle: hd-idle is running",
+				"udisks: StandbyTimeout, APMLevel in /etc/udisks2/WDC-WD40EFRX-123.conf, udisksd is running",
+				"tlp: DISK_SPINDOWN_TIMEOUT_ON_BAT in /etc/tlp.d/10-disks.conf",
+				"tlp: DISK_APM_LEVEL_ON_AC in /etc/tlp.conf",
+				"hdparm.conf: spindown_time, apm in /etc/hdparm.conf",
+			},
+		},
+		{
+			name: "tlp leaves the disks alone",
+			fsys: fstest.MapFS{
+				"etc/tlp.d/10-disks.conf": file("DISK_APM_LEVEL_ON_AC=\"254 254\"\n"),
+				"etc/tlp.conf":            file("DISK_DEVICES=\"\"\n"),
+			},
+		},
+		{
+			name: "tlp disabled",
+			fsys: fstest.MapFS{
+				"etc/tlp.conf": file("TLP_ENABLE=0\nDISK_SPINDOWN_TIMEOUT_ON_AC=\"12 12\"\n"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range detect(tt.fsys) {
+				require.NotEmpty(t, m.Hint)
+				got = append(got, m.Name+": "+m.Evidence)
+			}
+			require.Equal(t, tt.expect, got)
+		})
+	}
+}
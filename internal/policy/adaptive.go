@@ -0,0 +1,137 @@
+package policy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// DefaultCandidates are the hdparm -S values the adaptive policy chooses from:
+// 1, 2, 5, 10, 15 and 20 minutes, then 30 minutes, 1, 2 and 3 hours.
+var DefaultCandidates = []int{12, 24, 60, 120, 180, 240, 241, 242, 244, 246}
+
+// AdaptiveConfig configures the adaptive standby policy.
+type AdaptiveConfig struct {
+	// PowerWeight balances power against wear in [0, 1]. 0 only minimizes
+	// spin-ups, 1 only minimizes the time spent spinning.
+	PowerWeight float64
+	// MinSamples is the number of recorded gaps required before the policy
+	// overrides the fallback value.
+	MinSamples int
+	// MaxSamples bounds the gap history kept per device (oldest dropped first).
+	MaxSamples int
+	// Candidates are the hdparm -S values to evaluate; DefaultCandidates if empty.
+	Candidates []int
+}
+
+// Adaptive learns per-device inter-access gaps and picks the standby timeout
+// with the lowest expected cost. It holds no clock of its own: callers pass
+// observation times, so feeding a recorded trace always yields the same result.
+// Adaptive is not safe for concurrent use.
+type Adaptive struct {
+	cfg        AdaptiveConfig
+	lastAccess map[string]time.Time
+	gaps       map[string][]time.Duration
+}
+
+func NewAdaptive(cfg AdaptiveConfig) *Adaptive {
+	if len(cfg.Candidates) == 0 {
+		cfg.Candidates = DefaultCandidates
+	}
+	if cfg.MaxSamples <= 0 {
+		cfg.MaxSamples = 1024
+	}
+	return &Adaptive{
+		cfg:        cfg,
+		lastAccess: make(map[string]time.Time),
+		gaps:       make(map[string][]time.Duration),
+	}
+}
+
+// Observe records that dev was accessed at the given time.
+func (a *Adaptive) Observe(dev string, at time.Time) {
+	last, ok := a.lastAccess[dev]
+	a.lastAccess[dev] = at
+	if !ok || !at.After(last) {
+		return
+	}
+	gaps := append(a.gaps[dev], at.Sub(last))
+	if len(gaps) > a.cfg.MaxSamples {
+		gaps = gaps[len(gaps)-a.cfg.MaxSamples:]
+	}
+	a.gaps[dev] = gaps
+}
+
+// Busy records that dev was still accessed at the given time, in the same busy
+// period as its previous access: no gap is recorded, the next one starts from
+// it.
+func (a *Adaptive) Busy(dev string, at time.Time) {
+	if last, ok := a.lastAccess[dev]; !ok || at.After(last) {
+		a.lastAccess[dev] = at
+	}
+}
+
+// Gaps returns a copy of the recorded inter-access gaps of dev.
+func (a *Adaptive) Gaps(dev string) []time.Duration {
+	return append([]time.Duration(nil), a.gaps[dev]...)
+}
+
+// Timeout returns the learned standby value for dev, or fallback while fewer
+// than MinSamples gaps have been recorded.
+func (a *Adaptive) Timeout(dev string, fallback int) int {
+	gaps := a.gaps[dev]
+	if len(gaps) == 0 || len(gaps) < a.cfg.MinSamples {
+		return fallback
+	}
+	return ChooseTimeout(gaps, a.cfg.Candidates, a.cfg.PowerWeight)
+}
+
+// ChooseTimeout returns the candidate standby value minimizing
+//
+//	w * spinning/total + (1-w) * spinups/len(gaps)
+//
+// where a gap g longer than the timeout T costs one spin-up and T of spinning,
+// and any other gap costs g of spinning. Ties go to the shorter timeout.
+// It returns 0 when there are no gaps to learn from.
+func ChooseTimeout(gaps []time.Duration, candidates []int, powerWeight float64) int {
+	if len(gaps) == 0 {
+		return 0
+	}
+	powerWeight = min(max(powerWeight, 0), 1)
+	values := append([]int(nil), candidates...)
+	sort.Slice(values, func(i, j int) bool {
+		return hw.StandbyDuration(values[i]) < hw.StandbyDuration(values[j])
+	})
+
+	var total time.Duration
+	for _, g := range gaps {
+		total += g
+	}
+
+	best, bestCost := 0, 0.0
+	for _, v := range values {
+		timeout := hw.StandbyDuration(v)
+		if timeout <= 0 {
+			continue
+		}
+		var spinning time.Duration
+		spinups := 0
+		for _, g := range gaps {
+			if g > timeout {
+				spinups++
+				spinning += timeout
+			} else {
+				spinning += g
+			}
+		}
+		cost := (1 - powerWeight) * float64(spinups) / float64(len(gaps))
+		if total > 0 {
+			cost += powerWeight * float64(spinning) / float64(total)
+		}
+		if best == 0 || cost < bestCost {
+			best, bestCost = v, cost
+		}
+	}
+	return best
+}
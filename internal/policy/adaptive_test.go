@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChooseTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		gaps        []time.Duration
+		powerWeight float64
+		want        int
+	}{
+		{
+			name:        "no gaps",
+			gaps:        nil,
+			powerWeight: 0.5,
+			want:        0,
+		},
+		{
+			name:        "wear only prefers shortest timeout without spin-ups",
+			gaps:        []time.Duration{3 * time.Minute, 4 * time.Minute, 8 * time.Minute},
+			powerWeight: 0,
+			want:        120,
+		},
+		{
+			name:        "power only prefers shortest timeout",
+			gaps:        []time.Duration{3 * time.Minute, 4 * time.Minute, 8 * time.Minute},
+			powerWeight: 1,
+			want:        12,
+		},
+		{
+			name: "bursty access with long nights",
+			gaps: []time.Duration{
+				30 * time.Second, 40 * time.Second, 50 * time.Second, 30 * time.Second,
+				8 * time.Hour, 6 * time.Hour,
+			},
+			powerWeight: 0.5,
+			want:        12,
+		},
+		{
+			name: "frequent 5 minute access avoids cycling",
+			gaps: []time.Duration{
+				4 * time.Minute, 4 * time.Minute, 4 * time.Minute, 4 * time.Minute,
+				4 * time.Minute, 4 * time.Minute, 4 * time.Minute, 3 * time.Hour,
+			},
+			powerWeight: 0.3,
+			want:        60,
+		},
+		{
+			name:        "weight is clamped",
+			gaps:        []time.Duration{3 * time.Minute, 4 * time.Minute, 8 * time.Minute},
+			powerWeight: -2,
+			want:        120,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ChooseTimeout(tt.gaps, DefaultCandidates, tt.powerWeight))
+		})
+	}
+}
+
+func TestAdaptive_ReplayTrace(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	trace := []time.Duration{0, 4 * time.Minute, 8 * time.Minute, 12 * time.Minute, 16 * time.Minute, 20 * time.Minute}
+
+	a := NewAdaptive(AdaptiveConfig{PowerWeight: 0, MinSamples: 5})
+	for i, offset := range trace {
+		// not enough samples yet
+		if i < 5 {
+			require.Equal(t, 120, a.Timeout("/dev/sda", 120))
+		}
+		a.Observe("/dev/sda", base.Add(offset))
+	}
+	// duplicate observation adds no gap
+	a.Observe("/dev/sda", base.Add(20*time.Minute))
+
+	require.Len(t, a.Gaps("/dev/sda"), 5)
+	require.Equal(t, 60, a.Timeout("/dev/sda", 120))
+	require.Equal(t, 120, a.Timeout("/dev/sdb", 120))
+}
+
+func TestAdaptive_MaxSamples(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewAdaptive(AdaptiveConfig{MaxSamples: 2})
+	a.Observe("/dev/sda", base)
+	a.Observe("/dev/sda", base.Add(time.Minute))
+	a.Observe("/dev/sda", base.Add(3*time.Minute))
+	a.Observe("/dev/sda", base.Add(6*time.Minute))
+
+	require.Equal(t, []time.Duration{2 * time.Minute, 3 * time.Minute}, a.Gaps("/dev/sda"))
+}
+
+func TestAdaptive_Busy(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewAdaptive(AdaptiveConfig{})
+	a.Busy("/dev/sda", base)
+	a.Observe("/dev/sda", base.Add(10*time.Minute))
+	a.Busy("/dev/sda", base.Add(11*time.Minute))
+	a.Busy("/dev/sda", base.Add(12*time.Minute))
+	a.Observe("/dev/sda", base.Add(40*time.Minute))
+
+	// the gaps start from the end of the busy periods
+	require.Equal(t, []time.Duration{10 * time.Minute, 28 * time.Minute}, a.Gaps("/dev/sda"))
+}
@@ -0,0 +1,127 @@
+package power
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// Usage is the time a drive spent in each power mode and its energy.
+type Usage struct {
+	Active  time.Duration `json:"active"`
+	Idle    time.Duration `json:"idle"`
+	Standby time.Duration `json:"standby"`
+	SpinUps int           `json:"spin_ups"`
+	// Joules is the energy consumed
+	Joules float64 `json:"joules"`
+	// BaselineJoules is the energy an always spinning drive would consume
+	BaselineJoules float64 `json:"baseline_joules"`
+}
+
+// KWh returns the energy consumed in kWh.
+func (u Usage) KWh() float64 { return u.Joules / 3.6e6 }
+
+// SavedKWh returns the energy saved against the always-on baseline in kWh,
+// negative when spin-ups cost more than standby saved.
+func (u Usage) SavedKWh() float64 { return (u.BaselineJoules - u.Joules) / 3.6e6 }
+
+// Sub returns the usage accumulated since prev.
+func (u Usage) Sub(prev Usage) Usage {
+	return Usage{
+		Active:         u.Active - prev.Active,
+		Idle:           u.Idle - prev.Idle,
+		Standby:        u.Standby - prev.Standby,
+		SpinUps:        u.SpinUps - prev.SpinUps,
+		Joules:         u.Joules - prev.Joules,
+		BaselineJoules: u.BaselineJoules - prev.BaselineJoules,
+	}
+}
+
+// Add returns the sum of two usages.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		Active:         u.Active + o.Active,
+		Idle:           u.Idle + o.Idle,
+		Standby:        u.Standby + o.Standby,
+		SpinUps:        u.SpinUps + o.SpinUps,
+		Joules:         u.Joules + o.Joules,
+		BaselineJoules: u.BaselineJoules + o.BaselineJoules,
+	}
+}
+
+type deviceMeter struct {
+	model Model
+	state string
+	since time.Time
+	usage Usage
+}
+
+// Meter integrates the time each drive spends in each power mode from the
+// polled states. Like the daemon it holds no clock of its own.
+// Meter is not safe for concurrent use.
+type Meter struct {
+	models  func(dev string) Model
+	devices map[string]*deviceMeter
+}
+
+// NewMeter returns a Meter using models to get the power model of a device.
+func NewMeter(models func(dev string) Model) *Meter {
+	return &Meter{models: models, devices: make(map[string]*deviceMeter)}
+}
+
+// Observe records that dev was seen in state at now. The previous state is
+// assumed to have lasted until now, and a spun down drive seen spinning
+// counts a spin-up.
+func (m *Meter) Observe(dev, state string, now time.Time) {
+	d, ok := m.devices[dev]
+	if !ok {
+		m.devices[dev] = &deviceMeter{model: m.models(dev), state: state, since: now}
+		return
+	}
+	d.advance(now)
+	if hw.IsSpunDown(d.state) && !hw.IsSpunDown(state) {
+		d.usage.SpinUps++
+		d.usage.Joules += d.model.SpinUpJoules
+	}
+	d.state = state
+}
+
+// advance accounts the current state until now.
+func (d *deviceMeter) advance(now time.Time) {
+	elapsed := now.Sub(d.since)
+	if elapsed <= 0 {
+		return
+	}
+	d.since = now
+	switch {
+	case hw.IsSpunDown(d.state):
+		d.usage.Standby += elapsed
+	case isLowPowerIdle(d.state):
+		d.usage.Idle += elapsed
+	default:
+		d.usage.Active += elapsed
+	}
+	d.usage.Joules += d.model.Watts(d.state) * elapsed.Seconds()
+	d.usage.BaselineJoules += d.model.ActiveWatts * elapsed.Seconds()
+}
+
+// Usage returns the usage of every metered device until now, by device.
+func (m *Meter) Usage(now time.Time) map[string]Usage {
+	usage := make(map[string]Usage, len(m.devices))
+	for dev, d := range m.devices {
+		d.advance(now)
+		usage[dev] = d.usage
+	}
+	return usage
+}
+
+// Devices returns the metered devices in order.
+func (m *Meter) Devices() []string {
+	devs := make([]string, 0, len(m.devices))
+	for dev := range m.devices {
+		devs = append(devs, dev)
+	}
+	sort.Strings(devs)
+	return devs
+}
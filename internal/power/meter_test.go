@@ -0,0 +1,46 @@
+package power
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeter(t *testing.T) {
+	model := Model{ActiveWatts: 5, IdleWatts: 3, StandbyWatts: 1, SpinUpJoules: 100}
+	m := NewMeter(func(string) Model { return model })
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	m.Observe("/dev/sda", hw.DriveStateActive, at(0))
+	m.Observe("/dev/sda", hw.DriveStateIdleB, at(time.Hour))
+	m.Observe("/dev/sda", hw.DriveStateStandby, at(2*time.Hour))
+	m.Observe("/dev/sda", hw.DriveStateSleeping, at(3*time.Hour))
+	m.Observe("/dev/sda", hw.DriveStateActive, at(5*time.Hour))
+	m.Observe("/dev/sdb", hw.DriveStateStandby, at(4*time.Hour))
+
+	usage := m.Usage(at(6 * time.Hour))
+	require.Equal(t, Usage{
+		Active:         2 * time.Hour,
+		Idle:           time.Hour,
+		Standby:        3 * time.Hour,
+		SpinUps:        1,
+		Joules:         (5*2+3*1+1*3)*3600 + 100,
+		BaselineJoules: 5 * 6 * 3600,
+	}, usage["/dev/sda"])
+	require.Equal(t, Usage{
+		Standby:        2 * time.Hour,
+		Joules:         1 * 2 * 3600,
+		BaselineJoules: 5 * 2 * 3600,
+	}, usage["/dev/sdb"])
+	require.Equal(t, []string{"/dev/sda", "/dev/sdb"}, m.Devices())
+
+	require.InDelta(t, 0.016, usage["/dev/sda"].KWh()-100/3.6e6, 1e-9)
+	require.InDelta(t, 0.014-100/3.6e6, usage["/dev/sda"].SavedKWh(), 1e-9)
+
+	// a later snapshot only accounts the elapsed time
+	later := m.Usage(at(7 * time.Hour))["/dev/sda"].Sub(usage["/dev/sda"])
+	require.Equal(t, Usage{Active: time.Hour, Joules: 5 * 3600, BaselineJoules: 5 * 3600}, later)
+}
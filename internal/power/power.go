@@ -0,0 +1,160 @@
+package power
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+)
+
+// Drive classes with a built-in power model.
+const (
+	// ClassDesktop is a 3.5" 7200 rpm desktop drive.
+	ClassDesktop = "desktop"
+	// ClassNAS is a 3.5" 5400 rpm class NAS drive.
+	ClassNAS = "nas"
+	// ClassEnterprise is a 3.5" 7200 rpm enterprise SATA or SAS drive.
+	ClassEnterprise = "enterprise"
+	// ClassLaptop is a 2.5" drive.
+	ClassLaptop = "laptop"
+)
+
+// Model is the power draw of a drive in each power mode.
+type Model struct {
+	// ActiveWatts is the draw while spinning with heads loaded, the
+	// "active/idle" mode. It is also the draw of the always-on baseline.
+	ActiveWatts float64 `yaml:"active_watts"`
+	// IdleWatts is the draw in the low power idle modes: legacy idle and
+	// the EPC idle_a/b/c conditions.
+	IdleWatts float64 `yaml:"idle_watts"`
+	// StandbyWatts is the draw while spun down.
+	StandbyWatts float64 `yaml:"standby_watts"`
+	// SpinUpJoules is the extra energy of a single spin-up.
+	SpinUpJoules float64 `yaml:"spinup_joules"`
+}
+
+// Classes are the built-in power models, rough averages of datasheet figures.
+var Classes = map[string]Model{
+	ClassDesktop:    {ActiveWatts: 5.0, IdleWatts: 3.5, StandbyWatts: 0.8, SpinUpJoules: 150},
+	ClassNAS:        {ActiveWatts: 3.5, IdleWatts: 2.5, StandbyWatts: 0.5, SpinUpJoules: 100},
+	ClassEnterprise: {ActiveWatts: 7.0, IdleWatts: 5.0, StandbyWatts: 1.0, SpinUpJoules: 250},
+	ClassLaptop:     {ActiveWatts: 1.5, IdleWatts: 0.8, StandbyWatts: 0.2, SpinUpJoules: 15},
+}
+
+// Watts returns the draw of the drive in state, one of the hw.DriveState
+// constants.
+func (m Model) Watts(state string) float64 {
+	switch {
+	case hw.IsSpunDown(state):
+		return m.StandbyWatts
+	case isLowPowerIdle(state):
+		return m.IdleWatts
+	default:
+		return m.ActiveWatts
+	}
+}
+
+// isLowPowerIdle tells whether state is a spinning mode below active/idle.
+func isLowPowerIdle(state string) bool {
+	switch state {
+	case hw.DriveStateIdle, hw.DriveStateIdleA, hw.DriveStateIdleB, hw.DriveStateIdleC:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks no figure is negative.
+func (m Model) Validate() error {
+	if m.ActiveWatts < 0 || m.IdleWatts < 0 || m.StandbyWatts < 0 || m.SpinUpJoules < 0 {
+		return fmt.Errorf("negative power figure in %+v", m)
+	}
+	return nil
+}
+
+// Spec selects the power model of a drive: a drive class, with any non-zero
+// figure overriding the one of the class.
+type Spec struct {
+	Class string `yaml:"class"`
+	Model `yaml:",inline"`
+}
+
+// Config configures the power model of every monitored drive.
+type Config struct {
+	// Spec applies to every drive. Without a class, SAS and SCSI drives are
+	// taken as enterprise drives and others as desktop drives.
+	Spec `yaml:",inline"`
+	// Devices overrides Spec per device path.
+	Devices map[string]Spec `yaml:"devices"`
+}
+
+// Validate checks classes exist and figures are not negative.
+func (c Config) Validate() error {
+	if err := c.Spec.validate(); err != nil {
+		return err
+	}
+	for dev, spec := range c.Devices {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("%s: %w", dev, err)
+		}
+	}
+	return nil
+}
+
+func (s Spec) validate() error {
+	if _, ok := Classes[s.Class]; s.Class != "" && !ok {
+		names := make([]string, 0, len(Classes))
+		for name := range Classes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown drive class %q: expected %s", s.Class, strings.Join(names, "|"))
+	}
+	return s.Model.Validate()
+}
+
+// Model returns the power model of dev attached through transport, one of
+// the hw.Transport constants.
+func (c Config) Model(dev, transport string) Model {
+	spec := c.Spec
+	if s, ok := c.Devices[dev]; ok {
+		spec = s.inherit(spec)
+	}
+	class := spec.Class
+	if class == "" {
+		class = ClassDesktop
+		if transport == hw.TransportSAS || transport == hw.TransportSCSI {
+			class = ClassEnterprise
+		}
+	}
+	return spec.Model.or(Classes[class])
+}
+
+// inherit fills the unset fields of s from parent. A spec naming its own
+// class does not inherit the figures overriding another class.
+func (s Spec) inherit(parent Spec) Spec {
+	if s.Class != "" {
+		return s
+	}
+	s.Class = parent.Class
+	s.Model = s.Model.or(parent.Model)
+	return s
+}
+
+// or returns m with its zero figures taken from fallback.
+func (m Model) or(fallback Model) Model {
+	if m.ActiveWatts == 0 {
+		m.ActiveWatts = fallback.ActiveWatts
+	}
+	if m.IdleWatts == 0 {
+		m.IdleWatts = fallback.IdleWatts
+	}
+	if m.StandbyWatts == 0 {
+		m.StandbyWatts = fallback.StandbyWatts
+	}
+	if m.SpinUpJoules == 0 {
+		m.SpinUpJoules = fallback.SpinUpJoules
+	}
+	return m
+}
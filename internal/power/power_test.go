@@ -0,0 +1,102 @@
+package power
+
+import (
+	"testing"
+
+	"github.com/chain710/hd-smart-idle/internal/hw"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestModel_Watts(t *testing.T) {
+	m := Model{ActiveWatts: 5, IdleWatts: 3, StandbyWatts: 1}
+	tests := []struct {
+		state string
+		want  float64
+	}{
+		{hw.DriveStateActive, 5},
+		{hw.DriveStateUnknown, 5},
+		{hw.DriveStateIdle, 3},
+		{hw.DriveStateIdleB, 3},
+		{hw.DriveStateStandbyY, 1},
+		{hw.DriveStateStandby, 1},
+		{hw.DriveStateSleeping, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			require.Equal(t, tt.want, m.Watts(tt.state))
+		})
+	}
+}
+
+func TestConfig_Model(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		dev       string
+		transport string
+		want      Model
+		wantErr   bool
+	}{
+		{
+			name:      "default sata",
+			dev:       "/dev/sda",
+			transport: hw.TransportATA,
+			want:      Classes[ClassDesktop],
+		},
+		{
+			name:      "default sas",
+			dev:       "/dev/sda",
+			transport: hw.TransportSAS,
+			want:      Classes[ClassEnterprise],
+		},
+		{
+			name:      "global class and override",
+			yaml:      "class: nas\nstandby_watts: 0.7\n",
+			dev:       "/dev/sda",
+			transport: hw.TransportSAS,
+			want:      Model{ActiveWatts: 3.5, IdleWatts: 2.5, StandbyWatts: 0.7, SpinUpJoules: 100},
+		},
+		{
+			name: "device inherits global",
+			yaml: "class: nas\nstandby_watts: 0.7\ndevices:\n  /dev/sda:\n    active_watts: 4\n",
+			dev:  "/dev/sda",
+			want: Model{ActiveWatts: 4, IdleWatts: 2.5, StandbyWatts: 0.7, SpinUpJoules: 100},
+		},
+		{
+			name: "device class drops global figures",
+			yaml: "class: nas\nstandby_watts: 0.7\ndevices:\n  /dev/sda:\n    class: laptop\n",
+			dev:  "/dev/sda",
+			want: Classes[ClassLaptop],
+		},
+		{
+			name: "other device",
+			yaml: "devices:\n  /dev/sda:\n    class: laptop\n",
+			dev:  "/dev/sdb",
+			want: Classes[ClassDesktop],
+		},
+		{
+			name:    "unknown class",
+			yaml:    "devices:\n  /dev/sda:\n    class: tape\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative figure",
+			yaml:    "idle_watts: -1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			require.NoError(t, yaml.Unmarshal([]byte(tt.yaml), &cfg))
+			err := cfg.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cfg.Model(tt.dev, tt.transport))
+		})
+	}
+}
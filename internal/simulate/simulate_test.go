@@ -0,0 +1,124 @@
+package simulate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chain710/hd-smart-idle/internal/config"
+	"github.com/chain710/hd-smart-idle/internal/daemon"
+	"github.com/chain710/hd-smart-idle/internal/power"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrace(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      Trace
+		expectErr string
+	}{
+		{
+			name: "mixed formats sorted by time",
+			input: `# recorded on nas
+2025-01-01T03:00:00Z /dev/sda
+
+1735700400 /dev/sdb active
+2025-01-01T02:00:00Z /dev/sda standby
+`,
+			want: Trace{
+				{Time: time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC), Device: "/dev/sda", Kind: EventStandby},
+				{Time: time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC), Device: "/dev/sda", Kind: EventIO},
+				{Time: time.Unix(1735700400, 0), Device: "/dev/sdb", Kind: EventActive},
+			},
+		},
+		{
+			name:      "missing device",
+			input:     "2025-01-01T03:00:00Z\n",
+			expectErr: "line 1",
+		},
+		{
+			name:      "bad timestamp",
+			input:     "yesterday /dev/sda\n",
+			expectErr: "invalid timestamp",
+		},
+		{
+			name:      "bad kind",
+			input:     "2025-01-01T03:00:00Z /dev/sda write\n",
+			expectErr: "unknown event kind",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := ParseTrace(strings.NewReader(tt.input))
+			if tt.expectErr != "" {
+				require.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, trace, len(tt.want))
+			for i := range tt.want {
+				require.True(t, tt.want[i].Time.Equal(trace[i].Time))
+				require.Equal(t, tt.want[i].Device, trace[i].Device)
+				require.Equal(t, tt.want[i].Kind, trace[i].Kind)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// daily access at 08:00 and 20:00 on sda, sdb idle
+	var trace Trace
+	for day := 0; day < 2; day++ {
+		for _, hour := range []int{8, 20} {
+			trace = append(trace, Event{Time: start.Add(time.Duration(day*24+hour) * time.Hour), Device: "/dev/sda", Kind: EventIO})
+		}
+	}
+	trace = append(trace, Event{Time: start.Add(time.Hour), Device: "/dev/sdb", Kind: EventStandby})
+	end := start.Add(48 * time.Hour)
+
+	p := Policy{
+		Name:   "night",
+		Config: daemon.Config{PollInterval: time.Minute, Cron: &config.CronExpr{Hour: 22, Min: 0}, StandbyValue: 120},
+	}
+	report, err := Run(trace, p, start, end, power.Model{ActiveWatts: 5, StandbyWatts: 1, SpinUpJoules: 3600})
+	require.NoError(t, err)
+	require.Equal(t, "night", report.Policy)
+	require.Len(t, report.Devices, 2)
+
+	// timers armed at 22:00, spin down at 22:10, woken at 08:00 by sda access:
+	// the daemon then disables the timer until 22:00 again
+	sda := report.Devices[0]
+	require.Equal(t, "/dev/sda", sda.Device)
+	require.Equal(t, 1, sda.SpinUps)
+	standby := (9 + 50.0/60) + (1 + 50.0/60)
+	require.InDelta(t, standby, sda.StandbyHours, 1e-9)
+	require.InDelta(t, 48-standby, sda.ActiveHours, 1e-9)
+
+	// sdb never wakes after the first window
+	sdb := report.Devices[1]
+	require.Equal(t, 0, sdb.SpinUps)
+	require.InDelta(t, 22+10.0/60, sdb.ActiveHours, 1e-9)
+
+	wantKWh := (5*sda.ActiveHours + sda.StandbyHours + 1 + 5*sdb.ActiveHours + sdb.StandbyHours) / 1000
+	require.InDelta(t, wantKWh, report.EnergyKWh, 1e-9)
+}
+
+func TestRun_DryRun(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	trace := Trace{{Time: start.Add(time.Hour), Device: "/dev/sda", Kind: EventStandby}}
+	end := start.Add(48 * time.Hour)
+
+	// the daemon drives the simulated disks through the same wrappers as the
+	// real ones: a dry run arms no timer
+	p := Policy{
+		Name:   "dry",
+		Config: daemon.Config{PollInterval: time.Minute, Cron: &config.CronExpr{Hour: 22, Min: 0}, StandbyValue: 120, DryRun: true},
+	}
+	report, err := Run(trace, p, start, end, power.Model{ActiveWatts: 5, StandbyWatts: 1})
+	require.NoError(t, err)
+	require.InDelta(t, 48, report.Devices[0].ActiveHours, 1e-9)
+	require.Zero(t, report.Devices[0].StandbyHours)
+}
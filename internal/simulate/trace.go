@@ -0,0 +1,96 @@
+package simulate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trace event kinds. A state-transition history only contributes its
+// "active" entries: standby transitions are decided by the simulated drive.
+const (
+	EventIO      = "io"
+	EventActive  = "active"
+	EventStandby = "standby"
+)
+
+// Event is a single recorded access or state transition of a device.
+type Event struct {
+	Time   time.Time
+	Device string
+	Kind   string
+}
+
+// Trace is a time-ordered list of recorded events.
+type Trace []Event
+
+// ParseTrace reads a trace with one event per line:
+//
+//	<timestamp> <device> [io|active|standby]
+//
+// Timestamps are RFC3339 or unix seconds. Blank lines and lines starting with
+// '#' are ignored. The kind defaults to "io".
+func ParseTrace(r io.Reader) (Trace, error) {
+	var trace Trace
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected '<timestamp> <device> [kind]', got %q", lineNo, line)
+		}
+		ts, err := parseTimestamp(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		kind := EventIO
+		if len(fields) == 3 {
+			kind = strings.ToLower(fields[2])
+		}
+		switch kind {
+		case EventIO, EventActive, EventStandby:
+		default:
+			return nil, fmt.Errorf("line %d: unknown event kind %q", lineNo, fields[2])
+		}
+		trace = append(trace, Event{Time: ts, Device: fields[1], Kind: kind})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(trace, func(i, j int) bool { return trace[i].Time.Before(trace[j].Time) })
+	return trace, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: expected RFC3339 or unix seconds", s)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), nil
+}
+
+// Devices returns the sorted set of devices referenced by the trace.
+func (t Trace) Devices() []string {
+	seen := make(map[string]bool)
+	var devs []string
+	for _, e := range t {
+		if !seen[e.Device] {
+			seen[e.Device] = true
+			devs = append(devs, e.Device)
+		}
+	}
+	sort.Strings(devs)
+	return devs
+}
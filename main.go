@@ -4,8 +4,16 @@ import (
 	"fmt"
 	"os"
 
+	doctorcmd "github.com/chain710/hd-smart-idle/cmd/doctor"
+	epccmd "github.com/chain710/hd-smart-idle/cmd/epc"
+	inhibitcmd "github.com/chain710/hd-smart-idle/cmd/inhibit"
+	listcmd "github.com/chain710/hd-smart-idle/cmd/list"
 	runcmd "github.com/chain710/hd-smart-idle/cmd/run"
+	simulatecmd "github.com/chain710/hd-smart-idle/cmd/simulate"
 	standbycmd "github.com/chain710/hd-smart-idle/cmd/standby"
+	statuscmd "github.com/chain710/hd-smart-idle/cmd/status"
+	wakecmd "github.com/chain710/hd-smart-idle/cmd/wake"
+	"github.com/chain710/hd-smart-idle/internal/config"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -35,8 +43,17 @@ func main() {
 	}
 
 	rootCmd.PersistentFlags().String(flagLogLevel, "info", "log level: debug|info|warn|error")
+	rootCmd.PersistentFlags().String(config.Flag, "", "path to YAML config file (e.g. USB bridge quirks)")
 	rootCmd.AddCommand(runcmd.NewRunCmd())
 	rootCmd.AddCommand(standbycmd.NewStandbyCmd())
+	rootCmd.AddCommand(simulatecmd.NewSimulateCmd())
+	rootCmd.AddCommand(epccmd.NewEPCCmd())
+	rootCmd.AddCommand(statuscmd.NewStatusCmd())
+	rootCmd.AddCommand(wakecmd.NewWakeCmd())
+	rootCmd.AddCommand(inhibitcmd.NewInhibitCmd())
+	rootCmd.AddCommand(listcmd.NewListCmd())
+	rootCmd.AddCommand(listcmd.NewInspectCmd())
+	rootCmd.AddCommand(doctorcmd.NewDoctorCmd())
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)